@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	tunasync "github.com/tuna/tunasync/internal"
+	"github.com/tuna/tunasync/manager"
+)
+
+// the database of the manager, as the manager would open it
+func managerDBConfig(c *cli.Context) (dbType, dbFile string, err error) {
+	cfg, err := manager.LoadConfig(c.String("config"), c)
+	if err != nil {
+		return "", "", fmt.Errorf("error loading config: %s", err.Error())
+	}
+	return cfg.Files.DBType, cfg.Files.DBFile, nil
+}
+
+func dumpManagerDB(c *cli.Context) error {
+	tunasync.InitLogger(c.Bool("verbose"), c.Bool("debug"), false)
+	dbType, dbFile, err := managerDBConfig(c)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	out := os.Stdout
+	if path := c.String("output"); path != "" && path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		defer f.Close()
+		out = f
+	}
+	stats, err := manager.DumpDB(dbType, dbFile, out)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Error dumping the %s database %s: %s", dbType, dbFile, err.Error()), 1)
+	}
+	if out != os.Stdout {
+		if err := out.Sync(); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Dumped %s\n", stats)
+	return nil
+}
+
+func restoreManagerDB(c *cli.Context) error {
+	tunasync.InitLogger(c.Bool("verbose"), c.Bool("debug"), false)
+	dbType, dbFile, err := managerDBConfig(c)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	in := os.Stdin
+	if path := c.String("input"); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		defer f.Close()
+		in = f
+	}
+	snapshot, err := manager.DecodeDBSnapshot(in)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Error reading the dump: %s", err.Error()), 1)
+	}
+	stats, err := manager.RestoreDB(dbType, dbFile, snapshot, c.Bool("force"))
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Error restoring the %s database %s: %s", dbType, dbFile, err.Error()), 1)
+	}
+	fmt.Printf("Restored and verified %s\n", stats)
+	return nil
+}
+
+func copyManagerDB(c *cli.Context) error {
+	tunasync.InitLogger(c.Bool("verbose"), c.Bool("debug"), false)
+	dbType, dbFile, err := managerDBConfig(c)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	toType, toFile := c.String("to-type"), c.String("to-file")
+	if toType == dbType && toFile == dbFile {
+		return cli.Exit("The source and the destination are the same database", 1)
+	}
+
+	stats, err := manager.CopyDB(dbType, dbFile, toType, toFile, c.Bool("force"))
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Error copying the %s database %s to the %s database %s: %s",
+			dbType, dbFile, toType, toFile, err.Error()), 1)
+	}
+	fmt.Printf("Copied and verified %s\n", stats)
+	return nil
+}
+
+var managerDBCommand = &cli.Command{
+	Name:  "manager-db",
+	Usage: "dump, restore or copy the database of the tunasync manager",
+	Description: "The manager should be stopped while its database is restored or copied.\n" +
+		"The database is taken from the manager config, unless --db-type and --db-file are given.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Aliases: []string{"c"},
+			Usage:   "Load manager configurations from `FILE`",
+		},
+		&cli.StringFlag{
+			Name:  "db-file",
+			Usage: "Use `FILE` as the database file",
+		},
+		&cli.StringFlag{
+			Name:  "db-type",
+			Usage: "Use database type `TYPE`",
+		},
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "Enable verbose logging",
+		},
+		&cli.BoolFlag{
+			Name:  "debug",
+			Usage: "Enable debug logging",
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:   "dump",
+			Usage:  "Dump the database as newline-delimited JSON",
+			Action: dumpManagerDB,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "output",
+					Aliases: []string{"o"},
+					Usage:   "Write the dump to `FILE` instead of stdout",
+				},
+			},
+		},
+		{
+			Name:   "restore",
+			Usage:  "Restore a dump into the database and verify it",
+			Action: restoreManagerDB,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "input",
+					Aliases: []string{"i"},
+					Usage:   "Read the dump from `FILE` instead of stdin",
+				},
+				&cli.BoolFlag{
+					Name:  "force",
+					Usage: "Restore into a database which is not empty",
+				},
+			},
+		},
+		{
+			Name:   "copy",
+			Usage:  "Copy the database into another one and verify it",
+			Action: copyManagerDB,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "to-type",
+					Usage:    "Copy to a database of type `TYPE`",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "to-file",
+					Usage:    "Copy to the database `FILE`",
+					Required: true,
+				},
+				&cli.BoolFlag{
+					Name:  "force",
+					Usage: "Copy into a database which is not empty",
+				},
+			},
+		},
+	},
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
@@ -25,6 +26,9 @@ var (
 
 var logger = tunasync.MustGetLogger("tunasync")
 
+// how long the ongoing requests are waited for on shutdown
+const managerShutdownTimeout = 30 * time.Second
+
 func startManager(c *cli.Context) error {
 	tunasync.InitLogger(c.Bool("verbose"), c.Bool("debug"), c.Bool("with-systemd"))
 
@@ -43,6 +47,31 @@ func startManager(c *cli.Context) error {
 		os.Exit(1)
 	}
 
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+		for s := range sigChan {
+			switch s {
+			case syscall.SIGHUP:
+				logger.Info("Received reload signal")
+				newCfg, err := manager.LoadConfig(c.String("config"), c)
+				if err != nil {
+					logger.Errorf("Error loading config: %s", err.Error())
+				} else if err := m.Reload(newCfg); err != nil {
+					logger.Errorf("Error reloading config: %s", err.Error())
+				}
+			case syscall.SIGINT, syscall.SIGTERM:
+				logger.Info("Shutting down tunasync manager server.")
+				ctx, cancel := context.WithTimeout(context.Background(), managerShutdownTimeout)
+				if err := m.Shutdown(ctx); err != nil {
+					logger.Errorf("Error shutting down: %s", err.Error())
+				}
+				cancel()
+				return
+			}
+		}
+	}()
+
 	logger.Info("Run tunasync manager server.")
 	m.Run()
 	return nil
@@ -93,6 +122,8 @@ func startWorker(c *cli.Context) error {
 				newCfg, err := worker.LoadConfig(c.String("config"))
 				if err != nil {
 					logger.Errorf("Error loading config: %s", err.Error())
+				} else if err := w.ReloadConcurrencyGroups(newCfg.ConcurrencyGroups); err != nil {
+					logger.Errorf("Error reloading concurrency groups: %s", err.Error())
 				} else {
 					w.ReloadMirrorConfig(newCfg.Mirrors)
 				}
@@ -171,6 +202,10 @@ func main() {
 					Name:  "db-file",
 					Usage: "Use `FILE` as the database file",
 				},
+				&cli.StringFlag{
+					Name:  "status-file",
+					Usage: "Write the mirror status to `FILE`",
+				},
 				&cli.StringFlag{
 					Name:  "db-type",
 					Usage: "Use database type `TYPE`",
@@ -231,6 +266,7 @@ func main() {
 				},
 			},
 		},
+		managerDBCommand,
 	}
 	app.Run(os.Args)
 }
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"text/template"
 	"time"
 
@@ -25,8 +27,19 @@ var (
 const (
 	listJobsPath      = "/jobs"
 	listWorkersPath   = "/workers"
+	listMirrorsPath   = "/mirrors"
 	flushDisabledPath = "/jobs/disabled"
 	cmdPath           = "/cmd"
+	auditPath         = "/audit"
+	cmdQueuePath      = "/cmd/queue"
+	bulkCmdPath       = "/cmd/bulk"
+	waitingJobsPath   = "/jobs/waiting"
+	workerWaitingPath = "/workers/%s/waiting"
+	jobLogPath        = "/workers/%s/jobs/%s/log"
+	jobLogListPath    = "/workers/%s/jobs/%s/logs"
+
+	// the header holding the cursor of the next page of jobs
+	nextCursorHeader = "X-Next-Cursor"
 
 	systemCfgFile = "/etc/tunasync/ctl.conf"          // system-wide conf
 	userCfgFile   = "$HOME/.config/tunasync/ctl.conf" // user-specific conf
@@ -51,6 +64,19 @@ type config struct {
 	ManagerAddr string `toml:"manager_addr"`
 	ManagerPort int    `toml:"manager_port"`
 	CACert      string `toml:"ca_cert"`
+	Token       string `toml:"token"`
+}
+
+// authTransport attaches the admin token to every request
+type authTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	tunasync.SetBearerToken(req, t.token)
+	return t.base.RoundTrip(req)
 }
 
 func loadConfig(cfgFile string, cfg *config) error {
@@ -107,6 +133,9 @@ func initialize(c *cli.Context) error {
 	if c.String("ca-cert") != "" {
 		cfg.CACert = c.String("ca-cert")
 	}
+	if c.String("token") != "" {
+		cfg.Token = c.String("token")
+	}
 
 	// parse base url of the manager server
 	if cfg.CACert != "" {
@@ -126,6 +155,13 @@ func initialize(c *cli.Context) error {
 		return err
 
 	}
+	if cfg.Token != "" {
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		client.Transport = &authTransport{token: cfg.Token, base: base}
+	}
 	return nil
 }
 
@@ -151,34 +187,91 @@ func listWorkers(c *cli.Context) error {
 
 func listJobs(c *cli.Context) error {
 	var genericJobs interface{}
+	query := url.Values{}
+	// stale is not a sync status but judged by the manager,
+	// so the jobs are filtered here when it is asked for
+	stale := false
+	var statuses []tunasync.SyncStatus
+	if statusStr := c.String("status"); statusStr != "" {
+		for _, s := range strings.Split(statusStr, ",") {
+			if strings.TrimSpace(s) == "stale" {
+				stale = true
+				continue
+			}
+			var status tunasync.SyncStatus
+			err := status.UnmarshalJSON([]byte("\"" + strings.TrimSpace(s) + "\""))
+			if err != nil {
+				return cli.Exit(
+					fmt.Sprintf("Error parsing status: %s", err.Error()),
+					1)
+			}
+			statuses = append(statuses, status)
+		}
+		if !stale {
+			query.Set("status", statusStr)
+		}
+	}
+	for _, f := range []string{"name", "stale-since", "sort"} {
+		if v := c.String(f); v != "" {
+			query.Set(strings.ReplaceAll(f, "-", "_"), v)
+		}
+	}
+	if c.IsSet("master") {
+		query.Set("master", strconv.FormatBool(c.Bool("master")))
+	}
+	if limit := c.Int("limit"); limit > 0 {
+		// the page cut by the manager would be filtered again
+		if stale {
+			return cli.Exit("Usage Error: --limit can not be used with the stale status", 1)
+		}
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if cursor := c.String("cursor"); cursor != "" {
+		if !c.Bool("all") && c.NArg() != 1 {
+			return cli.Exit("Usage Error: --cursor is used with --all or one worker", 1)
+		}
+		query.Set("cursor", cursor)
+	}
+	// tells how to get the next page, if any
+	printNextCursor := func(resp *http.Response) {
+		if resp == nil {
+			return
+		}
+		if next := resp.Header.Get(nextCursorHeader); next != "" {
+			fmt.Fprintf(os.Stderr, "More jobs with --cursor %s\n", next)
+		}
+	}
+	wanted := func(status tunasync.SyncStatus, isStale bool) bool {
+		if isStale {
+			return true
+		}
+		for _, s := range statuses {
+			if status == s {
+				return true
+			}
+		}
+		return false
+	}
+	var params string
+	if len(query) > 0 {
+		params = "?" + query.Encode()
+	}
+
 	if c.Bool("all") {
 		var jobs []tunasync.WebMirrorStatus
-		_, err := tunasync.GetJSON(baseURL+listJobsPath, &jobs, client)
+		resp, err := tunasync.GetJSON(baseURL+listJobsPath+params, &jobs, client)
 		if err != nil {
 			return cli.Exit(
 				fmt.Sprintf("Failed to correctly get information "+
 					"of all jobs from manager server: %s", err.Error()),
 				1)
 		}
-		if statusStr := c.String("status"); statusStr != "" {
+		printNextCursor(resp)
+		if stale {
 			filteredJobs := make([]tunasync.WebMirrorStatus, 0, len(jobs))
-			var statuses []tunasync.SyncStatus
-			for _, s := range strings.Split(statusStr, ",") {
-				var status tunasync.SyncStatus
-				err = status.UnmarshalJSON([]byte("\"" + strings.TrimSpace(s) + "\""))
-				if err != nil {
-					return cli.Exit(
-						fmt.Sprintf("Error parsing status: %s", err.Error()),
-						1)
-				}
-				statuses = append(statuses, status)
-			}
 			for _, job := range jobs {
-				for _, s := range statuses {
-					if job.Status == s {
-						filteredJobs = append(filteredJobs, job)
-						break
-					}
+				if wanted(job.Status, job.Stale) {
+					filteredJobs = append(filteredJobs, job)
 				}
 			}
 			genericJobs = filteredJobs
@@ -197,11 +290,13 @@ func listJobs(c *cli.Context) error {
 		for _, workerID := range args {
 			go func(workerID string) {
 				var workerJobs []tunasync.MirrorStatus
-				_, err := tunasync.GetJSON(fmt.Sprintf("%s/workers/%s/jobs",
-					baseURL, workerID), &workerJobs, client)
+				resp, err := tunasync.GetJSON(fmt.Sprintf("%s/workers/%s/jobs%s",
+					baseURL, workerID, params), &workerJobs, client)
 				if err != nil {
 					logger.Infof("Failed to correctly get jobs"+
 						" for worker %s: %s", workerID, err.Error())
+				} else if len(args) == 1 {
+					printNextCursor(resp)
 				}
 				ans <- workerJobs
 			}(workerID)
@@ -216,6 +311,15 @@ func listJobs(c *cli.Context) error {
 			}
 			jobs = append(jobs, job...)
 		}
+		if stale {
+			filteredJobs := make([]tunasync.MirrorStatus, 0, len(jobs))
+			for _, job := range jobs {
+				if wanted(job.Status, job.Stale) {
+					filteredJobs = append(filteredJobs, job)
+				}
+			}
+			jobs = filteredJobs
+		}
 		genericJobs = jobs
 	}
 
@@ -262,6 +366,58 @@ func listJobs(c *cli.Context) error {
 	return nil
 }
 
+func listMirrors(c *cli.Context) error {
+	var mirrors []tunasync.WebMirrorGroup
+	_, err := tunasync.GetJSON(baseURL+listMirrorsPath, &mirrors, client)
+	if err != nil {
+		return cli.Exit(
+			fmt.Sprintf("Failed to correctly get information "+
+				"of mirrors from manager server: %s", err.Error()),
+			1)
+	}
+	if names := c.Args().Slice(); len(names) > 0 {
+		wanted := make(map[string]bool)
+		for _, name := range names {
+			wanted[name] = true
+		}
+		filtered := make([]tunasync.WebMirrorGroup, 0, len(names))
+		for _, m := range mirrors {
+			if wanted[m.Name] {
+				filtered = append(filtered, m)
+			}
+		}
+		mirrors = filtered
+	}
+
+	if format := c.String("format"); format != "" {
+		tpl := template.New("")
+		_, err := tpl.Parse(format)
+		if err != nil {
+			return cli.Exit(
+				fmt.Sprintf("Error parsing format template: %s", err.Error()),
+				1)
+		}
+		for _, m := range mirrors {
+			err = tpl.Execute(os.Stdout, m)
+			if err != nil {
+				return cli.Exit(
+					fmt.Sprintf("Error printing out information: %s", err.Error()),
+					1)
+			}
+			fmt.Println()
+		}
+	} else {
+		b, err := json.MarshalIndent(mirrors, "", "  ")
+		if err != nil {
+			return cli.Exit(
+				fmt.Sprintf("Error printing out information: %s", err.Error()),
+				1)
+		}
+		fmt.Println(string(b))
+	}
+	return nil
+}
+
 func updateMirrorSize(c *cli.Context) error {
 	args := c.Args().Slice()
 	if len(args) != 2 {
@@ -391,8 +547,392 @@ func flushDisabledJobs(c *cli.Context) error {
 	return nil
 }
 
+func listAuditRecords(c *cli.Context) error {
+	query := url.Values{}
+	for param, flag := range map[string]string{
+		"worker":   "worker",
+		"mirror":   "mirror",
+		"identity": "user",
+		"action":   "action",
+		"since":    "since",
+		"until":    "until",
+	} {
+		if v := c.String(flag); v != "" {
+			query.Set(param, v)
+		}
+	}
+	query.Set("limit", strconv.Itoa(c.Int("limit")))
+
+	var records []tunasync.AuditRecord
+	resp, err := tunasync.GetJSON(baseURL+auditPath+"?"+query.Encode(), &records, client)
+	if err != nil {
+		if resp != nil {
+			err = fmt.Errorf("%s: %s", err.Error(), resp.Status)
+		}
+		return cli.Exit(
+			fmt.Sprintf("Failed to get the audit log from manager server: %s",
+				err.Error()),
+			1)
+	}
+
+	if format := c.String("format"); format != "" {
+		tpl, err := template.New("").Parse(format)
+		if err != nil {
+			return cli.Exit(
+				fmt.Sprintf("Error parsing format template: %s", err.Error()),
+				1)
+		}
+		for _, r := range records {
+			if err := tpl.Execute(os.Stdout, r); err != nil {
+				return cli.Exit(
+					fmt.Sprintf("Error printing out information: %s", err.Error()),
+					1)
+			}
+			fmt.Println()
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return cli.Exit(
+			fmt.Sprintf("Error printing out information: %s", err.Error()),
+			1)
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func listQueuedCmds(c *cli.Context) error {
+	query := url.Values{}
+	if w := c.String("worker"); w != "" {
+		query.Set("worker", w)
+	}
+	var qs []tunasync.QueuedCmd
+	resp, err := tunasync.GetJSON(baseURL+cmdQueuePath+"?"+query.Encode(), &qs, client)
+	if err != nil {
+		if resp != nil {
+			err = fmt.Errorf("%s: %s", err.Error(), resp.Status)
+		}
+		return cli.Exit(
+			fmt.Sprintf("Failed to get the queued commands from manager server: %s",
+				err.Error()),
+			1)
+	}
+
+	if format := c.String("format"); format != "" {
+		tpl, err := template.New("").Parse(format)
+		if err != nil {
+			return cli.Exit(
+				fmt.Sprintf("Error parsing format template: %s", err.Error()),
+				1)
+		}
+		for _, q := range qs {
+			if err := tpl.Execute(os.Stdout, q); err != nil {
+				return cli.Exit(
+					fmt.Sprintf("Error printing out information: %s", err.Error()),
+					1)
+			}
+			fmt.Println()
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(qs, "", "  ")
+	if err != nil {
+		return cli.Exit(
+			fmt.Sprintf("Error printing out information: %s", err.Error()),
+			1)
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func listWaitingJobs(c *cli.Context) error {
+	var lists []tunasync.WaitingJobs
+	var err error
+	if workerID := c.String("worker"); workerID != "" {
+		var list tunasync.WaitingJobs
+		_, err = tunasync.GetJSON(baseURL+fmt.Sprintf(workerWaitingPath, url.PathEscape(workerID)), &list, client)
+		lists = append(lists, list)
+	} else {
+		_, err = tunasync.GetJSON(baseURL+waitingJobsPath, &lists, client)
+	}
+	if err != nil {
+		return cli.Exit(
+			fmt.Sprintf("Failed to get the jobs waiting from manager server: %s",
+				err.Error()),
+			1)
+	}
+
+	if format := c.String("format"); format != "" {
+		tpl, err := template.New("").Parse(format)
+		if err != nil {
+			return cli.Exit(
+				fmt.Sprintf("Error parsing format template: %s", err.Error()),
+				1)
+		}
+		for _, list := range lists {
+			if err := tpl.Execute(os.Stdout, list); err != nil {
+				return cli.Exit(
+					fmt.Sprintf("Error printing out information: %s", err.Error()),
+					1)
+			}
+			fmt.Println()
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(lists, "", "  ")
+	if err != nil {
+		return cli.Exit(
+			fmt.Sprintf("Error printing out information: %s", err.Error()),
+			1)
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func cancelQueuedCmds(c *cli.Context) error {
+	workerID := c.String("worker")
+	if workerID == "" || c.NArg() == 0 {
+		return cli.Exit("Usage Error: queue cancel -w <worker-id> ID...", 1)
+	}
+	for _, id := range c.Args().Slice() {
+		req, err := http.NewRequest("DELETE",
+			baseURL+cmdQueuePath+"/"+url.PathEscape(workerID)+"/"+url.PathEscape(id), nil)
+		if err != nil {
+			logger.Panicf("Invalid  HTTP Request: %s", err.Error())
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return cli.Exit(
+				fmt.Sprintf("Failed to send request to manager: %s", err.Error()), 1)
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return cmdError(resp)
+		}
+		resp.Body.Close()
+		fmt.Printf("Successfully cancelled the queued command %s\n", id)
+	}
+	return nil
+}
+
+func showJobLogs(c *cli.Context) error {
+	workerID := c.String("worker")
+	if workerID == "" || c.NArg() != 1 {
+		return cli.Exit("Usage Error: logs -w <worker-id> MIRROR", 1)
+	}
+	mirrorID := c.Args().Get(0)
+
+	if c.Bool("list") {
+		var files []tunasync.JobLogFile
+		listURL := baseURL + fmt.Sprintf(jobLogListPath, url.PathEscape(workerID), url.PathEscape(mirrorID))
+		resp, err := tunasync.GetJSON(listURL, &files, client)
+		if err != nil {
+			if resp != nil {
+				defer resp.Body.Close()
+				return logsError(resp)
+			}
+			return cli.Exit(
+				fmt.Sprintf("Failed to get the logs from manager server: %s", err.Error()),
+				1)
+		}
+		b, err := json.MarshalIndent(files, "", "  ")
+		if err != nil {
+			return cli.Exit(
+				fmt.Sprintf("Error printing out information: %s", err.Error()),
+				1)
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	query := url.Values{}
+	if f := c.String("file"); f != "" {
+		query.Set("file", f)
+	}
+	if n := c.Int("tail"); n > 0 {
+		query.Set("tail", strconv.Itoa(n))
+	}
+	// the followed logs are streamed for as long as the sync runs
+	streamClient := *client
+	if c.Bool("follow") {
+		query.Set("follow", "true")
+		streamClient.Timeout = 0
+	}
+	logURL := baseURL + fmt.Sprintf(jobLogPath, url.PathEscape(workerID), url.PathEscape(mirrorID))
+	resp, err := streamClient.Get(logURL + "?" + query.Encode())
+	if err != nil {
+		return cli.Exit(
+			fmt.Sprintf("Failed to send request to manager: %s", err.Error()), 1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return logsError(resp)
+	}
+	logger.Infof("Showing log %s", resp.Header.Get(tunasync.LogFileHeader))
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		return cli.Exit(fmt.Sprintf("Failed to read the log: %s", err.Error()), 1)
+	}
+	return nil
+}
+
+// logsError tells why the logs are not got, the errors come
+// from either the manager or the worker
+func logsError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	res := map[string]string{}
+	msg := strings.TrimSpace(string(body))
+	if err := json.Unmarshal(body, &res); err == nil {
+		if res["error"] != "" {
+			msg = res["error"]
+		} else if res["msg"] != "" {
+			msg = res["msg"]
+		}
+	}
+	return cli.Exit(fmt.Sprintf("Failed to get the logs: %s (HTTP status code %d)", msg, resp.StatusCode), 1)
+}
+
+// cmdError tells why the command failed, as relayed by the manager
+// from the worker if it is not accepted there
+func cmdError(resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return cli.Exit(
+			fmt.Sprintf("Failed to parse response: %s", err.Error()),
+			1)
+	}
+	res := map[string]string{}
+	if err := json.Unmarshal(body, &res); err == nil && res["error"] != "" {
+		return cli.Exit(fmt.Sprintf("Failed to correctly send"+
+			" command: %s (HTTP status code %d)", res["error"], resp.StatusCode),
+			1)
+	}
+	return cli.Exit(fmt.Sprintf("Failed to correctly send"+
+		" command: HTTP status code is not 200: %s", body),
+		1)
+}
+
+// isBulkCmd tells whether the command is for the jobs selected
+// rather than a single mirror
+func isBulkCmd(c *cli.Context) bool {
+	for _, f := range []string{"all", "status", "older-than", "dry-run"} {
+		if c.IsSet(f) {
+			return true
+		}
+	}
+	return strings.ContainsAny(c.Args().First(), "*?[,") ||
+		strings.Contains(c.String("worker"), ",")
+}
+
+// parseAge parses a duration like 12h, which can be in days like 2d
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// splitList splits a comma separated list, without the empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// cmdBulk sends the command to all the jobs selected by the mirror
+// name globs, the workers, the status and the age, and prints the
+// result on each of them
+func cmdBulk(c *cli.Context, cmd tunasync.CmdVerb, options map[string]bool) error {
+	args := c.Args().Slice()
+	if len(args) > 2 {
+		return cli.Exit("Usage Error: bulk command receive at most "+
+			"1 optional argument MIRROR and 1 optional argument ARGS", 1)
+	}
+	if options["master"] || options["all_workers"] {
+		return cli.Exit("Usage Error: --master and --all-workers are not "+
+			"for the bulk commands, which are sent to all the jobs selected", 1)
+	}
+	bulkCmd := tunasync.BulkCmd{
+		Cmd: cmd,
+		Selector: tunasync.JobSelector{
+			Names:   splitList(c.Args().Get(0)),
+			Workers: splitList(c.String("worker")),
+			All:     c.Bool("all"),
+		},
+		Args:     splitList(c.Args().Get(1)),
+		Options:  options,
+		QueueTTL: int(c.Duration("queue").Seconds()),
+		DryRun:   c.Bool("dry-run"),
+	}
+	for _, s := range splitList(c.String("status")) {
+		var status tunasync.SyncStatus
+		if err := status.UnmarshalJSON([]byte("\"" + s + "\"")); err != nil {
+			return cli.Exit(fmt.Sprintf("Error parsing status: %s", err.Error()), 1)
+		}
+		bulkCmd.Selector.Statuses = append(bulkCmd.Selector.Statuses, status)
+	}
+	if v := c.String("older-than"); v != "" {
+		age, err := parseAge(v)
+		if err != nil || age <= 0 {
+			return cli.Exit(fmt.Sprintf("Error parsing older-than: %s", v), 1)
+		}
+		bulkCmd.Selector.OlderThan = int(age.Seconds())
+	}
+
+	resp, err := tunasync.PostJSON(baseURL+bulkCmdPath, bulkCmd, client)
+	if err != nil {
+		return cli.Exit(
+			fmt.Sprintf("Failed to correctly send command: %s",
+				err.Error()),
+			1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return cmdError(resp)
+	}
+	var results []tunasync.CmdResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return cli.Exit(fmt.Sprintf("Failed to parse response: %s", err.Error()), 1)
+	}
+	if len(results) == 0 {
+		fmt.Println("No job is selected")
+		return nil
+	}
+
+	failed := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MIRROR\tWORKER\tCODE\tMESSAGE")
+	for _, r := range results {
+		// 202 if the command is queued
+		if r.Code != http.StatusOK && r.Code != http.StatusAccepted {
+			failed++
+		}
+		msg := r.Message
+		if msg == "" {
+			msg = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", r.MirrorID, r.WorkerID, r.Code, msg)
+	}
+	tw.Flush()
+	if failed > 0 {
+		return cli.Exit(fmt.Sprintf("The command failed on %d of %d jobs", failed, len(results)), 1)
+	}
+	return nil
+}
+
 func cmdJob(cmd tunasync.CmdVerb) cli.ActionFunc {
 	return func(c *cli.Context) error {
+		if isBulkCmd(c) {
+			return cmdBulk(c, cmd, cmdOptions(c))
+		}
 		var mirrorID string
 		var argsList []string
 		args := c.Args().Slice()
@@ -409,16 +949,13 @@ func cmdJob(cmd tunasync.CmdVerb) cli.ActionFunc {
 				"argument WORKER", 1)
 		}
 
-		options := map[string]bool{}
-		if c.Bool("force") {
-			options["force"] = true
-		}
 		cmd := tunasync.ClientCmd{
 			Cmd:      cmd,
 			MirrorID: mirrorID,
 			WorkerID: c.String("worker"),
 			Args:     argsList,
-			Options:  options,
+			Options:  cmdOptions(c),
+			QueueTTL: int(c.Duration("queue").Seconds()),
 		}
 		resp, err := tunasync.PostJSON(baseURL+cmdPath, cmd, client)
 		if err != nil {
@@ -429,24 +966,37 @@ func cmdJob(cmd tunasync.CmdVerb) cli.ActionFunc {
 		}
 		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return cli.Exit(
-					fmt.Sprintf("Failed to parse response: %s", err.Error()),
-					1)
-			}
-
-			return cli.Exit(fmt.Sprintf("Failed to correctly send"+
-				" command: HTTP status code is not 200: %s", body),
-				1)
+		// 202 if the command is queued
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+			return cmdError(resp)
+		}
+		res := map[string]string{}
+		if err := json.NewDecoder(resp.Body).Decode(&res); err == nil && res["message"] != "" {
+			// tells which workers the command is sent or queued to
+			fmt.Println(res["message"])
+		} else {
+			fmt.Println("Successfully send the command")
 		}
-		fmt.Println("Successfully send the command")
 
 		return nil
 	}
 }
 
+// cmdOptions reads the options of a job command from the flags
+func cmdOptions(c *cli.Context) map[string]bool {
+	options := map[string]bool{}
+	if c.Bool("force") {
+		options["force"] = true
+	}
+	if c.Bool("master") {
+		options["master"] = true
+	}
+	if c.Bool("all-workers") {
+		options["all_workers"] = true
+	}
+	return options
+}
+
 func cmdWorker(cmd tunasync.CmdVerb) cli.ActionFunc {
 	return func(c *cli.Context) error {
 
@@ -457,6 +1007,7 @@ func cmdWorker(cmd tunasync.CmdVerb) cli.ActionFunc {
 		cmd := tunasync.ClientCmd{
 			Cmd:      cmd,
 			WorkerID: c.String("worker"),
+			QueueTTL: int(c.Duration("queue").Seconds()),
 		}
 		resp, err := tunasync.PostJSON(baseURL+cmdPath, cmd, client)
 		if err != nil {
@@ -467,17 +1018,14 @@ func cmdWorker(cmd tunasync.CmdVerb) cli.ActionFunc {
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode == http.StatusAccepted {
+			res := map[string]string{}
+			_ = json.NewDecoder(resp.Body).Decode(&res)
+			fmt.Println(res["message"])
+			return nil
+		}
 		if resp.StatusCode != http.StatusOK {
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return cli.Exit(
-					fmt.Sprintf("Failed to parse response: %s", err.Error()),
-					1)
-			}
-
-			return cli.Exit(fmt.Sprintf("Failed to correctly send"+
-				" command: HTTP status code is not 200: %s", body),
-				1)
+			return cmdError(resp)
 		}
 		fmt.Println("Successfully send the command")
 
@@ -534,6 +1082,10 @@ func main() {
 			Name:  "ca-cert",
 			Usage: "Trust root CA cert file `CERT`",
 		},
+		&cli.StringFlag{
+			Name:  "token",
+			Usage: "Authenticate to the manager with admin `TOKEN`",
+		},
 
 		&cli.BoolFlag{
 			Name:    "verbose",
@@ -549,14 +1101,53 @@ func main() {
 		&cli.StringFlag{
 			Name:    "worker",
 			Aliases: []string{"w"},
-			Usage:   "Send the command to `WORKER`",
+			Usage:   "Send the command to `WORKER`, or the comma separated workers of a bulk command",
+		},
+		&cli.DurationFlag{
+			Name:  "queue",
+			Usage: "Queue the command for `DURATION` if the worker is unreachable, e.g. 2h",
 		},
 	}
 
+	// used when the worker is not specified
+	routeFlags := []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "master",
+			Usage: "Send the command to the master worker of the mirror",
+		},
+		&cli.BoolFlag{
+			Name:  "all-workers",
+			Usage: "Send the command to all the workers hosting the mirror",
+		},
+	}
+	// select the jobs of a bulk command, as do the mirror name globs
+	// and the comma separated workers
+	selectFlags := []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "all",
+			Aliases: []string{"a"},
+			Usage:   "Send the command to all the jobs",
+		},
+		&cli.StringFlag{
+			Name:    "status",
+			Aliases: []string{"s"},
+			Usage:   "Send the command to the jobs of the comma separated `STATUS`, e.g. failed",
+		},
+		&cli.StringFlag{
+			Name:  "older-than",
+			Usage: "Send the command to the jobs not updated for `DURATION`, e.g. 12h or 2d",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only show the jobs selected",
+		},
+	}
+	jobCmdFlags := append(append(append(commonFlags, cmdFlags...), routeFlags...), selectFlags...)
+
 	forceStartFlag := &cli.BoolFlag{
 		Name:    "force",
 		Aliases: []string{"f"},
-		Usage:   "Override the concurrent limit",
+		Usage:   "Override the concurrent limit and the sync windows",
 	}
 
 	app.Commands = []*cli.Command{
@@ -573,7 +1164,31 @@ func main() {
 					&cli.StringFlag{
 						Name:    "status",
 						Aliases: []string{"s"},
-						Usage:   "Filter output based on status provided",
+						Usage:   "Filter output based on status provided, or stale for the stale mirrors",
+					},
+					&cli.StringFlag{
+						Name:  "name",
+						Usage: "Filter output based on a glob on the mirror names",
+					},
+					&cli.BoolFlag{
+						Name:  "master",
+						Usage: "Filter output based on whether the mirror is master, use --master=false for slaves",
+					},
+					&cli.StringFlag{
+						Name:  "stale-since",
+						Usage: "List the mirrors not updated since the time or duration like 24h",
+					},
+					&cli.StringFlag{
+						Name:  "sort",
+						Usage: "Sort by name, worker, last_update, last_started or last_ended, prefixed by - for the descending order",
+					},
+					&cli.IntFlag{
+						Name:  "limit",
+						Usage: "List at most this many jobs, the cursor of the next page is printed if any",
+					},
+					&cli.StringFlag{
+						Name:  "cursor",
+						Usage: "List the page after `CURSOR`, with the same other flags",
 					},
 					&cli.StringFlag{
 						Name:    "format",
@@ -583,6 +1198,19 @@ func main() {
 				}...),
 			Action: initializeWrapper(listJobs),
 		},
+		{
+			Name:      "mirrors",
+			Usage:     "List the status of mirrors on all their workers",
+			ArgsUsage: "[mirror...]",
+			Flags: append(commonFlags,
+				&cli.StringFlag{
+					Name:    "format",
+					Aliases: []string{"f"},
+					Usage:   "Pretty-print mirrors using a Go template",
+				},
+			),
+			Action: initializeWrapper(listMirrors),
+		},
 		{
 			Name:   "flush",
 			Usage:  "Flush disabled jobs",
@@ -621,28 +1249,61 @@ func main() {
 			),
 			Action: initializeWrapper(updateMirrorSize),
 		},
+		{
+			Name:      "logs",
+			Usage:     "Show the sync logs of a job on a worker",
+			ArgsUsage: "MIRROR",
+			Flags: append(commonFlags,
+				[]cli.Flag{
+					&cli.StringFlag{
+						Name:    "worker",
+						Aliases: []string{"w"},
+						Usage:   "The job is on `WORKER`",
+					},
+					&cli.BoolFlag{
+						Name:    "follow",
+						Aliases: []string{"f"},
+						Usage:   "Keep showing the new lines until the sync is over",
+					},
+					&cli.IntFlag{
+						Name:    "tail",
+						Aliases: []string{"n"},
+						Usage:   "Show only the last `N` lines",
+					},
+					&cli.StringFlag{
+						Name:  "file",
+						Usage: "Show the log `FILE` of an earlier sync instead of the latest one",
+					},
+					&cli.BoolFlag{
+						Name:    "list",
+						Aliases: []string{"l"},
+						Usage:   "List the log files of the job",
+					},
+				}...),
+			Action: initializeWrapper(showJobLogs),
+		},
 		{
 			Name:   "start",
 			Usage:  "Start a job",
-			Flags:  append(append(commonFlags, cmdFlags...), forceStartFlag),
+			Flags:  append(jobCmdFlags, forceStartFlag),
 			Action: initializeWrapper(cmdJob(tunasync.CmdStart)),
 		},
 		{
 			Name:   "stop",
 			Usage:  "Stop a job",
-			Flags:  append(commonFlags, cmdFlags...),
+			Flags:  jobCmdFlags,
 			Action: initializeWrapper(cmdJob(tunasync.CmdStop)),
 		},
 		{
 			Name:   "disable",
 			Usage:  "Disable a job",
-			Flags:  append(commonFlags, cmdFlags...),
+			Flags:  jobCmdFlags,
 			Action: initializeWrapper(cmdJob(tunasync.CmdDisable)),
 		},
 		{
 			Name:   "restart",
 			Usage:  "Restart a job",
-			Flags:  append(commonFlags, cmdFlags...),
+			Flags:  jobCmdFlags,
 			Action: initializeWrapper(cmdJob(tunasync.CmdRestart)),
 		},
 		{
@@ -653,9 +1314,105 @@ func main() {
 		},
 		{
 			Name:   "ping",
-			Flags:  append(commonFlags, cmdFlags...),
+			Flags:  jobCmdFlags,
 			Action: initializeWrapper(cmdJob(tunasync.CmdPing)),
 		},
+		{
+			Name:  "audit",
+			Usage: "List the administrative operations, newest first",
+			Flags: append(commonFlags,
+				[]cli.Flag{
+					&cli.StringFlag{
+						Name:    "worker",
+						Aliases: []string{"w"},
+						Usage:   "Only the operations on `WORKER`",
+					},
+					&cli.StringFlag{
+						Name:  "mirror",
+						Usage: "Only the operations on `MIRROR`",
+					},
+					&cli.StringFlag{
+						Name:    "user",
+						Aliases: []string{"u"},
+						Usage:   "Only the operations by the admin token `NAME`",
+					},
+					&cli.StringFlag{
+						Name:  "action",
+						Usage: "Only the `ACTION`, e.g. disable, delete_worker, flush_disabled or set_size",
+					},
+					&cli.StringFlag{
+						Name:  "since",
+						Usage: "Only the operations since `TIME`, in RFC3339 or unix timestamp",
+					},
+					&cli.StringFlag{
+						Name:  "until",
+						Usage: "Only the operations before `TIME`, in RFC3339 or unix timestamp",
+					},
+					&cli.IntFlag{
+						Name:    "limit",
+						Aliases: []string{"n"},
+						Value:   100,
+						Usage:   "List at most `N` records, 0 for all of them",
+					},
+					&cli.StringFlag{
+						Name:    "format",
+						Aliases: []string{"f"},
+						Usage:   "Pretty-print the records using a Go template",
+					},
+				}...),
+			Action: initializeWrapper(listAuditRecords),
+		},
+		{
+			Name:  "queue",
+			Usage: "List the commands queued for the unreachable workers",
+			Flags: append(commonFlags,
+				[]cli.Flag{
+					&cli.StringFlag{
+						Name:    "worker",
+						Aliases: []string{"w"},
+						Usage:   "Only the commands queued for `WORKER`",
+					},
+					&cli.StringFlag{
+						Name:    "format",
+						Aliases: []string{"f"},
+						Usage:   "Pretty-print the commands using a Go template",
+					},
+				}...),
+			Action: initializeWrapper(listQueuedCmds),
+			Subcommands: []*cli.Command{
+				{
+					Name:      "cancel",
+					Usage:     "Cancel the queued commands before they are delivered",
+					ArgsUsage: "ID...",
+					Flags: append(commonFlags,
+						&cli.StringFlag{
+							Name:    "worker",
+							Aliases: []string{"w"},
+							Usage:   "The commands are queued for `WORKER`",
+						},
+					),
+					Action: initializeWrapper(cancelQueuedCmds),
+				},
+			},
+		},
+		{
+			Name:  "waiting",
+			Usage: "List the jobs waiting for a concurrency slot on the workers",
+			Flags: append(commonFlags,
+				[]cli.Flag{
+					&cli.StringFlag{
+						Name:    "worker",
+						Aliases: []string{"w"},
+						Usage:   "Only the jobs waiting on `WORKER`",
+					},
+					&cli.StringFlag{
+						Name:    "format",
+						Aliases: []string{"f"},
+						Usage:   "Pretty-print the jobs waiting on each worker using a Go template",
+					},
+				}...),
+			Action: initializeWrapper(listWaitingJobs),
+		},
 	}
 	app.Run(os.Args)
 }
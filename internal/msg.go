@@ -21,6 +21,77 @@ type MirrorStatus struct {
 	Upstream    string     `json:"upstream"`
 	Size        string     `json:"size"`
 	ErrorMsg    string     `json:"error_msg"`
+	Interval    int        `json:"interval"`           // sync interval in minutes
+	Deferred    string     `json:"deferred,omitempty"` // why the next sync waits for its windows
+	Stale       bool       `json:"stale"`              // filled in by the manager on listing
+}
+
+// A SyncRecord is the history record of one sync run
+// of a mirror on a worker
+type SyncRecord struct {
+	Name     string     `json:"name"`
+	Worker   string     `json:"worker"`
+	Status   SyncStatus `json:"status"`
+	Started  time.Time  `json:"started"`
+	Ended    time.Time  `json:"ended"`
+	Size     string     `json:"size"`
+	ErrorMsg string     `json:"error_msg"`
+}
+
+// A JobLogFile is a log file of a sync run of a mirror on a worker
+type JobLogFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Failed  bool      `json:"failed"` // the run has failed
+	Latest  bool      `json:"latest"` // the log of the latest run
+}
+
+// An AuditRecord records an administrative operation on the manager
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Source   string    `json:"source"`             // address of the client
+	Identity string    `json:"identity,omitempty"` // name of the admin token
+	WorkerID string    `json:"worker_id,omitempty"`
+	MirrorID string    `json:"mirror_id,omitempty"`
+	Args     []string  `json:"args,omitempty"`
+	Code     int       `json:"code"`    // the status code responded
+	Message  string    `json:"message"` // the response, of the worker if any
+}
+
+// An EventType is the kind of a status event
+type EventType string
+
+const (
+	// EventJobStatus is sent when the sync status of a job changes
+	EventJobStatus EventType = "job_status"
+	// EventJobSize is sent when the size of a mirror is updated
+	EventJobSize EventType = "job_size"
+	// EventJobSchedule is sent when a job is rescheduled
+	EventJobSchedule EventType = "job_schedule"
+	// EventWorkerRegistered is sent when a worker registers
+	EventWorkerRegistered EventType = "worker_registered"
+	// EventWorkerDeleted is sent when a worker is deleted
+	EventWorkerDeleted EventType = "worker_deleted"
+	// EventWorkerOffline is sent when a worker is not heard from
+	// for longer than the offline timeout
+	EventWorkerOffline EventType = "worker_offline"
+	// EventWorkerOnline is sent when an offline worker comes back
+	EventWorkerOnline EventType = "worker_online"
+	// EventReset tells the client that some events are lost,
+	// and the full status should be fetched again
+	EventReset EventType = "reset"
+)
+
+// An Event is a status transition pushed to the clients
+type Event struct {
+	ID     uint64        `json:"id"`
+	Type   EventType     `json:"type"`
+	Time   time.Time     `json:"time"`
+	Worker string        `json:"worker,omitempty"`
+	Mirror string        `json:"mirror,omitempty"`
+	Status *MirrorStatus `json:"status,omitempty"`
 }
 
 // A WorkerStatus is the information struct that describe
@@ -31,6 +102,10 @@ type WorkerStatus struct {
 	Token        string    `json:"token"`         // session token
 	LastOnline   time.Time `json:"last_online"`   // last seen
 	LastRegister time.Time `json:"last_register"` // last register time
+	Online       bool      `json:"online"`        // filled in by the manager on listing
+	// the worker polls the manager for the commands instead of
+	// receiving them at the url
+	Pull bool `json:"pull,omitempty"`
 }
 
 type MirrorSchedules struct {
@@ -40,6 +115,37 @@ type MirrorSchedules struct {
 type MirrorSchedule struct {
 	MirrorName   string    `json:"name"`
 	NextSchedule time.Time `json:"next_schedule"`
+	// why the job waits for its sync windows, empty if it does not
+	Deferred string `json:"deferred,omitempty"`
+}
+
+// A WaitingJob is a job waiting for a concurrency slot on a worker
+type WaitingJob struct {
+	Name     string    `json:"name"`
+	Group    string    `json:"group,omitempty"` // the concurrency group
+	Priority int       `json:"priority"`
+	Due      time.Time `json:"due"`   // when the sync was due
+	Since    time.Time `json:"since"` // when the job started waiting
+}
+
+// WaitingJobs are the jobs waiting on a worker, in the order
+// they are admitted
+type WaitingJobs struct {
+	Worker  string `json:"worker"`
+	Running int    `json:"running"`
+	Limit   int    `json:"limit"`
+	// the slots of the concurrency groups declared
+	Groups []ConcurrencyGroup `json:"groups,omitempty"`
+	Jobs   []WaitingJob       `json:"jobs"`
+	// when the worker reported them, set by the manager
+	Updated time.Time `json:"updated"`
+}
+
+// A ConcurrencyGroup limits the jobs of it syncing at once on a worker
+type ConcurrencyGroup struct {
+	Name    string `json:"name"`
+	Running int    `json:"running"`
+	Limit   int    `json:"limit"`
 }
 
 // A CmdVerb is an action to a job or worker
@@ -127,4 +233,64 @@ type ClientCmd struct {
 	WorkerID string          `json:"worker_id"`
 	Args     []string        `json:"args"`
 	Options  map[string]bool `json:"options"`
+	// seconds to keep the command queued if the worker is unreachable,
+	// the command is not queued if it is zero
+	QueueTTL int `json:"queue_ttl,omitempty"`
+}
+
+// A PulledCmd is a command received by a worker polling the manager
+type PulledCmd struct {
+	ID  string    `json:"id"`
+	Cmd WorkerCmd `json:"cmd"`
+}
+
+// A CmdReply is the reply of a worker to a pulled command,
+// the same as it responds to a command posted to it
+type CmdReply struct {
+	Code    int    `json:"code"`
+	Message string `json:"msg"`
+}
+
+// A QueuedCmd is a command kept by the manager until the worker
+// is reachable again
+type QueuedCmd struct {
+	ID       string    `json:"id"`
+	WorkerID string    `json:"worker_id"`
+	Cmd      WorkerCmd `json:"cmd"`
+	Identity string    `json:"identity,omitempty"` // name of the admin token
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+}
+
+// A JobSelector selects the jobs of a bulk command,
+// a job is selected if it matches all the fields given
+type JobSelector struct {
+	Names    []string     `json:"names,omitempty"` // globs on the mirror names
+	Statuses []SyncStatus `json:"statuses,omitempty"`
+	Workers  []string     `json:"workers,omitempty"`
+	// not updated for this many seconds
+	OlderThan int `json:"older_than,omitempty"`
+	// select all the jobs if nothing else is given
+	All bool `json:"all,omitempty"`
+}
+
+// A BulkCmd is the command message send from client to the
+// manager for all the jobs selected
+type BulkCmd struct {
+	Cmd      CmdVerb         `json:"cmd"`
+	Selector JobSelector     `json:"selector"`
+	Args     []string        `json:"args"`
+	Options  map[string]bool `json:"options"`
+	QueueTTL int             `json:"queue_ttl,omitempty"`
+	// only tell the jobs selected
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// A CmdResult is the outcome of a command on a worker
+type CmdResult struct {
+	WorkerID string `json:"worker_id"`
+	MirrorID string `json:"mirror_id"`
+	// the HTTP status code, 200 if the worker has accepted the command
+	Code    int    `json:"code"`
+	Message string `json:"message"`
 }
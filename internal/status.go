@@ -16,6 +16,8 @@ const (
 	PreSyncing
 	Paused
 	Disabled
+	// Unknown is shown for the mirrors of offline workers
+	Unknown
 )
 
 func (s SyncStatus) String() string {
@@ -34,6 +36,8 @@ func (s SyncStatus) String() string {
 		return "paused"
 	case Disabled:
 		return "disabled"
+	case Unknown:
+		return "unknown"
 	default:
 		return ""
 	}
@@ -65,6 +69,8 @@ func (s *SyncStatus) UnmarshalJSON(v []byte) error {
 		*s = Paused
 	case `"disabled"`:
 		*s = Disabled
+	case `"unknown"`:
+		*s = Unknown
 	default:
 		return fmt.Errorf("Invalid status value: %s", string(v))
 	}
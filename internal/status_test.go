@@ -19,5 +19,9 @@ func TestSyncStatus(t *testing.T) {
 		err = json.Unmarshal([]byte(`"failed"`), &s)
 		So(err, ShouldBeNil)
 		So(s, ShouldEqual, Failed)
+
+		err = json.Unmarshal([]byte(`"unknown"`), &s)
+		So(err, ShouldBeNil)
+		So(s, ShouldEqual, Unknown)
 	})
 }
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"encoding/json"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -51,6 +52,9 @@ type WebMirrorStatus struct {
 	ScheduledTs   stampTime  `json:"next_schedule_ts"`
 	Upstream      string     `json:"upstream"`
 	Size          string     `json:"size"` // approximate size
+	Interval      int        `json:"interval"`
+	Deferred      string     `json:"deferred,omitempty"`
+	Stale         bool       `json:"stale"`
 }
 
 func BuildWebMirrorStatus(m MirrorStatus) WebMirrorStatus {
@@ -68,5 +72,103 @@ func BuildWebMirrorStatus(m MirrorStatus) WebMirrorStatus {
 		ScheduledTs:   stampTime{m.Scheduled},
 		Upstream:      m.Upstream,
 		Size:          m.Size,
+		Interval:      m.Interval,
+		Deferred:      m.Deferred,
+		Stale:         m.Stale,
 	}
 }
+
+// WebMirrorReplica is the status of a mirror on one of its workers
+type WebMirrorReplica struct {
+	Worker       string     `json:"worker"`
+	IsMaster     bool       `json:"is_master"`
+	Status       SyncStatus `json:"status"`
+	Stale        bool       `json:"stale"`
+	LastUpdate   textTime   `json:"last_update"`
+	LastUpdateTs stampTime  `json:"last_update_ts"`
+	// seconds behind the last update of the shown replica, negative
+	// when ahead of it, and null when either has never been updated
+	Lag      *int64 `json:"lag"`
+	Size     string `json:"size"`
+	ErrorMsg string `json:"error_msg,omitempty"`
+}
+
+// WebMirrorGroup is the status of a mirror on all its workers, the
+// status of the master is shown as the status of the mirror, or of the
+// latest updated replica if there is no master
+type WebMirrorGroup struct {
+	WebMirrorStatus
+	Master        string             `json:"master"` // empty if there is no master
+	SizeMismatch  bool               `json:"size_mismatch"`
+	FailedWorkers []string           `json:"failed_workers"`
+	Replicas      []WebMirrorReplica `json:"replicas"`
+}
+
+// BuildWebMirrorGroups groups the mirror status by mirror name,
+// sorted by the names
+func BuildWebMirrorGroups(ms []MirrorStatus) []WebMirrorGroup {
+	byName := make(map[string][]MirrorStatus)
+	var names []string
+	for _, m := range ms {
+		if _, ok := byName[m.Name]; !ok {
+			names = append(names, m.Name)
+		}
+		byName[m.Name] = append(byName[m.Name], m)
+	}
+	sort.Strings(names)
+
+	groups := make([]WebMirrorGroup, 0, len(names))
+	for _, name := range names {
+		replicas := byName[name]
+		// masters first
+		sort.Slice(replicas, func(i, j int) bool {
+			if replicas[i].IsMaster != replicas[j].IsMaster {
+				return replicas[i].IsMaster
+			}
+			return replicas[i].Worker < replicas[j].Worker
+		})
+		shown := replicas[0]
+		if !shown.IsMaster {
+			for _, r := range replicas[1:] {
+				if r.LastUpdate.After(shown.LastUpdate) {
+					shown = r
+				}
+			}
+		}
+
+		g := WebMirrorGroup{
+			WebMirrorStatus: BuildWebMirrorStatus(shown),
+			FailedWorkers:   []string{},
+		}
+		if shown.IsMaster {
+			g.Master = shown.Worker
+		}
+		sizes := make(map[string]bool)
+		for _, r := range replicas {
+			replica := WebMirrorReplica{
+				Worker:       r.Worker,
+				IsMaster:     r.IsMaster,
+				Status:       r.Status,
+				Stale:        r.Stale,
+				LastUpdate:   textTime{r.LastUpdate},
+				LastUpdateTs: stampTime{r.LastUpdate},
+				Size:         r.Size,
+				ErrorMsg:     r.ErrorMsg,
+			}
+			if !r.LastUpdate.IsZero() && !shown.LastUpdate.IsZero() {
+				lag := int64(shown.LastUpdate.Sub(r.LastUpdate) / time.Second)
+				replica.Lag = &lag
+			}
+			if r.Status == Failed {
+				g.FailedWorkers = append(g.FailedWorkers, r.Worker)
+			}
+			if r.Size != "" && r.Size != "unknown" {
+				sizes[r.Size] = true
+			}
+			g.Replicas = append(g.Replicas, replica)
+		}
+		g.SizeMismatch = len(sizes) > 1
+		groups = append(groups, g)
+	}
+	return groups
+}
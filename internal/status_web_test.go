@@ -94,4 +94,49 @@ func TestStatus(t *testing.T) {
 		So(m2.Size, ShouldEqual, m.Size)
 		So(m2.Upstream, ShouldEqual, m.Upstream)
 	})
+	Convey("BuildWebMirrorGroups should work", t, func() {
+		now := time.Now()
+		ms := []MirrorStatus{
+			{Name: "ubuntu", Worker: "w2", Status: Success, LastUpdate: now.Add(-time.Hour), Size: "1T"},
+			{Name: "debian", Worker: "w3", Status: Failed, LastUpdate: now.Add(-time.Hour), Size: "2T"},
+			{Name: "debian", Worker: "w1", IsMaster: true, Status: Success, LastUpdate: now, Size: "2T"},
+			{Name: "ubuntu", Worker: "w1", Status: Success, LastUpdate: now, Size: "unknown"},
+			{Name: "debian", Worker: "w2", Status: Syncing, Size: "1.9T"},
+		}
+		groups := BuildWebMirrorGroups(ms)
+		So(groups, ShouldHaveLength, 2)
+
+		debian := groups[0]
+		So(debian.Name, ShouldEqual, "debian")
+		So(debian.Master, ShouldEqual, "w1")
+		So(debian.Status, ShouldEqual, Success)
+		So(debian.LastUpdate.UnixNano(), ShouldEqual, now.UnixNano())
+		So(debian.SizeMismatch, ShouldBeTrue)
+		So(debian.FailedWorkers, ShouldResemble, []string{"w3"})
+		So(debian.Replicas, ShouldHaveLength, 3)
+		So(debian.Replicas[0].Worker, ShouldEqual, "w1")
+		So(*debian.Replicas[0].Lag, ShouldEqual, 0)
+		So(debian.Replicas[1].Worker, ShouldEqual, "w2")
+		So(debian.Replicas[1].Lag, ShouldBeNil)
+		So(debian.Replicas[2].Worker, ShouldEqual, "w3")
+		So(*debian.Replicas[2].Lag, ShouldEqual, 3600)
+
+		// without a master, the latest updated replica is shown
+		ubuntu := groups[1]
+		So(ubuntu.Master, ShouldEqual, "")
+		So(ubuntu.Size, ShouldEqual, "unknown")
+		So(ubuntu.LastUpdate.UnixNano(), ShouldEqual, now.UnixNano())
+		So(ubuntu.SizeMismatch, ShouldBeFalse)
+		So(ubuntu.FailedWorkers, ShouldBeEmpty)
+		So(*ubuntu.Replicas[1].Lag, ShouldEqual, 3600)
+
+		b, err := json.Marshal(groups)
+		So(err, ShouldBeNil)
+		var groups2 []WebMirrorGroup
+		So(json.Unmarshal(b, &groups2), ShouldBeNil)
+		So(groups2[0].Name, ShouldEqual, "debian")
+		So(groups2[0].Master, ShouldEqual, "w1")
+		So(groups2[0].LastUpdateTs.Unix(), ShouldEqual, now.Unix())
+		So(*groups2[0].Replicas[2].Lag, ShouldEqual, 3600)
+	})
 }
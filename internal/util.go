@@ -2,8 +2,13 @@ package internal
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,9 +17,21 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	// SignatureHeader carries the signature of commands sent
+	// from the manager to workers
+	SignatureHeader = "X-Tunasync-Signature"
+	// LogFileHeader tells the name of the log file served by a worker
+	LogFileHeader = "X-Tunasync-Log-File"
+
+	bearerPrefix = "Bearer "
+)
+
 var rsyncExitValues = map[int]string{
 	0:  "Success",
 	1:  "Syntax or usage error",
@@ -81,6 +98,12 @@ func CreateHTTPClient(CAFile string) (*http.Client, error) {
 
 // PostJSON posts json object to url
 func PostJSON(url string, obj interface{}, client *http.Client) (*http.Response, error) {
+	return PostJSONWithToken(url, obj, "", client)
+}
+
+// PostJSONWithToken posts json object to url, with token as the
+// bearer token if it's not empty
+func PostJSONWithToken(url string, obj interface{}, token string, client *http.Client) (*http.Response, error) {
 	if client == nil {
 		client, _ = CreateHTTPClient("")
 	}
@@ -88,7 +111,115 @@ func PostJSON(url string, obj interface{}, client *http.Client) (*http.Response,
 	if err := json.NewEncoder(b).Encode(obj); err != nil {
 		return nil, err
 	}
-	return client.Post(url, "application/json; charset=utf-8", b)
+	req, err := http.NewRequest(http.MethodPost, url, b)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	SetBearerToken(req, token)
+	return client.Do(req)
+}
+
+// PostSignedJSON posts json object to url, signing the request
+// with secret if it's not empty
+func PostSignedJSON(url string, obj interface{}, secret string, client *http.Client) (*http.Response, error) {
+	if client == nil {
+		client, _ = CreateHTTPClient("")
+	}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if secret != "" {
+		SignRequest(req, body, secret, time.Now())
+	}
+	return client.Do(req)
+}
+
+// SetBearerToken sets token as the bearer token of req,
+// nothing is done if token is empty
+func SetBearerToken(req *http.Request, token string) {
+	if token != "" {
+		req.Header.Set("Authorization", bearerPrefix+token)
+	}
+}
+
+// BearerToken returns the bearer token of req
+func BearerToken(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(auth[len(bearerPrefix):])
+}
+
+// TokenEqual compares two tokens in constant time, an empty
+// token never matches
+func TokenEqual(given, expected string) bool {
+	if expected == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// GenerateToken returns a random hex token
+func GenerateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func requestMAC(method, uri string, body []byte, ts int64, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d\n%s\n%s\n", ts, method, uri)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// SignRequest signs the method, uri and body of req with secret,
+// and puts the signature into the SignatureHeader
+func SignRequest(req *http.Request, body []byte, secret string, now time.Time) {
+	ts := now.Unix()
+	mac := requestMAC(req.Method, req.URL.RequestURI(), body, ts, secret)
+	req.Header.Set(SignatureHeader, fmt.Sprintf("%d:%s", ts, hex.EncodeToString(mac)))
+}
+
+// VerifyRequest checks that req is signed by one of the secrets
+// no longer than maxAge ago
+func VerifyRequest(req *http.Request, body []byte, secrets []string, maxAge time.Duration) error {
+	sig := req.Header.Get(SignatureHeader)
+	if sig == "" {
+		return errors.New("missing signature")
+	}
+	tsStr, macStr, ok := strings.Cut(sig, ":")
+	if !ok {
+		return errors.New("malformed signature")
+	}
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return errors.New("malformed signature timestamp")
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > maxAge || d < -maxAge {
+		return errors.New("signature expired")
+	}
+	given, err := hex.DecodeString(macStr)
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+	for _, secret := range secrets {
+		mac := requestMAC(req.Method, req.URL.RequestURI(), body, ts, secret)
+		if hmac.Equal(given, mac) {
+			return nil
+		}
+	}
+	return errors.New("invalid signature")
 }
 
 // GetJSON gets a json response from url
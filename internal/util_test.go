@@ -1,9 +1,12 @@
 package internal
 
 import (
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -39,3 +42,33 @@ total size is 1.33T  speedup is 1,604.11
 		So(res, ShouldEqual, "1.33T")
 	})
 }
+
+func TestSignRequest(t *testing.T) {
+	Convey("Request signature should work", t, func() {
+		body := []byte(`{"cmd":"start","mirror_id":"debian"}`)
+		req, err := http.NewRequest("POST", "http://localhost:6000/", strings.NewReader(string(body)))
+		So(err, ShouldBeNil)
+
+		err = VerifyRequest(req, body, []string{"secret"}, time.Minute)
+		So(err, ShouldNotBeNil)
+
+		SignRequest(req, body, "secret", time.Now())
+		So(VerifyRequest(req, body, []string{"other", "secret"}, time.Minute), ShouldBeNil)
+		So(VerifyRequest(req, body, []string{"other"}, time.Minute), ShouldNotBeNil)
+		So(VerifyRequest(req, []byte(`{"cmd":"stop"}`), []string{"secret"}, time.Minute), ShouldNotBeNil)
+
+		SignRequest(req, body, "secret", time.Now().Add(-time.Hour))
+		So(VerifyRequest(req, body, []string{"secret"}, time.Minute), ShouldNotBeNil)
+	})
+
+	Convey("Tokens should be generated randomly", t, func() {
+		t1, err := GenerateToken()
+		So(err, ShouldBeNil)
+		t2, err := GenerateToken()
+		So(err, ShouldBeNil)
+		So(t1, ShouldNotEqual, t2)
+		So(TokenEqual(t1, t1), ShouldBeTrue)
+		So(TokenEqual(t1, t2), ShouldBeFalse)
+		So(TokenEqual("", ""), ShouldBeFalse)
+	})
+}
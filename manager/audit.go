@@ -0,0 +1,162 @@
+package manager
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// the administrative actions besides the commands,
+// which are recorded by their verbs
+const (
+	auditDeleteWorker  = "delete_worker"
+	auditFlushDisabled = "flush_disabled"
+	auditSetSize       = "set_size"
+)
+
+// default number of audit records listed
+const defaultAuditLimit = 100
+
+// an auditFilter selects the audit records to list,
+// the empty fields match everything
+type auditFilter struct {
+	Since    time.Time
+	Until    time.Time
+	Action   string
+	WorkerID string
+	MirrorID string
+	Identity string
+	Limit    int
+}
+
+func (f auditFilter) match(r AuditRecord) bool {
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !r.Time.Before(f.Until) {
+		return false
+	}
+	return (f.Action == "" || r.Action == f.Action) &&
+		(f.WorkerID == "" || r.WorkerID == f.WorkerID) &&
+		(f.MirrorID == "" || r.MirrorID == f.MirrorID) &&
+		(f.Identity == "" || r.Identity == f.Identity)
+}
+
+// auditRecordKey orders the audit records by time, the hash tells
+// apart the records of the same time and keeps a record restored
+// twice from being duplicated
+func auditRecordKey(r AuditRecord) string {
+	b, _ := json.Marshal(canonicalAuditRecord(r))
+	sum := sha1.Sum(b)
+	return auditTimeKey(r.Time) + "-" + hex.EncodeToString(sum[:6])
+}
+
+func auditTimeKey(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+// auditKeyRange returns the range of the keys of the records
+// in [since, until), the zero times are not bounds
+func auditKeyRange(since, until time.Time) (start, end string) {
+	if !since.IsZero() {
+		start = auditTimeKey(since)
+	}
+	if !until.IsZero() {
+		end = auditTimeKey(until)
+	}
+	return
+}
+
+// audit records an administrative operation requested by c
+func (s *Manager) audit(c *gin.Context, r AuditRecord) {
+	r.Time = time.Now()
+	r.Source = c.ClientIP()
+	r.Identity = c.GetString(_identityKey)
+	s.addAudit(r)
+}
+
+// addAudit records an administrative operation
+func (s *Manager) addAudit(r AuditRecord) {
+	logger.Noticef("Audit: %s %s/%s by %s@%s: %d %s", r.Action, r.WorkerID, r.MirrorID,
+		r.Identity, r.Source, r.Code, r.Message)
+
+	s.rwmu.Lock()
+	err := s.adapter.AddAuditRecord(r)
+	s.rwmu.Unlock()
+	if err != nil {
+		logger.Errorf("Failed to add audit record of %s: %s", r.Action, err.Error())
+	}
+
+	// the records are kept forever unless a retention is set
+	if retention := s.config().Audit.Retention; retention > 0 {
+		s.rwmu.Lock()
+		err := s.adapter.PruneAuditRecords(r.Time.AddDate(0, 0, -retention))
+		s.rwmu.Unlock()
+		if err != nil {
+			logger.Errorf("Failed to prune audit records: %s", err.Error())
+		}
+	}
+}
+
+// cmdAuditArgs records the arguments and the options of a command
+func cmdAuditArgs(args []string, options map[string]bool) []string {
+	recorded := append([]string{}, args...)
+	var opts []string
+	for opt, on := range options {
+		if on {
+			opts = append(opts, "--"+opt)
+		}
+	}
+	sort.Strings(opts)
+	return append(recorded, opts...)
+}
+
+// listAuditRecords responds with the audit records, newest first
+func (s *Manager) listAuditRecords(c *gin.Context) {
+	var err error
+	filter := auditFilter{
+		Action:   c.Query("action"),
+		WorkerID: c.Query("worker"),
+		MirrorID: c.Query("mirror"),
+		Identity: c.Query("identity"),
+		Limit:    defaultAuditLimit,
+	}
+	if filter.Since, err = parseTimeParam(c.Query("since")); err != nil {
+		s.returnErrJSON(c, http.StatusBadRequest, fmt.Errorf("invalid since: %s", err.Error()))
+		return
+	}
+	if filter.Until, err = parseTimeParam(c.Query("until")); err != nil {
+		s.returnErrJSON(c, http.StatusBadRequest, fmt.Errorf("invalid until: %s", err.Error()))
+		return
+	}
+	if l := c.Query("limit"); l != "" {
+		filter.Limit, err = strconv.Atoi(l)
+		if err != nil || filter.Limit < 0 {
+			s.returnErrJSON(c, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", l))
+			return
+		}
+	}
+
+	s.rwmu.RLock()
+	records, err := s.adapter.ListAuditRecords(filter)
+	s.rwmu.RUnlock()
+	if err != nil {
+		err := fmt.Errorf("failed to list audit records: %s", err.Error())
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	if records == nil {
+		records = []AuditRecord{}
+	}
+	c.JSON(http.StatusOK, records)
+}
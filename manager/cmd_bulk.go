@@ -0,0 +1,147 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// how many commands of a bulk command are sent at once
+const maxBulkCmdConcurrency = 16
+
+// bulkCmdVerbs are the commands on jobs that can be sent in bulk
+var bulkCmdVerbs = map[CmdVerb]bool{
+	CmdStart:   true,
+	CmdStop:    true,
+	CmdRestart: true,
+	CmdDisable: true,
+	CmdPing:    true,
+}
+
+// selectJobs finds the jobs the selector matches, in the order of
+// their names and workers
+func (s *Manager) selectJobs(sel JobSelector) ([]MirrorStatus, error) {
+	filter := jobFilter{Statuses: sel.Statuses, Workers: sel.Workers}
+	if sel.OlderThan > 0 {
+		filter.StaleSince = time.Now().Add(-time.Duration(sel.OlderThan) * time.Second)
+	}
+	if err := s.filterOfflineWorkers(&filter); err != nil {
+		return nil, err
+	}
+	s.rwmu.RLock()
+	ms, err := s.adapter.QueryMirrorStatus(filter)
+	s.rwmu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	selected := []MirrorStatus{}
+	for _, m := range ms {
+		if len(sel.Names) == 0 {
+			selected = append(selected, m)
+			continue
+		}
+		for _, name := range sel.Names {
+			if ok, _ := path.Match(name, m.Name); ok {
+				selected = append(selected, m)
+				break
+			}
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if selected[i].Name != selected[j].Name {
+			return selected[i].Name < selected[j].Name
+		}
+		return selected[i].Worker < selected[j].Worker
+	})
+	return selected, nil
+}
+
+// validateJobSelector rejects the selectors matching all the jobs by
+// mistake, and the malformed globs
+func validateJobSelector(sel JobSelector) error {
+	if sel.OlderThan < 0 {
+		return fmt.Errorf("invalid older_than: %d", sel.OlderThan)
+	}
+	if len(sel.Names) == 0 && len(sel.Statuses) == 0 && len(sel.Workers) == 0 &&
+		sel.OlderThan == 0 && !sel.All {
+		return errors.New("no job is selected, use all to select all the jobs")
+	}
+	for _, name := range sel.Names {
+		if _, err := path.Match(name, ""); err != nil {
+			return fmt.Errorf("invalid mirror name pattern: %s", name)
+		}
+	}
+	return nil
+}
+
+// handleBulkCmd sends the command to all the jobs selected, a few at
+// once, and responds with the result on each of them
+func (s *Manager) handleBulkCmd(c *gin.Context) {
+	var bulkCmd BulkCmd
+	if err := c.BindJSON(&bulkCmd); err != nil {
+		return
+	}
+	if !bulkCmdVerbs[bulkCmd.Cmd] {
+		err := fmt.Errorf("command %s can not be sent in bulk", bulkCmd.Cmd)
+		s.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	if bulkCmd.QueueTTL < 0 || bulkCmd.QueueTTL > maxCmdQueueTTL {
+		err := fmt.Errorf("invalid queue_ttl: %d, at most %d seconds", bulkCmd.QueueTTL, maxCmdQueueTTL)
+		s.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := validateJobSelector(bulkCmd.Selector); err != nil {
+		s.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+
+	jobs, err := s.selectJobs(bulkCmd.Selector)
+	if err != nil {
+		err := fmt.Errorf("failed to select jobs: %s", err.Error())
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	results := make([]CmdResult, len(jobs))
+	if bulkCmd.DryRun {
+		for i, m := range jobs {
+			results[i] = CmdResult{WorkerID: m.Worker, MirrorID: m.Name,
+				Code: http.StatusOK, Message: "selected"}
+		}
+		c.JSON(http.StatusOK, results)
+		return
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan empty, maxBulkCmdConcurrency)
+	for i, m := range jobs {
+		wg.Add(1)
+		sem <- empty{}
+		go func(i int, m MirrorStatus) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			clientCmd := ClientCmd{
+				Cmd:      bulkCmd.Cmd,
+				MirrorID: m.Name,
+				WorkerID: m.Worker,
+				Args:     bulkCmd.Args,
+				Options:  bulkCmd.Options,
+				QueueTTL: bulkCmd.QueueTTL,
+			}
+			results[i] = s.dispatchClientCmd(c, m.Worker, clientCmd)
+		}(i, m)
+	}
+	wg.Wait()
+	c.JSON(http.StatusOK, results)
+}
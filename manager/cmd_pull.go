@@ -0,0 +1,193 @@
+package manager
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+const (
+	// how long a poll of the workers waits for a command, in seconds
+	defaultCmdPollWait = 30
+	maxCmdPollWait     = 300
+)
+
+// a pulledCmd waits for a worker to poll it and reply
+type pulledCmd struct {
+	PulledCmd
+	reply chan CmdReply
+	// signaled when the poll taking the command is over before
+	// the command is handed to the worker
+	back chan empty
+}
+
+// cmdPuller hands the commands to the workers polling the manager,
+// which are behind NAT or firewalls the manager cannot reach through
+type cmdPuller struct {
+	sync.Mutex
+	// a command is sent to the worker only while it is polling
+	polls map[string]chan *pulledCmd
+	// the commands taken by the workers, waiting for the replies
+	pending map[string]*pulledCmd
+}
+
+// the maps are made on the first use, under the lock
+func (p *cmdPuller) init() {
+	if p.polls == nil {
+		p.polls = make(map[string]chan *pulledCmd)
+		p.pending = make(map[string]*pulledCmd)
+	}
+}
+
+func (p *cmdPuller) polling(workerID string) chan *pulledCmd {
+	p.Lock()
+	defer p.Unlock()
+	p.init()
+	ch, ok := p.polls[workerID]
+	if !ok {
+		ch = make(chan *pulledCmd)
+		p.polls[workerID] = ch
+	}
+	return ch
+}
+
+func (p *cmdPuller) wait(workerID string, cmd *pulledCmd) {
+	p.Lock()
+	defer p.Unlock()
+	p.init()
+	p.pending[workerID+"/"+cmd.ID] = cmd
+}
+
+func (p *cmdPuller) done(workerID string, cmd *pulledCmd) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.pending, workerID+"/"+cmd.ID)
+}
+
+// reply passes the reply of the worker on, and tells whether the
+// command is still waiting for it
+func (p *cmdPuller) reply(workerID, id string, reply CmdReply) bool {
+	p.Lock()
+	cmd, ok := p.pending[workerID+"/"+id]
+	delete(p.pending, workerID+"/"+id)
+	p.Unlock()
+	if ok {
+		cmd.reply <- reply
+	}
+	return ok
+}
+
+// pullWorkerCmd hands the command to the worker when it polls, and
+// waits for its reply, in the same way as postWorkerCmd
+func (s *Manager) pullWorkerCmd(w WorkerStatus, cmd WorkerCmd) (int, string, error) {
+	timeout := s.cmdTimeout()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	pc := &pulledCmd{
+		PulledCmd: PulledCmd{ID: newCmdID(time.Now()), Cmd: cmd},
+		reply:     make(chan CmdReply, 1),
+		back:      make(chan empty, 1),
+	}
+	// registered before being taken, so that no reply is missed
+	s.pulls.wait(w.ID, pc)
+	defer s.pulls.done(w.ID, pc)
+
+	for {
+		select {
+		case s.pulls.polling(w.ID) <- pc:
+		case <-timer.C:
+			return http.StatusBadGateway, "",
+				fmt.Errorf("worker %s is not polling for commands", w.ID)
+		}
+		select {
+		case reply := <-pc.reply:
+			return reply.Code, reply.Message, nil
+		case <-pc.back:
+			// never reached the worker, handed to the next poll
+		case <-timer.C:
+			return http.StatusGatewayTimeout, "",
+				fmt.Errorf("worker %s did not answer in %s", w.ID, timeout)
+		}
+	}
+}
+
+// pollWorkerCmd holds the poll of a worker until a command is sent
+// to it, or responds with 204 when the wait is over
+func (s *Manager) pollWorkerCmd(c *gin.Context) {
+	workerID := c.Param("id")
+	wait := defaultCmdPollWait
+	if v := c.Query("wait"); v != "" {
+		var err error
+		wait, err = strconv.Atoi(v)
+		if err != nil || wait < 0 || wait > maxCmdPollWait {
+			err := fmt.Errorf("invalid wait: %s, at most %d seconds", v, maxCmdPollWait)
+			s.returnErrJSON(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	// polling keeps the worker online
+	s.rwmu.RLock()
+	_, err := s.adapter.RefreshWorker(workerID)
+	s.rwmu.RUnlock()
+	if err != nil {
+		err := fmt.Errorf("failed to refresh worker %s: %s", workerID, err.Error())
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	s.triggerQueuedCmds(workerID, 0)
+
+	// the poll lives longer than the write timeout of the server
+	d := time.Duration(wait) * time.Second
+	rc := http.NewResponseController(c.Writer)
+	rc.SetWriteDeadline(time.Now().Add(d + 10*time.Second))
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case pc := <-s.pulls.polling(workerID):
+		// the command is given back if the poll is over before it
+		// is sent, for the sender would take it as not delivered
+		// and queue it again
+		if c.Request.Context().Err() != nil {
+			pc.back <- empty{}
+			return
+		}
+		c.JSON(http.StatusOK, pc.PulledCmd)
+		if c.IsAborted() || rc.Flush() != nil {
+			logger.Debugf("Worker <%s> dropped the poll of command %s", workerID, pc.Cmd)
+			pc.back <- empty{}
+			return
+		}
+		logger.Debugf("Worker <%s> pulled command %s", workerID, pc.Cmd)
+	case <-timer.C:
+		c.Status(http.StatusNoContent)
+	case <-s.done:
+		c.Status(http.StatusNoContent)
+	case <-c.Request.Context().Done():
+	}
+}
+
+// replyWorkerCmd receives the reply of a worker to a pulled command
+func (s *Manager) replyWorkerCmd(c *gin.Context) {
+	workerID, id := c.Param("id"), c.Param("cmd")
+	var reply CmdReply
+	if err := c.BindJSON(&reply); err != nil {
+		return
+	}
+	if !s.pulls.reply(workerID, id, reply) {
+		err := fmt.Errorf("command %s of worker %s is not waiting for a reply", id, workerID)
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, empty{})
+}
@@ -0,0 +1,246 @@
+package manager
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+const (
+	// the audit action of cancelling a queued command
+	auditCancelQueued = "cancel_queued"
+	// the source of the audit records of the queued commands
+	cmdQueueSource = "queue"
+	// the longest a command is kept queued, in seconds
+	maxCmdQueueTTL = 7 * 24 * 3600
+)
+
+// the queued commands are delivered a while after the worker
+// registers, when it has got its session token and listens
+var cmdQueueRegisterDelay = 3 * time.Second
+
+// cmdQueueLocks keeps the queued commands of a worker from being
+// delivered by two goroutines at once
+type cmdQueueLocks struct {
+	sync.Map
+}
+
+func (l *cmdQueueLocks) of(workerID string) *sync.Mutex {
+	mu, _ := l.LoadOrStore(workerID, &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+// cmdRetryable tells whether a command failed because the worker is
+// not reachable for now, or has not got its new session token yet
+func cmdRetryable(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusGatewayTimeout ||
+		code == http.StatusUnauthorized
+}
+
+// newCmdID makes an ID of a command, the IDs sort in the order
+// they are made
+func newCmdID(now time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%020d-%s", now.UnixNano(), hex.EncodeToString(b))
+}
+
+// newQueuedCmd makes the queued command of cmd
+func newQueuedCmd(workerID string, cmd WorkerCmd, identity string, ttl time.Duration) QueuedCmd {
+	now := time.Now()
+	return QueuedCmd{
+		ID:       newCmdID(now),
+		WorkerID: workerID,
+		Cmd:      cmd,
+		Identity: identity,
+		Created:  now,
+		Expires:  now.Add(ttl),
+	}
+}
+
+// sortQueuedCmds orders the queued commands as they are queued
+func sortQueuedCmds(qs []QueuedCmd) {
+	sort.Slice(qs, func(i, j int) bool {
+		if qs[i].ID != qs[j].ID {
+			return qs[i].ID < qs[j].ID
+		}
+		return qs[i].WorkerID < qs[j].WorkerID
+	})
+}
+
+// queueCmd keeps the command until the worker is reachable again
+func (s *Manager) queueCmd(q QueuedCmd) error {
+	s.rwmu.Lock()
+	err := s.adapter.AddQueuedCmd(q)
+	s.rwmu.Unlock()
+	if err != nil {
+		return err
+	}
+	logger.Noticef("Queued command '%s' to <%s> until %s", q.Cmd, q.WorkerID,
+		q.Expires.Format(time.RFC3339))
+	return nil
+}
+
+// auditQueuedCmd records what becomes of a queued command
+func (s *Manager) auditQueuedCmd(q QueuedCmd, code int, msg string) {
+	s.addAudit(AuditRecord{
+		Time:     time.Now(),
+		Action:   q.Cmd.Cmd.String(),
+		Source:   cmdQueueSource,
+		Identity: q.Identity,
+		WorkerID: q.WorkerID,
+		MirrorID: q.Cmd.MirrorID,
+		Args:     cmdAuditArgs(q.Cmd.Args, q.Cmd.Options),
+		Code:     code,
+		Message:  msg,
+	})
+}
+
+// triggerQueuedCmds delivers the queued commands of the worker in
+// the background, unless they are being delivered already
+func (s *Manager) triggerQueuedCmds(workerID string, delay time.Duration) {
+	go func() {
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-s.done:
+				return
+			}
+		}
+		mu := s.cmdQueues.of(workerID)
+		if !mu.TryLock() {
+			return
+		}
+		defer mu.Unlock()
+		s.deliverQueuedCmds(workerID)
+	}()
+}
+
+// flushQueuedCmds delivers the queued commands of the worker before
+// another command is sent to it, to keep the commands in order
+func (s *Manager) flushQueuedCmds(workerID string) {
+	mu := s.cmdQueues.of(workerID)
+	mu.Lock()
+	defer mu.Unlock()
+	s.deliverQueuedCmds(workerID)
+}
+
+// deliverQueuedCmds sends the queued commands to the worker in order
+// and stops at the first one the worker is still unreachable for.
+// The commands rejected by the worker or expired are dropped.
+func (s *Manager) deliverQueuedCmds(workerID string) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	s.rwmu.RLock()
+	qs, err := s.adapter.ListQueuedCmds(workerID)
+	s.rwmu.RUnlock()
+	if err != nil {
+		logger.Errorf("Failed to list queued commands of worker %s: %s", workerID, err.Error())
+		return
+	}
+
+	for _, q := range qs {
+		var result CmdResult
+		if time.Now().After(q.Expires) {
+			result.Code = http.StatusGone
+			result.Message = fmt.Sprintf("command expired at %s", q.Expires.Format(time.RFC3339))
+		} else {
+			logger.Noticef("Delivering queued command '%s' to <%s>", q.Cmd, workerID)
+			result = s.sendWorkerCmd(workerID, q.Cmd)
+			if cmdRetryable(result.Code) {
+				logger.Debugf("Queued command '%s' to <%s> not delivered: %s",
+					q.Cmd, workerID, result.Message)
+				return
+			}
+		}
+
+		s.rwmu.Lock()
+		err := s.adapter.DeleteQueuedCmd(workerID, q.ID)
+		s.rwmu.Unlock()
+		if err != nil {
+			// cancelled meanwhile
+			logger.Warningf("Failed to remove queued command %s of worker %s: %s",
+				q.ID, workerID, err.Error())
+		}
+		if result.Code != http.StatusOK {
+			logger.Warningf("Dropped queued command '%s' to <%s>: %s", q.Cmd, workerID, result.Message)
+		}
+		s.auditQueuedCmd(q, result.Code, result.Message)
+	}
+}
+
+// listQueuedCmds responds with the queued commands of a worker,
+// or of all the workers, in the order they are delivered
+func (s *Manager) listQueuedCmds(c *gin.Context) {
+	s.rwmu.RLock()
+	qs, err := s.adapter.ListQueuedCmds(c.Query("worker"))
+	s.rwmu.RUnlock()
+	if err != nil {
+		err := fmt.Errorf("failed to list queued commands: %s", err.Error())
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	// the expired commands are dropped on the next delivery
+	now := time.Now()
+	pending := []QueuedCmd{}
+	for _, q := range qs {
+		if now.Before(q.Expires) {
+			pending = append(pending, q)
+		}
+	}
+	c.JSON(http.StatusOK, pending)
+}
+
+// cancelQueuedCmd removes a queued command before it is delivered
+func (s *Manager) cancelQueuedCmd(c *gin.Context) {
+	workerID, id := c.Param("worker"), c.Param("id")
+	// not while the command is being delivered
+	mu := s.cmdQueues.of(workerID)
+	mu.Lock()
+	defer mu.Unlock()
+
+	var q QueuedCmd
+	code := http.StatusNotFound
+	s.rwmu.Lock()
+	qs, err := s.adapter.ListQueuedCmds(workerID)
+	if err != nil {
+		code = http.StatusInternalServerError
+	} else {
+		err = fmt.Errorf("invalid queued command %s of worker %s", id, workerID)
+		for _, queued := range qs {
+			if queued.ID == id {
+				q = queued
+				err = s.adapter.DeleteQueuedCmd(workerID, id)
+				break
+			}
+		}
+	}
+	s.rwmu.Unlock()
+
+	record := AuditRecord{Action: auditCancelQueued, WorkerID: workerID,
+		MirrorID: q.Cmd.MirrorID, Args: []string{id}}
+	if err != nil {
+		err := fmt.Errorf("failed to cancel queued command: %s", err.Error())
+		c.Error(err)
+		record.Code, record.Message = code, err.Error()
+		s.audit(c, record)
+		s.returnErrJSON(c, code, err)
+		return
+	}
+	msg := fmt.Sprintf("cancelled queued command '%s' to worker %s", q.Cmd, workerID)
+	record.Code, record.Message = http.StatusOK, msg
+	s.audit(c, record)
+	c.JSON(http.StatusOK, gin.H{_infoKey: msg})
+}
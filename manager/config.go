@@ -7,9 +7,15 @@ import (
 
 // A Config is the top-level toml-serializaible config struct
 type Config struct {
-	Debug  bool         `toml:"debug"`
-	Server ServerConfig `toml:"server"`
-	Files  FileConfig   `toml:"files"`
+	Debug   bool          `toml:"debug"`
+	Server  ServerConfig  `toml:"server"`
+	Files   FileConfig    `toml:"files"`
+	History HistoryConfig `toml:"history"`
+	Audit   AuditConfig   `toml:"audit"`
+	Auth    AuthConfig    `toml:"auth"`
+	Status  StatusConfig  `toml:"status"`
+	// webhooks notified of the mirror status transitions
+	Webhooks []WebhookConfig `toml:"webhooks"`
 }
 
 // A ServerConfig represents the configuration for HTTP server
@@ -18,6 +24,8 @@ type ServerConfig struct {
 	Port    int    `toml:"port"`
 	SSLCert string `toml:"ssl_cert"`
 	SSLKey  string `toml:"ssl_key"`
+	// seconds to wait for a worker to answer a command
+	CmdTimeout int `toml:"cmd_timeout"`
 }
 
 // A FileConfig contains paths to special files
@@ -29,16 +37,94 @@ type FileConfig struct {
 	CACert string `toml:"ca_cert"`
 }
 
+// A HistoryConfig controls how the sync history is kept
+type HistoryConfig struct {
+	// sync records older than this many days are pruned,
+	// 0 means keeping them forever
+	Retention int `toml:"retention"`
+}
+
+// An AuditConfig controls how the audit records are kept
+type AuditConfig struct {
+	// audit records older than this many days are pruned,
+	// 0 means keeping them forever
+	Retention int `toml:"retention"`
+}
+
+// A StatusConfig controls how the status of workers is judged
+type StatusConfig struct {
+	// a worker not heard from for this many seconds is offline,
+	// 0 disables the offline marking. It is off by default since
+	// the workers older than the heartbeat are only heard from when
+	// they report.
+	OfflineTimeout int `toml:"offline_timeout"`
+	// a mirror not updated for this many times of its sync
+	// interval is stale, 0 disables the stale marking
+	StaleFactor float64 `toml:"stale_factor"`
+}
+
+// A WebhookConfig describes a webhook and the events it subscribes to
+type WebhookConfig struct {
+	URL string `toml:"url"`
+	// the events to send, all of them when empty
+	Events []string `toml:"events"`
+	// glob patterns of the mirrors and workers to watch,
+	// all of them when empty
+	Mirrors []string `toml:"mirrors"`
+	Workers []string `toml:"workers"`
+	// the number of failed runs in a row to notify, 3 by default
+	ConsecutiveFailures int `toml:"consecutive_failures"`
+	// a Go template of the body, the payload is posted as JSON
+	// when it is empty
+	Template    string            `toml:"template"`
+	ContentType string            `toml:"content_type"`
+	Headers     map[string]string `toml:"headers"`
+	// timeout of a request in seconds, 10 by default
+	Timeout int `toml:"timeout"`
+	// retries of a failed request, 3 by default and negative
+	// for none; the backoff starts from retry_backoff seconds
+	// and doubles on each retry
+	MaxRetries   int `toml:"max_retries"`
+	RetryBackoff int `toml:"retry_backoff"`
+}
+
+// An AuthConfig contains the tokens used to authenticate
+// workers and tunasynctl users, authentication is disabled
+// when no token is set
+type AuthConfig struct {
+	// the secret shared by all the workers to register
+	WorkerToken string `toml:"worker_token"`
+	// per-worker secrets, override worker_token
+	WorkerTokens map[string]string `toml:"worker_tokens"`
+	// tokens of tunasynctl users, keyed by user name
+	AdminTokens map[string]string `toml:"admin_tokens"`
+}
+
+func (a AuthConfig) workerAuthEnabled() bool {
+	return a.WorkerToken != "" || len(a.WorkerTokens) > 0
+}
+
+// workerSecret returns the secret with which the worker registers
+func (a AuthConfig) workerSecret(workerID string) string {
+	if token, ok := a.WorkerTokens[workerID]; ok {
+		return token
+	}
+	return a.WorkerToken
+}
+
 // LoadConfig loads config from specified file
 func LoadConfig(cfgFile string, c *cli.Context) (*Config, error) {
 
 	cfg := new(Config)
 	cfg.Server.Addr = "127.0.0.1"
 	cfg.Server.Port = 14242
+	cfg.Server.CmdTimeout = defaultCmdTimeout
 	cfg.Debug = false
 	cfg.Files.StatusFile = "/var/lib/tunasync/tunasync.json"
 	cfg.Files.DBFile = "/var/lib/tunasync/tunasync.db"
 	cfg.Files.DBType = "bolt"
+	cfg.History.Retention = 30
+	cfg.Status.StaleFactor = 3
 
 	if cfgFile != "" {
 		if _, err := toml.DecodeFile(cfgFile, cfg); err != nil {
@@ -75,6 +75,9 @@ func TestConfig(t *testing.T) {
 					cfg, err := LoadConfig(cfgFile, c)
 					So(err, ShouldEqual, nil)
 					So(cfg.Server.Addr, ShouldEqual, "127.0.0.1")
+					So(cfg.Status.OfflineTimeout, ShouldEqual, 0)
+					So(cfg.Audit.Retention, ShouldEqual, 0)
+					So(cfg.Status.StaleFactor, ShouldEqual, 3)
 					return nil
 				}
 				args := strings.Split("cmd", " ")
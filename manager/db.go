@@ -3,6 +3,7 @@ package manager
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -26,7 +27,17 @@ type dbAdapter interface {
 	GetMirrorStatus(workerID, mirrorID string) (MirrorStatus, error)
 	ListMirrorStatus(workerID string) ([]MirrorStatus, error)
 	ListAllMirrorStatus() ([]MirrorStatus, error)
+	QueryMirrorStatus(filter jobFilter) ([]MirrorStatus, error)
 	FlushDisabledJobs() error
+	AddSyncRecord(workerID, mirrorID string, record SyncRecord) error
+	ListSyncRecords(workerID, mirrorID string, since, until time.Time, limit int) ([]SyncRecord, error)
+	PruneSyncRecords(workerID, mirrorID string, before time.Time) error
+	AddAuditRecord(r AuditRecord) error
+	ListAuditRecords(filter auditFilter) ([]AuditRecord, error)
+	PruneAuditRecords(before time.Time) error
+	AddQueuedCmd(q QueuedCmd) error
+	ListQueuedCmds(workerID string) ([]QueuedCmd, error)
+	DeleteQueuedCmd(workerID, id string) error
 	Close() error
 }
 
@@ -35,14 +46,22 @@ type kvAdapter interface {
 	InitBucket(bucket string) error
 	Get(bucket string, key string) ([]byte, error)
 	GetAll(bucket string) (map[string][]byte, error)
+	GetPrefix(bucket string, prefix string) (map[string][]byte, error)
+	// ScanRange walks the keys in [start, end) from the last one
+	// backwards until fn returns false, an empty end means no upper
+	// bound. The value is only valid within fn.
+	ScanRange(bucket string, start, end string, fn func(key string, value []byte) bool) error
 	Put(bucket string, key string, value []byte) error
 	Delete(bucket string, key string) error
 	Close() error
 }
 
 const (
-	_workerBucketKey = "workers"
-	_statusBucketKey = "mirror_status"
+	_workerBucketKey  = "workers"
+	_statusBucketKey  = "mirror_status"
+	_historyBucketKey = "mirror_history"
+	_auditBucketKey   = "audit_log"
+	_cmdQueueKey      = "cmd_queue"
 )
 
 func makeDBAdapter(dbType string, dbFile string) (dbAdapter, error) {
@@ -101,6 +120,13 @@ func makeDBAdapter(dbType string, dbFile string) (dbAdapter, error) {
 		}
 		err = kv.Init()
 		return &kv, err
+	} else if dbType == "sqlite" || dbType == "postgres" {
+		db, err := makeSQLAdapter(dbType, dbFile)
+		if err != nil {
+			return nil, err
+		}
+		err = db.Init()
+		return db, err
 	}
 	// unsupported db-type
 	return nil, fmt.Errorf("unsupported db-type: %s", dbType)
@@ -120,6 +146,18 @@ func (b *kvDBAdapter) Init() error {
 	if err != nil {
 		return fmt.Errorf("create bucket %s error: %s", _workerBucketKey, err.Error())
 	}
+	err = b.db.InitBucket(_historyBucketKey)
+	if err != nil {
+		return fmt.Errorf("create bucket %s error: %s", _historyBucketKey, err.Error())
+	}
+	err = b.db.InitBucket(_auditBucketKey)
+	if err != nil {
+		return fmt.Errorf("create bucket %s error: %s", _auditBucketKey, err.Error())
+	}
+	err = b.db.InitBucket(_cmdQueueKey)
+	if err != nil {
+		return fmt.Errorf("create bucket %s error: %s", _cmdQueueKey, err.Error())
+	}
 	return err
 }
 
@@ -236,6 +274,30 @@ func (b *kvDBAdapter) ListAllMirrorStatus() (ms []MirrorStatus, err error) {
 	return
 }
 
+func (b *kvDBAdapter) QueryMirrorStatus(filter jobFilter) (ms []MirrorStatus, err error) {
+	var vals map[string][]byte
+	if filter.Name != "" && !strings.ContainsAny(filter.Name, "*?[\\") {
+		// the status is keyed by the mirror first
+		vals, err = b.db.GetPrefix(_statusBucketKey, filter.Name+"/")
+	} else {
+		vals, err = b.db.GetAll(_statusBucketKey)
+	}
+	if err != nil {
+		return
+	}
+
+	for _, v := range vals {
+		var m MirrorStatus
+		jsonErr := json.Unmarshal(v, &m)
+		if jsonErr != nil {
+			err = errors.Wrap(err, jsonErr.Error())
+			continue
+		}
+		ms = append(ms, m)
+	}
+	return selectMirrorStatus(ms, filter), err
+}
+
 func (b *kvDBAdapter) FlushDisabledJobs() (err error) {
 	var vals map[string][]byte
 	vals, err = b.db.GetAll(_statusBucketKey)
@@ -260,6 +322,169 @@ func (b *kvDBAdapter) FlushDisabledJobs() (err error) {
 	return
 }
 
+// sync records are keyed by mirror, worker and the start time of the run,
+// so that all the attempts of one run share a single record
+func syncRecordPrefix(workerID, mirrorID string) string {
+	return mirrorID + "/" + workerID + "/"
+}
+
+func syncRecordKey(workerID, mirrorID string, started time.Time) string {
+	return fmt.Sprintf("%s%020d", syncRecordPrefix(workerID, mirrorID), started.UnixNano())
+}
+
+func (b *kvDBAdapter) AddSyncRecord(workerID, mirrorID string, record SyncRecord) error {
+	v, err := json.Marshal(record)
+	if err == nil {
+		err = b.db.Put(_historyBucketKey, syncRecordKey(workerID, mirrorID, record.Started), v)
+	}
+	return err
+}
+
+func (b *kvDBAdapter) ListSyncRecords(workerID, mirrorID string, since, until time.Time, limit int) (rs []SyncRecord, err error) {
+	var vals map[string][]byte
+	vals, err = b.db.GetPrefix(_historyBucketKey, syncRecordPrefix(workerID, mirrorID))
+	if err != nil {
+		return
+	}
+
+	// newest first
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		keys = append(keys, k)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+
+	for _, k := range keys {
+		var r SyncRecord
+		jsonErr := json.Unmarshal(vals[k], &r)
+		if jsonErr != nil {
+			err = errors.Wrap(err, jsonErr.Error())
+			continue
+		}
+		if !since.IsZero() && r.Started.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !r.Started.Before(until) {
+			continue
+		}
+		rs = append(rs, r)
+		if limit > 0 && len(rs) >= limit {
+			break
+		}
+	}
+	return
+}
+
+func (b *kvDBAdapter) PruneSyncRecords(workerID, mirrorID string, before time.Time) (err error) {
+	var vals map[string][]byte
+	vals, err = b.db.GetPrefix(_historyBucketKey, syncRecordPrefix(workerID, mirrorID))
+	if err != nil {
+		return
+	}
+
+	for k, v := range vals {
+		var r SyncRecord
+		jsonErr := json.Unmarshal(v, &r)
+		if jsonErr != nil {
+			err = errors.Wrap(err, jsonErr.Error())
+			continue
+		}
+		if r.Ended.Before(before) {
+			deleteErr := b.db.Delete(_historyBucketKey, k)
+			if deleteErr != nil {
+				err = errors.Wrap(err, deleteErr.Error())
+			}
+		}
+	}
+	return
+}
+
+func (b *kvDBAdapter) AddAuditRecord(r AuditRecord) error {
+	v, err := json.Marshal(r)
+	if err == nil {
+		err = b.db.Put(_auditBucketKey, auditRecordKey(r), v)
+	}
+	return err
+}
+
+func (b *kvDBAdapter) ListAuditRecords(filter auditFilter) (rs []AuditRecord, err error) {
+	start, end := auditKeyRange(filter.Since, filter.Until)
+	var jsonErr error
+	err = b.db.ScanRange(_auditBucketKey, start, end, func(_ string, v []byte) bool {
+		var r AuditRecord
+		if jsonErr = json.Unmarshal(v, &r); jsonErr != nil {
+			return false
+		}
+		if filter.match(r) {
+			rs = append(rs, r)
+		}
+		return filter.Limit <= 0 || len(rs) < filter.Limit
+	})
+	if err == nil {
+		err = jsonErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+func (b *kvDBAdapter) PruneAuditRecords(before time.Time) error {
+	var keys []string
+	_, end := auditKeyRange(time.Time{}, before)
+	err := b.db.ScanRange(_auditBucketKey, "", end, func(k string, _ []byte) bool {
+		keys = append(keys, k)
+		return true
+	})
+	for _, k := range keys {
+		deleteErr := b.db.Delete(_auditBucketKey, k)
+		if deleteErr != nil {
+			err = errors.Wrap(err, deleteErr.Error())
+		}
+	}
+	return err
+}
+
+func (b *kvDBAdapter) AddQueuedCmd(q QueuedCmd) error {
+	v, err := json.Marshal(q)
+	if err == nil {
+		err = b.db.Put(_cmdQueueKey, q.WorkerID+"/"+q.ID, v)
+	}
+	return err
+}
+
+func (b *kvDBAdapter) ListQueuedCmds(workerID string) (qs []QueuedCmd, err error) {
+	var vals map[string][]byte
+	if workerID == "" {
+		vals, err = b.db.GetAll(_cmdQueueKey)
+	} else {
+		vals, err = b.db.GetPrefix(_cmdQueueKey, workerID+"/")
+	}
+	if err != nil {
+		return
+	}
+	for _, v := range vals {
+		var q QueuedCmd
+		jsonErr := json.Unmarshal(v, &q)
+		if jsonErr != nil {
+			err = errors.Wrap(err, jsonErr.Error())
+			continue
+		}
+		qs = append(qs, q)
+	}
+	sortQueuedCmds(qs)
+	return
+}
+
+func (b *kvDBAdapter) DeleteQueuedCmd(workerID, id string) error {
+	key := workerID + "/" + id
+	v, _ := b.db.Get(_cmdQueueKey, key)
+	if v == nil {
+		return fmt.Errorf("invalid queued command %s of worker %s", id, workerID)
+	}
+	return b.db.Delete(_cmdQueueKey, key)
+}
+
 func (b *kvDBAdapter) Close() error {
 	if b.db != nil {
 		return b.db.Close()
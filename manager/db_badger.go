@@ -46,6 +46,59 @@ func (b *badgerAdapter) GetAll(bucket string) (m map[string][]byte, err error) {
 	return
 }
 
+func (b *badgerAdapter) GetPrefix(bucket string, prefix string) (m map[string][]byte, err error) {
+	b.db.View(func(tx *badger.Txn) error {
+		it := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		p := []byte(bucket + prefix)
+		m = make(map[string][]byte)
+		for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+			item := it.Item()
+			k := string(item.Key())
+			actualKey := k[len(bucket):]
+
+			var v []byte
+			v, err = item.ValueCopy(nil)
+			m[actualKey] = v
+		}
+		return nil
+	})
+	return
+}
+
+func (b *badgerAdapter) ScanRange(bucket string, start, end string, fn func(key string, value []byte) bool) error {
+	return b.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = true
+		opts.Prefix = []byte(bucket)
+		it := tx.NewIterator(opts)
+		defer it.Close()
+		// a reverse seek lands on the last key not after the one given
+		seek := []byte(bucket + end)
+		if end == "" {
+			seek = append([]byte(bucket), 0xff)
+		}
+		for it.Seek(seek); it.ValidForPrefix(opts.Prefix); it.Next() {
+			item := it.Item()
+			k := string(item.Key())[len(bucket):]
+			if end != "" && k >= end {
+				continue
+			}
+			if k < start {
+				break
+			}
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if !fn(k, v) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
 func (b *badgerAdapter) Put(bucket string, key string, value []byte) error {
 	err := b.db.Update(func(tx *badger.Txn) error {
 		err := tx.Set([]byte(bucket+key), value)
@@ -1,6 +1,7 @@
 package manager
 
 import (
+	"bytes"
 	"fmt"
 
 	bolt "go.etcd.io/bbolt"
@@ -43,6 +44,40 @@ func (b *boltAdapter) GetAll(bucket string) (m map[string][]byte, err error) {
 	return
 }
 
+func (b *boltAdapter) GetPrefix(bucket string, prefix string) (m map[string][]byte, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucket))
+		c := bucket.Cursor()
+		m = make(map[string][]byte)
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			m[string(k)] = v
+		}
+		return nil
+	})
+	return
+}
+
+func (b *boltAdapter) ScanRange(bucket string, start, end string, fn func(key string, value []byte) bool) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(bucket)).Cursor()
+		var k, v []byte
+		if end == "" {
+			k, v = c.Last()
+		} else if k, v = c.Seek([]byte(end)); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil && string(k) >= start; k, v = c.Prev() {
+			if !fn(string(k), v) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
 func (b *boltAdapter) Put(bucket string, key string, value []byte) error {
 	err := b.db.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(bucket))
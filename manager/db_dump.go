@@ -0,0 +1,367 @@
+package manager
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// version of the dump format, bump it on incompatible changes
+const dbDumpVersion = 1
+
+// kinds of the lines in a dump
+const (
+	dumpHeader       = "header"
+	dumpWorker       = "worker"
+	dumpMirrorStatus = "mirror_status"
+	dumpSyncRecord   = "sync_record"
+	dumpAuditRecord  = "audit_record"
+)
+
+// a dumpEntry is one line of a dump, which is a stream of
+// newline-delimited JSON objects starting with a header
+type dumpEntry struct {
+	Kind       string        `json:"kind"`
+	Version    int           `json:"version,omitempty"`
+	Time       *time.Time    `json:"time,omitempty"`
+	Worker     *WorkerStatus `json:"worker,omitempty"`
+	Status     *MirrorStatus `json:"mirror_status,omitempty"`
+	SyncRecord *SyncRecord   `json:"sync_record,omitempty"`
+	Audit      *AuditRecord  `json:"audit_record,omitempty"`
+}
+
+// DBSnapshot is the content of a manager database
+type DBSnapshot struct {
+	Workers      []WorkerStatus
+	Status       []MirrorStatus
+	SyncRecords  []SyncRecord
+	AuditRecords []AuditRecord
+}
+
+// DBStats counts the entries of a snapshot
+type DBStats struct {
+	Workers      int
+	Status       int
+	SyncRecords  int
+	AuditRecords int
+}
+
+func (s *DBSnapshot) Stats() DBStats {
+	return DBStats{len(s.Workers), len(s.Status), len(s.SyncRecords), len(s.AuditRecords)}
+}
+
+func (st DBStats) String() string {
+	return fmt.Sprintf("%d workers, %d mirror status, %d sync records, %d audit records",
+		st.Workers, st.Status, st.SyncRecords, st.AuditRecords)
+}
+
+func (st DBStats) empty() bool {
+	return st == DBStats{}
+}
+
+// readSnapshot reads everything in db, sorted so that two snapshots
+// of the same data are identical
+func readSnapshot(db dbAdapter) (*DBSnapshot, error) {
+	var s DBSnapshot
+	var err error
+	if s.Workers, err = db.ListWorkers(); err != nil {
+		return nil, fmt.Errorf("list workers: %s", err.Error())
+	}
+	if s.Status, err = db.ListAllMirrorStatus(); err != nil {
+		return nil, fmt.Errorf("list mirror status: %s", err.Error())
+	}
+	sort.Slice(s.Workers, func(i, j int) bool {
+		return s.Workers[i].ID < s.Workers[j].ID
+	})
+	sort.Slice(s.Status, func(i, j int) bool {
+		a, b := s.Status[i], s.Status[j]
+		if a.Worker != b.Worker {
+			return a.Worker < b.Worker
+		}
+		return a.Name < b.Name
+	})
+	// the sync records are reached through the mirrors they belong to
+	for _, m := range s.Status {
+		rs, err := db.ListSyncRecords(m.Worker, m.Name, time.Time{}, time.Time{}, 0)
+		if err != nil {
+			return nil, fmt.Errorf("list sync records of %s/%s: %s", m.Worker, m.Name, err.Error())
+		}
+		// oldest first
+		for i := len(rs) - 1; i >= 0; i-- {
+			s.SyncRecords = append(s.SyncRecords, rs[i])
+		}
+	}
+	audit, err := db.ListAuditRecords(auditFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("list audit records: %s", err.Error())
+	}
+	for i := len(audit) - 1; i >= 0; i-- {
+		s.AuditRecords = append(s.AuditRecords, audit[i])
+	}
+	return &s, nil
+}
+
+// writeSnapshot stores everything in s into db
+func writeSnapshot(db dbAdapter, s *DBSnapshot) error {
+	for _, w := range s.Workers {
+		w.Online = false
+		if _, err := db.CreateWorker(w); err != nil {
+			return fmt.Errorf("create worker %s: %s", w.ID, err.Error())
+		}
+	}
+	for _, m := range s.Status {
+		if _, err := db.UpdateMirrorStatus(m.Worker, m.Name, m); err != nil {
+			return fmt.Errorf("update mirror status %s/%s: %s", m.Worker, m.Name, err.Error())
+		}
+	}
+	for _, r := range s.SyncRecords {
+		if err := db.AddSyncRecord(r.Worker, r.Name, r); err != nil {
+			return fmt.Errorf("add sync record %s/%s: %s", r.Worker, r.Name, err.Error())
+		}
+	}
+	for _, r := range s.AuditRecords {
+		if err := db.AddAuditRecord(r); err != nil {
+			return fmt.Errorf("add audit record: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// Encode writes s to w as newline-delimited JSON
+func (s *DBSnapshot) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	now := time.Now()
+	if err := enc.Encode(dumpEntry{Kind: dumpHeader, Version: dbDumpVersion, Time: &now}); err != nil {
+		return err
+	}
+	for i := range s.Workers {
+		if err := enc.Encode(dumpEntry{Kind: dumpWorker, Worker: &s.Workers[i]}); err != nil {
+			return err
+		}
+	}
+	for i := range s.Status {
+		if err := enc.Encode(dumpEntry{Kind: dumpMirrorStatus, Status: &s.Status[i]}); err != nil {
+			return err
+		}
+	}
+	for i := range s.SyncRecords {
+		if err := enc.Encode(dumpEntry{Kind: dumpSyncRecord, SyncRecord: &s.SyncRecords[i]}); err != nil {
+			return err
+		}
+	}
+	for i := range s.AuditRecords {
+		if err := enc.Encode(dumpEntry{Kind: dumpAuditRecord, Audit: &s.AuditRecords[i]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecodeDBSnapshot reads a snapshot written by Encode
+func DecodeDBSnapshot(r io.Reader) (*DBSnapshot, error) {
+	var s DBSnapshot
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e dumpEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		if line == 1 {
+			if e.Kind != dumpHeader {
+				return nil, errors.New("not a tunasync manager database dump")
+			}
+			if e.Version > dbDumpVersion {
+				return nil, fmt.Errorf("dump version %d is newer than %d", e.Version, dbDumpVersion)
+			}
+			continue
+		}
+		switch {
+		case e.Kind == dumpWorker && e.Worker != nil:
+			s.Workers = append(s.Workers, *e.Worker)
+		case e.Kind == dumpMirrorStatus && e.Status != nil:
+			s.Status = append(s.Status, *e.Status)
+		case e.Kind == dumpSyncRecord && e.SyncRecord != nil:
+			s.SyncRecords = append(s.SyncRecords, *e.SyncRecord)
+		case e.Kind == dumpAuditRecord && e.Audit != nil:
+			s.AuditRecords = append(s.AuditRecords, *e.Audit)
+		default:
+			return nil, fmt.Errorf("line %d: invalid entry of kind '%s'", line, e.Kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if line == 0 {
+		return nil, errors.New("empty dump")
+	}
+	return &s, nil
+}
+
+// the times are compared in UTC, since the backends do not keep
+// the time zones alike
+func canonicalWorker(w WorkerStatus) WorkerStatus {
+	w.LastOnline = w.LastOnline.UTC()
+	w.LastRegister = w.LastRegister.UTC()
+	w.Online = false
+	return w
+}
+
+func canonicalMirrorStatus(m MirrorStatus) MirrorStatus {
+	m.LastUpdate = m.LastUpdate.UTC()
+	m.LastStarted = m.LastStarted.UTC()
+	m.LastEnded = m.LastEnded.UTC()
+	m.Scheduled = m.Scheduled.UTC()
+	return m
+}
+
+func canonicalSyncRecord(r SyncRecord) SyncRecord {
+	r.Started = r.Started.UTC()
+	r.Ended = r.Ended.UTC()
+	return r
+}
+
+func canonicalAuditRecord(r AuditRecord) AuditRecord {
+	r.Time = r.Time.UTC()
+	return r
+}
+
+func sameJSON(a, b interface{}) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
+
+// verifySnapshot checks that everything in s is found unchanged in db
+func verifySnapshot(db dbAdapter, s *DBSnapshot) error {
+	got, err := readSnapshot(db)
+	if err != nil {
+		return err
+	}
+
+	workers := make(map[string]WorkerStatus)
+	for _, w := range got.Workers {
+		workers[w.ID] = canonicalWorker(w)
+	}
+	for _, w := range s.Workers {
+		if v, ok := workers[w.ID]; !ok || !sameJSON(v, canonicalWorker(w)) {
+			return fmt.Errorf("worker %s mismatches", w.ID)
+		}
+	}
+
+	status := make(map[string]MirrorStatus)
+	for _, m := range got.Status {
+		status[m.Worker+"/"+m.Name] = canonicalMirrorStatus(m)
+	}
+	for _, m := range s.Status {
+		if v, ok := status[m.Worker+"/"+m.Name]; !ok || !sameJSON(v, canonicalMirrorStatus(m)) {
+			return fmt.Errorf("mirror status %s/%s mismatches", m.Worker, m.Name)
+		}
+	}
+
+	records := make(map[string]SyncRecord)
+	recordKey := func(r SyncRecord) string {
+		return syncRecordKey(r.Worker, r.Name, r.Started)
+	}
+	for _, r := range got.SyncRecords {
+		records[recordKey(r)] = canonicalSyncRecord(r)
+	}
+	for _, r := range s.SyncRecords {
+		if v, ok := records[recordKey(r)]; !ok || !sameJSON(v, canonicalSyncRecord(r)) {
+			return fmt.Errorf("sync record %s/%s started at %s mismatches",
+				r.Worker, r.Name, r.Started.Format(time.RFC3339))
+		}
+	}
+
+	audit := make(map[string]int)
+	for _, r := range got.AuditRecords {
+		b, _ := json.Marshal(canonicalAuditRecord(r))
+		audit[string(b)]++
+	}
+	for _, r := range s.AuditRecords {
+		b, _ := json.Marshal(canonicalAuditRecord(r))
+		if audit[string(b)] == 0 {
+			return fmt.Errorf("audit record of %s at %s mismatches",
+				r.Action, r.Time.Format(time.RFC3339))
+		}
+		audit[string(b)]--
+	}
+	return nil
+}
+
+// DumpDB writes the content of a manager database to w
+func DumpDB(dbType, dbFile string, w io.Writer) (DBStats, error) {
+	db, err := makeDBAdapter(dbType, dbFile)
+	if err != nil {
+		return DBStats{}, err
+	}
+	defer db.Close()
+
+	s, err := readSnapshot(db)
+	if err != nil {
+		return DBStats{}, err
+	}
+	return s.Stats(), s.Encode(w)
+}
+
+// RestoreDB writes a snapshot into a manager database and verifies it.
+// Unless force is set, the database must be empty.
+func RestoreDB(dbType, dbFile string, s *DBSnapshot, force bool) (DBStats, error) {
+	db, err := makeDBAdapter(dbType, dbFile)
+	if err != nil {
+		return DBStats{}, err
+	}
+	defer db.Close()
+	return restoreSnapshot(db, s, force)
+}
+
+func restoreSnapshot(db dbAdapter, s *DBSnapshot, force bool) (DBStats, error) {
+	if !force {
+		existing, err := readSnapshot(db)
+		if err != nil {
+			return DBStats{}, err
+		}
+		if st := existing.Stats(); !st.empty() {
+			return DBStats{}, fmt.Errorf("the database is not empty (%s)", st)
+		}
+	}
+	if err := writeSnapshot(db, s); err != nil {
+		return DBStats{}, err
+	}
+	if err := verifySnapshot(db, s); err != nil {
+		return DBStats{}, fmt.Errorf("verification failed: %s", err.Error())
+	}
+	return s.Stats(), nil
+}
+
+// CopyDB copies the content of a manager database into another one
+// and verifies it
+func CopyDB(srcType, srcFile, dstType, dstFile string, force bool) (DBStats, error) {
+	src, err := makeDBAdapter(srcType, srcFile)
+	if err != nil {
+		return DBStats{}, fmt.Errorf("open source database: %s", err.Error())
+	}
+	defer src.Close()
+	s, err := readSnapshot(src)
+	if err != nil {
+		return DBStats{}, err
+	}
+
+	dst, err := makeDBAdapter(dstType, dstFile)
+	if err != nil {
+		return DBStats{}, fmt.Errorf("open destination database: %s", err.Error())
+	}
+	defer dst.Close()
+	return restoreSnapshot(dst, s, force)
+}
@@ -0,0 +1,131 @@
+package manager
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestDBDump(t *testing.T) {
+	Convey("Dump, restore and copy the database", t, func() {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+
+		srcFile := filepath.Join(tmpDir, "src.db")
+		src, err := makeDBAdapter("bolt", srcFile)
+		So(err, ShouldBeNil)
+
+		now := time.Now()
+		for _, id := range []string{"worker1", "worker2"} {
+			_, err := src.CreateWorker(WorkerStatus{
+				ID: id, URL: "http://" + id + "/", Token: "token-" + id,
+				LastOnline: now, LastRegister: now.Add(-time.Hour),
+			})
+			So(err, ShouldBeNil)
+			_, err = src.UpdateMirrorStatus(id, "debian", MirrorStatus{
+				Name: "debian", Worker: id, IsMaster: id == "worker1", Status: Success,
+				LastUpdate: now, LastStarted: now.Add(-time.Minute), LastEnded: now,
+				Upstream: "rsync://debian.org/debian/", Size: "1.2T",
+			})
+			So(err, ShouldBeNil)
+			for i := 0; i < 3; i++ {
+				started := now.Add(-time.Duration(i) * time.Hour)
+				err := src.AddSyncRecord(id, "debian", SyncRecord{
+					Name: "debian", Worker: id, Status: Success,
+					Started: started, Ended: started.Add(time.Minute), Size: "1.2T",
+				})
+				So(err, ShouldBeNil)
+			}
+		}
+		err = src.AddAuditRecord(AuditRecord{
+			Time: now, Action: "disable", Source: "127.0.0.1", Identity: "admin",
+			WorkerID: "worker1", MirrorID: "debian", Code: 200, Message: "command sent",
+		})
+		So(err, ShouldBeNil)
+		// closed so that CopyDB can open it again
+		So(src.Close(), ShouldBeNil)
+
+		var buf bytes.Buffer
+		stats, err := DumpDB("bolt", srcFile, &buf)
+		So(err, ShouldBeNil)
+		So(stats, ShouldResemble, DBStats{Workers: 2, Status: 2, SyncRecords: 6, AuditRecords: 1})
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		So(lines, ShouldHaveLength, 12)
+		So(lines[0], ShouldStartWith, `{"kind":"header","version":1`)
+
+		snapshot, err := DecodeDBSnapshot(bytes.NewReader(buf.Bytes()))
+		So(err, ShouldBeNil)
+		So(snapshot.Stats(), ShouldResemble, stats)
+
+		Convey("restore into other backends", func() {
+			for _, dbType := range []string{"badger", "leveldb", "sqlite"} {
+				dbFile := filepath.Join(tmpDir, "restored."+dbType)
+				stats, err := RestoreDB(dbType, dbFile, snapshot, false)
+				So(err, ShouldBeNil)
+				So(stats, ShouldResemble, DBStats{Workers: 2, Status: 2, SyncRecords: 6, AuditRecords: 1})
+
+				db, err := makeDBAdapter(dbType, dbFile)
+				So(err, ShouldBeNil)
+				m, err := db.GetMirrorStatus("worker1", "debian")
+				So(err, ShouldBeNil)
+				So(m.IsMaster, ShouldBeTrue)
+				So(m.LastUpdate.Equal(now), ShouldBeTrue)
+				rs, err := db.ListSyncRecords("worker2", "debian", time.Time{}, time.Time{}, 0)
+				So(err, ShouldBeNil)
+				So(rs, ShouldHaveLength, 3)
+				So(db.Close(), ShouldBeNil)
+			}
+		})
+
+		Convey("refuse to restore into a database which is not empty", func() {
+			dbFile := filepath.Join(tmpDir, "restored.sqlite")
+			_, err := RestoreDB("sqlite", dbFile, snapshot, false)
+			So(err, ShouldBeNil)
+			_, err = RestoreDB("sqlite", dbFile, snapshot, false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not empty")
+			_, err = RestoreDB("sqlite", dbFile, snapshot, true)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("copy to another backend", func() {
+			dstFile := filepath.Join(tmpDir, "dst.sqlite")
+			stats, err := CopyDB("bolt", srcFile, "sqlite", dstFile, false)
+			So(err, ShouldBeNil)
+			So(stats, ShouldResemble, DBStats{Workers: 2, Status: 2, SyncRecords: 6, AuditRecords: 1})
+		})
+
+		Convey("detect mismatches", func() {
+			db, err := makeDBAdapter("bolt", srcFile)
+			So(err, ShouldBeNil)
+			defer db.Close()
+			So(verifySnapshot(db, snapshot), ShouldBeNil)
+
+			_, err = db.UpdateMirrorStatus("worker2", "debian", MirrorStatus{
+				Name: "debian", Worker: "worker2", Status: Failed,
+			})
+			So(err, ShouldBeNil)
+			err = verifySnapshot(db, snapshot)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "worker2/debian")
+		})
+
+		Convey("reject invalid dumps", func() {
+			_, err := DecodeDBSnapshot(strings.NewReader(""))
+			So(err, ShouldNotBeNil)
+			_, err = DecodeDBSnapshot(strings.NewReader(lines[1] + "\n"))
+			So(err, ShouldNotBeNil)
+			_, err = DecodeDBSnapshot(strings.NewReader(`{"kind":"header","version":99}` + "\n"))
+			So(err, ShouldNotBeNil)
+			_, err = DecodeDBSnapshot(strings.NewReader(lines[0] + "\n" + `{"kind":"worker"}` + "\n"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
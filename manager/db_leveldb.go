@@ -36,6 +36,38 @@ func (b *leveldbAdapter) GetAll(bucket string) (m map[string][]byte, err error)
 	return
 }
 
+func (b *leveldbAdapter) GetPrefix(bucket string, prefix string) (m map[string][]byte, err error) {
+	it := b.db.NewIterator(util.BytesPrefix([]byte(bucket+prefix)), nil)
+	defer it.Release()
+	m = make(map[string][]byte)
+	for it.Next() {
+		k := string(it.Key())
+		actualKey := k[len(bucket):]
+		// it.Value() changes on next iteration
+		val := it.Value()
+		v := make([]byte, len(val))
+		copy(v, val)
+		m[actualKey] = v
+	}
+	return
+}
+
+func (b *leveldbAdapter) ScanRange(bucket string, start, end string, fn func(key string, value []byte) bool) error {
+	r := util.BytesPrefix([]byte(bucket))
+	r.Start = []byte(bucket + start)
+	if end != "" {
+		r.Limit = []byte(bucket + end)
+	}
+	it := b.db.NewIterator(r, nil)
+	defer it.Release()
+	for ok := it.Last(); ok; ok = it.Prev() {
+		if !fn(string(it.Key())[len(bucket):], it.Value()) {
+			break
+		}
+	}
+	return it.Error()
+}
+
 func (b *leveldbAdapter) Put(bucket string, key string, value []byte) error {
 	err := b.db.Put([]byte(bucket+key), []byte(value), nil)
 	return err
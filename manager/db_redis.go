@@ -2,6 +2,8 @@ package manager
 
 import (
 	"context"
+	"sort"
+	"strings"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -13,6 +15,10 @@ type redisAdapter struct {
 
 var ctx = context.Background()
 
+var redisGlobEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`,
+)
+
 func (b *redisAdapter) InitBucket(bucket string) (err error) {
 	// no-op
 	return
@@ -39,6 +45,60 @@ func (b *redisAdapter) GetAll(bucket string) (m map[string][]byte, err error) {
 	return
 }
 
+func (b *redisAdapter) GetPrefix(bucket string, prefix string) (m map[string][]byte, err error) {
+	// escape the glob-style pattern characters of HSCAN MATCH
+	pattern := redisGlobEscaper.Replace(prefix) + "*"
+	m = make(map[string][]byte)
+	iter := b.db.HScan(ctx, bucket, 0, pattern, 0).Iterator()
+	for iter.Next(ctx) {
+		k := iter.Val()
+		if !iter.Next(ctx) {
+			break
+		}
+		m[k] = []byte(iter.Val())
+	}
+	err = iter.Err()
+	return
+}
+
+// how many values are fetched at once by ScanRange
+const redisScanBatch = 100
+
+func (b *redisAdapter) ScanRange(bucket string, start, end string, fn func(key string, value []byte) bool) error {
+	// the fields of a hash are not ordered, so the keys are sorted
+	// here and the values fetched in batches as they are walked
+	keys, err := b.db.HKeys(ctx, bucket).Result()
+	if err != nil {
+		return err
+	}
+	inRange := keys[:0]
+	for _, k := range keys {
+		if k >= start && (end == "" || k < end) {
+			inRange = append(inRange, k)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(inRange)))
+
+	for i := 0; i < len(inRange); i += redisScanBatch {
+		batch := inRange[i:min(i+redisScanBatch, len(inRange))]
+		vals, err := b.db.HMGet(ctx, bucket, batch...).Result()
+		if err != nil {
+			return err
+		}
+		for j, v := range vals {
+			// deleted meanwhile
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			if !fn(batch[j], []byte(s)) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
 func (b *redisAdapter) Put(bucket string, key string, value []byte) error {
 	_, err := b.db.HSet(ctx, bucket, key, string(value)).Result()
 	return err
@@ -0,0 +1,654 @@
+package manager
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// schema migrations of the sql database, applied in order;
+// never modify a released one, append a new migration instead
+var sqlMigrations = [][]string{
+	// 1: initial schema
+	{
+		`CREATE TABLE workers (
+			id            TEXT PRIMARY KEY,
+			url           TEXT NOT NULL,
+			token         TEXT NOT NULL,
+			last_online   BIGINT NOT NULL,
+			last_register BIGINT NOT NULL
+		)`,
+		`CREATE TABLE mirror_status (
+			worker        TEXT NOT NULL,
+			mirror        TEXT NOT NULL,
+			is_master     BOOLEAN NOT NULL,
+			status        TEXT NOT NULL,
+			last_update   BIGINT NOT NULL,
+			last_started  BIGINT NOT NULL,
+			last_ended    BIGINT NOT NULL,
+			next_schedule BIGINT NOT NULL,
+			upstream      TEXT NOT NULL,
+			size          TEXT NOT NULL,
+			error_msg     TEXT NOT NULL,
+			PRIMARY KEY (worker, mirror)
+		)`,
+		`CREATE TABLE sync_records (
+			worker    TEXT NOT NULL,
+			mirror    TEXT NOT NULL,
+			started   BIGINT NOT NULL,
+			ended     BIGINT NOT NULL,
+			status    TEXT NOT NULL,
+			size      TEXT NOT NULL,
+			error_msg TEXT NOT NULL,
+			PRIMARY KEY (worker, mirror, started)
+		)`,
+	},
+	// 2: audit log
+	{
+		`CREATE TABLE audit_log (
+			id       TEXT PRIMARY KEY,
+			time     BIGINT NOT NULL,
+			action   TEXT NOT NULL,
+			source   TEXT NOT NULL,
+			identity TEXT NOT NULL,
+			worker   TEXT NOT NULL,
+			mirror   TEXT NOT NULL,
+			args     TEXT NOT NULL,
+			code     INTEGER NOT NULL,
+			message  TEXT NOT NULL
+		)`,
+		`CREATE INDEX audit_log_time ON audit_log (time)`,
+	},
+	// 3: sync interval reported by the workers
+	{
+		`ALTER TABLE mirror_status ADD COLUMN sync_interval INTEGER NOT NULL DEFAULT 0`,
+	},
+	// 4: commands queued for the unreachable workers
+	{
+		`CREATE TABLE cmd_queue (
+			worker   TEXT NOT NULL,
+			id       TEXT NOT NULL,
+			cmd      TEXT NOT NULL,
+			identity TEXT NOT NULL,
+			created  BIGINT NOT NULL,
+			expires  BIGINT NOT NULL,
+			PRIMARY KEY (worker, id)
+		)`,
+	},
+	// 5: workers polling the manager for commands
+	{
+		`ALTER TABLE workers ADD COLUMN pull BOOLEAN NOT NULL DEFAULT FALSE`,
+	},
+	// 6: why the jobs wait for their sync windows
+	{
+		`ALTER TABLE mirror_status ADD COLUMN deferred TEXT NOT NULL DEFAULT ''`,
+	},
+}
+
+// sqlAdapter stores the data in the tables of a sql database,
+// the times are stored as unix nanoseconds
+type sqlAdapter struct {
+	db     *sql.DB
+	dbType string
+}
+
+func makeSQLAdapter(dbType, dsn string) (*sqlAdapter, error) {
+	driver := map[string]string{
+		"sqlite":   "sqlite",
+		"postgres": "pgx",
+	}[dbType]
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dbType == "sqlite" {
+		// sqlite allows only one writer at a time
+		db.SetMaxOpenConns(1)
+	}
+	return &sqlAdapter{db: db, dbType: dbType}, nil
+}
+
+// rebind replaces the ? placeholders for postgres
+func (b *sqlAdapter) rebind(query string) string {
+	if b.dbType != "postgres" {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+		} else {
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
+func (b *sqlAdapter) exec(query string, args ...interface{}) (sql.Result, error) {
+	return b.db.Exec(b.rebind(query), args...)
+}
+
+func (b *sqlAdapter) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return b.db.Query(b.rebind(query), args...)
+}
+
+func (b *sqlAdapter) Init() error {
+	if err := b.db.Ping(); err != nil {
+		return fmt.Errorf("connect to %s database error: %s", b.dbType, err.Error())
+	}
+	if err := b.migrate(); err != nil {
+		return fmt.Errorf("migrate %s database error: %s", b.dbType, err.Error())
+	}
+	return nil
+}
+
+// migrate applies the pending schema migrations
+func (b *sqlAdapter) migrate() error {
+	_, err := b.exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	row := b.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)
+	if err := row.Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqlMigrations) {
+		return fmt.Errorf("database schema version %d is newer than %d", version, len(sqlMigrations))
+	}
+
+	for i := version; i < len(sqlMigrations); i++ {
+		tx, err := b.db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, stmt := range sqlMigrations[i] {
+			if _, err := tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d: %s", i+1, err.Error())
+			}
+		}
+		_, err = tx.Exec(b.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`),
+			i+1, time.Now().Unix())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		logger.Noticef("Applied migration %d of the %s database", i+1, b.dbType)
+	}
+	return nil
+}
+
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+func parseSyncStatus(s string) (status SyncStatus, err error) {
+	err = json.Unmarshal([]byte(strconv.Quote(s)), &status)
+	return
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+const workerColumns = `id, url, token, last_online, last_register, pull`
+
+func scanWorker(row rowScanner) (w WorkerStatus, err error) {
+	var lastOnline, lastRegister int64
+	err = row.Scan(&w.ID, &w.URL, &w.Token, &lastOnline, &lastRegister, &w.Pull)
+	w.LastOnline = fromUnixNano(lastOnline)
+	w.LastRegister = fromUnixNano(lastRegister)
+	return
+}
+
+func (b *sqlAdapter) ListWorkers() (ws []WorkerStatus, err error) {
+	rows, err := b.query(`SELECT ` + workerColumns + ` FROM workers ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		w, err := scanWorker(rows)
+		if err != nil {
+			return nil, err
+		}
+		ws = append(ws, w)
+	}
+	return ws, rows.Err()
+}
+
+func (b *sqlAdapter) GetWorker(workerID string) (w WorkerStatus, err error) {
+	row := b.db.QueryRow(b.rebind(`SELECT `+workerColumns+` FROM workers WHERE id = ?`), workerID)
+	w, err = scanWorker(row)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("invalid workerID %s", workerID)
+	}
+	return
+}
+
+func (b *sqlAdapter) DeleteWorker(workerID string) error {
+	res, err := b.exec(`DELETE FROM workers WHERE id = ?`, workerID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("invalid workerID %s", workerID)
+	}
+	return nil
+}
+
+func (b *sqlAdapter) CreateWorker(w WorkerStatus) (WorkerStatus, error) {
+	_, err := b.exec(`INSERT INTO workers (`+workerColumns+`) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET url = excluded.url, token = excluded.token,
+		last_online = excluded.last_online, last_register = excluded.last_register,
+		pull = excluded.pull`,
+		w.ID, w.URL, w.Token, toUnixNano(w.LastOnline), toUnixNano(w.LastRegister), w.Pull)
+	return w, err
+}
+
+func (b *sqlAdapter) RefreshWorker(workerID string) (w WorkerStatus, err error) {
+	_, err = b.exec(`UPDATE workers SET last_online = ? WHERE id = ?`,
+		time.Now().UnixNano(), workerID)
+	if err != nil {
+		return
+	}
+	return b.GetWorker(workerID)
+}
+
+const mirrorStatusColumns = `worker, mirror, is_master, status, last_update, last_started,
+	last_ended, next_schedule, upstream, size, error_msg, sync_interval, deferred`
+
+func scanMirrorStatus(row rowScanner) (m MirrorStatus, err error) {
+	var status string
+	var lastUpdate, lastStarted, lastEnded, scheduled int64
+	err = row.Scan(&m.Worker, &m.Name, &m.IsMaster, &status, &lastUpdate, &lastStarted,
+		&lastEnded, &scheduled, &m.Upstream, &m.Size, &m.ErrorMsg, &m.Interval, &m.Deferred)
+	if err != nil {
+		return
+	}
+	m.LastUpdate = fromUnixNano(lastUpdate)
+	m.LastStarted = fromUnixNano(lastStarted)
+	m.LastEnded = fromUnixNano(lastEnded)
+	m.Scheduled = fromUnixNano(scheduled)
+	m.Status, err = parseSyncStatus(status)
+	return
+}
+
+func (b *sqlAdapter) UpdateMirrorStatus(workerID, mirrorID string, status MirrorStatus) (MirrorStatus, error) {
+	_, err := b.exec(`INSERT INTO mirror_status (`+mirrorStatusColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (worker, mirror) DO UPDATE SET is_master = excluded.is_master,
+		status = excluded.status, last_update = excluded.last_update,
+		last_started = excluded.last_started, last_ended = excluded.last_ended,
+		next_schedule = excluded.next_schedule, upstream = excluded.upstream,
+		size = excluded.size, error_msg = excluded.error_msg,
+		sync_interval = excluded.sync_interval, deferred = excluded.deferred`,
+		workerID, mirrorID, status.IsMaster, status.Status.String(),
+		toUnixNano(status.LastUpdate), toUnixNano(status.LastStarted),
+		toUnixNano(status.LastEnded), toUnixNano(status.Scheduled),
+		status.Upstream, status.Size, status.ErrorMsg, status.Interval, status.Deferred)
+	return status, err
+}
+
+func (b *sqlAdapter) GetMirrorStatus(workerID, mirrorID string) (m MirrorStatus, err error) {
+	row := b.db.QueryRow(b.rebind(`SELECT `+mirrorStatusColumns+
+		` FROM mirror_status WHERE worker = ? AND mirror = ?`), workerID, mirrorID)
+	m, err = scanMirrorStatus(row)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("no mirror '%s' exists in worker '%s'", mirrorID, workerID)
+	}
+	return
+}
+
+func (b *sqlAdapter) listMirrorStatus(where string, args ...interface{}) (ms []MirrorStatus, err error) {
+	rows, err := b.query(`SELECT `+mirrorStatusColumns+` FROM mirror_status `+where+
+		` ORDER BY mirror, worker`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := scanMirrorStatus(rows)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, rows.Err()
+}
+
+func (b *sqlAdapter) ListMirrorStatus(workerID string) ([]MirrorStatus, error) {
+	return b.listMirrorStatus(`WHERE worker = ?`, workerID)
+}
+
+func (b *sqlAdapter) ListAllMirrorStatus() ([]MirrorStatus, error) {
+	return b.listMirrorStatus(``)
+}
+
+func (b *sqlAdapter) QueryMirrorStatus(filter jobFilter) ([]MirrorStatus, error) {
+	where := `WHERE 1 = 1`
+	var args []interface{}
+	// the condition is written before its arguments are added
+	in := func(column, op string, values []string) string {
+		for _, v := range values {
+			args = append(args, v)
+		}
+		return column + op + `(?` + strings.Repeat(`, ?`, len(values)-1) + `)`
+	}
+	if len(filter.Statuses) > 0 {
+		var statuses, held []string
+		unknown := false
+		for _, status := range filter.Statuses {
+			statuses = append(statuses, status.String())
+			switch status {
+			case Disabled, Paused:
+				held = append(held, status.String())
+			case Unknown:
+				unknown = true
+			}
+		}
+		if len(filter.Offline) == 0 {
+			where += ` AND ` + in(`status`, ` IN `, statuses)
+		} else {
+			// the jobs of the offline workers are shown unknown
+			// unless disabled or paused
+			where += ` AND ((` + in(`worker`, ` NOT IN `, filter.Offline)
+			where += ` AND ` + in(`status`, ` IN `, statuses) + `)`
+			if len(held) > 0 {
+				where += ` OR (` + in(`worker`, ` IN `, filter.Offline)
+				where += ` AND ` + in(`status`, ` IN `, held) + `)`
+			}
+			if unknown {
+				where += ` OR (` + in(`worker`, ` IN `, filter.Offline)
+				where += ` AND ` + in(`status`, ` NOT IN `, []string{Disabled.String(), Paused.String()}) + `)`
+			}
+			where += `)`
+		}
+	}
+	if len(filter.Workers) > 0 {
+		where += ` AND ` + in(`worker`, ` IN `, filter.Workers)
+	}
+	if filter.Name != "" {
+		if b.dbType == "postgres" {
+			where += ` AND mirror ~ ?`
+			args = append(args, globToRegexp(filter.Name))
+		} else {
+			where += ` AND mirror GLOB ?`
+			args = append(args, filter.Name)
+		}
+	}
+	if filter.IsMaster != nil {
+		where += ` AND is_master = ?`
+		args = append(args, *filter.IsMaster)
+	}
+	if !filter.StaleSince.IsZero() {
+		where += ` AND last_update < ?`
+		args = append(args, filter.StaleSince.UnixNano())
+	}
+
+	field, desc := filter.sortField()
+	columns := []string{`mirror`, `worker`}
+	switch field {
+	case "name":
+	case "worker":
+		columns = []string{`worker`, `mirror`}
+	default:
+		columns = append([]string{jobSortColumns[field]}, columns...)
+	}
+	key := `(` + strings.Join(columns, `, `) + `)`
+	if after := filter.After; after != nil {
+		op := ` > `
+		if desc {
+			op = ` < `
+		}
+		where += ` AND ` + key + op + `(?` + strings.Repeat(`, ?`, len(columns)-1) + `)`
+		for _, column := range columns {
+			switch column {
+			case `mirror`:
+				args = append(args, after.Name)
+			case `worker`:
+				args = append(args, after.Worker)
+			default:
+				args = append(args, after.Time)
+			}
+		}
+	}
+	order := strings.Join(columns, `, `)
+	if desc {
+		order = strings.Join(columns, ` DESC, `) + ` DESC`
+	}
+	query := `SELECT ` + mirrorStatusColumns + ` FROM mirror_status ` + where + ` ORDER BY ` + order
+	if filter.Limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(filter.Limit)
+	}
+
+	rows, err := b.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ms []MirrorStatus
+	for rows.Next() {
+		m, err := scanMirrorStatus(rows)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, rows.Err()
+}
+
+func (b *sqlAdapter) FlushDisabledJobs() error {
+	_, err := b.exec(`DELETE FROM mirror_status WHERE status = ? OR mirror = ''`,
+		Disabled.String())
+	return err
+}
+
+func (b *sqlAdapter) AddSyncRecord(workerID, mirrorID string, record SyncRecord) error {
+	_, err := b.exec(`INSERT INTO sync_records
+		(worker, mirror, started, ended, status, size, error_msg) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (worker, mirror, started) DO UPDATE SET ended = excluded.ended,
+		status = excluded.status, size = excluded.size, error_msg = excluded.error_msg`,
+		workerID, mirrorID, toUnixNano(record.Started), toUnixNano(record.Ended),
+		record.Status.String(), record.Size, record.ErrorMsg)
+	return err
+}
+
+func (b *sqlAdapter) ListSyncRecords(workerID, mirrorID string, since, until time.Time, limit int) (rs []SyncRecord, err error) {
+	query := `SELECT worker, mirror, started, ended, status, size, error_msg
+		FROM sync_records WHERE worker = ? AND mirror = ?`
+	args := []interface{}{workerID, mirrorID}
+	if !since.IsZero() {
+		query += ` AND started >= ?`
+		args = append(args, since.UnixNano())
+	}
+	if !until.IsZero() {
+		query += ` AND started < ?`
+		args = append(args, until.UnixNano())
+	}
+	query += ` ORDER BY started DESC`
+	if limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(limit)
+	}
+
+	rows, err := b.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r SyncRecord
+		var status string
+		var started, ended int64
+		err := rows.Scan(&r.Worker, &r.Name, &started, &ended, &status, &r.Size, &r.ErrorMsg)
+		if err != nil {
+			return nil, err
+		}
+		r.Started = fromUnixNano(started)
+		r.Ended = fromUnixNano(ended)
+		if r.Status, err = parseSyncStatus(status); err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	return rs, rows.Err()
+}
+
+func (b *sqlAdapter) PruneSyncRecords(workerID, mirrorID string, before time.Time) error {
+	_, err := b.exec(`DELETE FROM sync_records WHERE worker = ? AND mirror = ? AND ended < ?`,
+		workerID, mirrorID, before.UnixNano())
+	return err
+}
+
+func (b *sqlAdapter) AddAuditRecord(r AuditRecord) error {
+	args, err := json.Marshal(r.Args)
+	if err != nil {
+		return err
+	}
+	// the records are never updated
+	_, err = b.exec(`INSERT INTO audit_log
+		(id, time, action, source, identity, worker, mirror, args, code, message)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`,
+		auditRecordKey(r), toUnixNano(r.Time), r.Action, r.Source, r.Identity,
+		r.WorkerID, r.MirrorID, string(args), r.Code, r.Message)
+	return err
+}
+
+func (b *sqlAdapter) ListAuditRecords(filter auditFilter) (rs []AuditRecord, err error) {
+	query := `SELECT time, action, source, identity, worker, mirror, args, code, message
+		FROM audit_log WHERE 1 = 1`
+	var args []interface{}
+	if !filter.Since.IsZero() {
+		query += ` AND time >= ?`
+		args = append(args, filter.Since.UnixNano())
+	}
+	if !filter.Until.IsZero() {
+		query += ` AND time < ?`
+		args = append(args, filter.Until.UnixNano())
+	}
+	for _, f := range []struct{ column, value string }{
+		{"action", filter.Action},
+		{"worker", filter.WorkerID},
+		{"mirror", filter.MirrorID},
+		{"identity", filter.Identity},
+	} {
+		if f.value != "" {
+			query += ` AND ` + f.column + ` = ?`
+			args = append(args, f.value)
+		}
+	}
+	query += ` ORDER BY id DESC`
+	if filter.Limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(filter.Limit)
+	}
+
+	rows, err := b.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r AuditRecord
+		var t int64
+		var recordArgs string
+		err := rows.Scan(&t, &r.Action, &r.Source, &r.Identity, &r.WorkerID, &r.MirrorID,
+			&recordArgs, &r.Code, &r.Message)
+		if err != nil {
+			return nil, err
+		}
+		r.Time = fromUnixNano(t)
+		if err := json.Unmarshal([]byte(recordArgs), &r.Args); err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	return rs, rows.Err()
+}
+
+func (b *sqlAdapter) PruneAuditRecords(before time.Time) error {
+	_, err := b.exec(`DELETE FROM audit_log WHERE time < ?`, before.UnixNano())
+	return err
+}
+
+func (b *sqlAdapter) AddQueuedCmd(q QueuedCmd) error {
+	cmd, err := json.Marshal(q.Cmd)
+	if err != nil {
+		return err
+	}
+	_, err = b.exec(`INSERT INTO cmd_queue (worker, id, cmd, identity, created, expires)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		q.WorkerID, q.ID, string(cmd), q.Identity, toUnixNano(q.Created), toUnixNano(q.Expires))
+	return err
+}
+
+func (b *sqlAdapter) ListQueuedCmds(workerID string) (qs []QueuedCmd, err error) {
+	query := `SELECT worker, id, cmd, identity, created, expires FROM cmd_queue`
+	var args []interface{}
+	if workerID != "" {
+		query += ` WHERE worker = ?`
+		args = append(args, workerID)
+	}
+	query += ` ORDER BY id, worker`
+
+	rows, err := b.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var q QueuedCmd
+		var cmd string
+		var created, expires int64
+		if err := rows.Scan(&q.WorkerID, &q.ID, &cmd, &q.Identity, &created, &expires); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(cmd), &q.Cmd); err != nil {
+			return nil, err
+		}
+		q.Created, q.Expires = fromUnixNano(created), fromUnixNano(expires)
+		qs = append(qs, q)
+	}
+	return qs, rows.Err()
+}
+
+func (b *sqlAdapter) DeleteQueuedCmd(workerID, id string) error {
+	res, err := b.exec(`DELETE FROM cmd_queue WHERE worker = ? AND id = ?`, workerID, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("invalid queued command %s of worker %s", id, workerID)
+	}
+	return nil
+}
+
+func (b *sqlAdapter) Close() error {
+	return b.db.Close()
+}
@@ -30,14 +30,19 @@ func DBAdapterTest(db dbAdapter) {
 				Token:        "token_" + id,
 				LastOnline:   time.Now(),
 				LastRegister: time.Now(),
+				Pull:         id == testWorkerIDs[1],
 			}
 			_, err = db.CreateWorker(w)
 			So(err, ShouldBeNil)
 		}
 
 		Convey("get existent worker", func() {
-			_, err := db.GetWorker(testWorkerIDs[0])
+			w, err := db.GetWorker(testWorkerIDs[0])
 			So(err, ShouldBeNil)
+			So(w.Pull, ShouldBeFalse)
+			w, err = db.GetWorker(testWorkerIDs[1])
+			So(err, ShouldBeNil)
+			So(w.Pull, ShouldBeTrue)
 		})
 
 		Convey("list existent workers", func() {
@@ -93,6 +98,7 @@ func DBAdapterTest(db dbAdapter) {
 				LastEnded:   time.Now(),
 				Upstream:    "mirrors.tuna.tsinghua.edu.cn",
 				Size:        "4GB",
+				Deferred:    "in blackout Sat 02:00-04:00",
 			},
 			{
 				Name:        "arch-sync3",
@@ -146,6 +152,102 @@ func DBAdapterTest(db dbAdapter) {
 			So(string(actualJSON), ShouldEqual, string(expectedJSON))
 		})
 
+		Convey("query mirror status", func() {
+			names := func(filter jobFilter) []string {
+				ms, err := db.QueryMirrorStatus(filter)
+				So(err, ShouldBeNil)
+				ns := []string{}
+				for _, m := range ms {
+					ns = append(ns, m.Name)
+				}
+				return ns
+			}
+			isSlave := false
+			So(names(jobFilter{}), ShouldResemble, []string{"arch-sync1", "arch-sync2", "arch-sync3"})
+			So(names(jobFilter{Statuses: []SyncStatus{Success, Failed}}), ShouldResemble, []string{"arch-sync1", "arch-sync3"})
+			// the jobs of the offline workers are unknown unless disabled or paused
+			offline := []string{testWorkerIDs[1]}
+			So(names(jobFilter{Statuses: []SyncStatus{Success}, Offline: offline}), ShouldResemble, []string{"arch-sync1"})
+			So(names(jobFilter{Statuses: []SyncStatus{Unknown}, Offline: offline}), ShouldResemble, []string{"arch-sync3"})
+			So(names(jobFilter{Statuses: []SyncStatus{Disabled}, Offline: offline}), ShouldResemble, []string{"arch-sync2"})
+			So(names(jobFilter{Statuses: []SyncStatus{Success, Disabled, Unknown}, Offline: offline}),
+				ShouldResemble, []string{"arch-sync1", "arch-sync2", "arch-sync3"})
+			So(names(jobFilter{Workers: []string{testWorkerIDs[1]}}), ShouldResemble, []string{"arch-sync2", "arch-sync3"})
+			So(names(jobFilter{Name: "arch-sync[12]"}), ShouldResemble, []string{"arch-sync1", "arch-sync2"})
+			So(names(jobFilter{Name: "arch-sync3"}), ShouldResemble, []string{"arch-sync3"})
+			So(names(jobFilter{Name: "*-sync?", Workers: []string{testWorkerIDs[0]}}), ShouldResemble, []string{"arch-sync1"})
+			So(names(jobFilter{IsMaster: &isSlave}), ShouldBeEmpty)
+			So(names(jobFilter{StaleSince: time.Now().Add(-30 * time.Minute)}), ShouldResemble, []string{"arch-sync2"})
+			So(names(jobFilter{Sort: "worker"}), ShouldResemble, []string{"arch-sync1", "arch-sync2", "arch-sync3"})
+			So(names(jobFilter{Sort: "-name"}), ShouldResemble, []string{"arch-sync3", "arch-sync2", "arch-sync1"})
+
+			// paginated by the cursor
+			filter := jobFilter{Sort: "-last_update", Limit: 2}
+			ms, err := db.QueryMirrorStatus(filter)
+			So(err, ShouldBeNil)
+			So(ms, ShouldHaveLength, 2)
+			So(ms[0].Name, ShouldEqual, "arch-sync1")
+			So(ms[1].Name, ShouldEqual, "arch-sync3")
+			after := filter.cursorOf(ms[1])
+			filter.After = &after
+			So(names(filter), ShouldResemble, []string{"arch-sync2"})
+			after = jobCursor{Sort: "name", Name: "arch-sync1", Worker: testWorkerIDs[0]}
+			So(names(jobFilter{Sort: "name", After: &after}), ShouldResemble, []string{"arch-sync2", "arch-sync3"})
+		})
+
+		Convey("add and list sync records", func() {
+			now := time.Now()
+			for i := 0; i < 3; i++ {
+				started := now.Add(time.Duration(i-3) * time.Hour)
+				r := SyncRecord{
+					Name:    status[0].Name,
+					Worker:  status[0].Worker,
+					Status:  Success,
+					Started: started,
+					Ended:   started.Add(time.Minute),
+					Size:    "3GB",
+				}
+				err := db.AddSyncRecord(r.Worker, r.Name, r)
+				So(err, ShouldBeNil)
+			}
+			// a retry of the latest run overwrites its record
+			r := SyncRecord{
+				Name:     status[0].Name,
+				Worker:   status[0].Worker,
+				Status:   Failed,
+				Started:  now.Add(-time.Hour),
+				Ended:    now,
+				ErrorMsg: "rsync error",
+			}
+			err := db.AddSyncRecord(r.Worker, r.Name, r)
+			So(err, ShouldBeNil)
+
+			rs, err := db.ListSyncRecords(status[0].Worker, status[0].Name, time.Time{}, time.Time{}, 0)
+			So(err, ShouldBeNil)
+			So(len(rs), ShouldEqual, 3)
+			So(rs[0].Status, ShouldEqual, Failed)
+			So(rs[0].ErrorMsg, ShouldEqual, "rsync error")
+			So(rs[1].Started.Before(rs[0].Started), ShouldBeTrue)
+
+			rs, err = db.ListSyncRecords(status[0].Worker, status[0].Name, time.Time{}, time.Time{}, 2)
+			So(err, ShouldBeNil)
+			So(len(rs), ShouldEqual, 2)
+
+			rs, err = db.ListSyncRecords(status[0].Worker, status[0].Name, now.Add(-150*time.Minute), now, 0)
+			So(err, ShouldBeNil)
+			So(len(rs), ShouldEqual, 2)
+
+			rs, err = db.ListSyncRecords(status[1].Worker, status[1].Name, time.Time{}, time.Time{}, 0)
+			So(err, ShouldBeNil)
+			So(len(rs), ShouldEqual, 0)
+
+			err = db.PruneSyncRecords(status[0].Worker, status[0].Name, now.Add(-90*time.Minute))
+			So(err, ShouldBeNil)
+			rs, err = db.ListSyncRecords(status[0].Worker, status[0].Name, time.Time{}, time.Time{}, 0)
+			So(err, ShouldBeNil)
+			So(len(rs), ShouldEqual, 1)
+		})
+
 		Convey("flush disabled jobs", func() {
 			ms, err := db.ListAllMirrorStatus()
 			So(err, ShouldBeNil)
@@ -158,6 +260,105 @@ func DBAdapterTest(db dbAdapter) {
 		})
 
 	})
+
+	Convey("add and list audit records", func() {
+		now := time.Now()
+		records := []AuditRecord{
+			{Time: now.Add(-2 * time.Hour), Action: "disable", Source: "10.0.0.1", Identity: "alice",
+				WorkerID: "test_worker1", MirrorID: "arch-sync1", Code: 200, Message: "command sent"},
+			{Time: now.Add(-time.Hour), Action: auditSetSize, Source: "10.0.0.2", Identity: "bob",
+				WorkerID: "test_worker1", MirrorID: "arch-sync2", Args: []string{"1.2T"}, Code: 200},
+			{Time: now, Action: auditFlushDisabled, Source: "10.0.0.1", Identity: "alice", Code: 200},
+		}
+		for _, r := range records {
+			So(db.AddAuditRecord(r), ShouldBeNil)
+		}
+		// adding a record again changes nothing
+		So(db.AddAuditRecord(records[0]), ShouldBeNil)
+
+		rs, err := db.ListAuditRecords(auditFilter{})
+		So(err, ShouldBeNil)
+		So(rs, ShouldHaveLength, 3)
+		So(rs[0].Action, ShouldEqual, auditFlushDisabled)
+		So(rs[1].Args, ShouldResemble, []string{"1.2T"})
+		So(rs[2].Time.Equal(records[0].Time), ShouldBeTrue)
+
+		rs, err = db.ListAuditRecords(auditFilter{Identity: "alice", Limit: 1})
+		So(err, ShouldBeNil)
+		So(rs, ShouldHaveLength, 1)
+		So(rs[0].Action, ShouldEqual, auditFlushDisabled)
+
+		rs, err = db.ListAuditRecords(auditFilter{WorkerID: "test_worker1", Until: now})
+		So(err, ShouldBeNil)
+		So(rs, ShouldHaveLength, 2)
+
+		rs, err = db.ListAuditRecords(auditFilter{Since: now.Add(-90 * time.Minute), MirrorID: "arch-sync2", Action: auditSetSize})
+		So(err, ShouldBeNil)
+		So(rs, ShouldHaveLength, 1)
+		So(rs[0].Identity, ShouldEqual, "bob")
+
+		rs, err = db.ListAuditRecords(auditFilter{Since: records[1].Time, Until: records[2].Time})
+		So(err, ShouldBeNil)
+		So(rs, ShouldHaveLength, 1)
+		So(rs[0].Identity, ShouldEqual, "bob")
+
+		if kv, ok := db.(*kvDBAdapter); ok {
+			var keys []string
+			err := kv.db.ScanRange(_auditBucketKey, auditTimeKey(records[1].Time), "", func(k string, _ []byte) bool {
+				keys = append(keys, k)
+				return true
+			})
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{auditRecordKey(records[2]), auditRecordKey(records[1])})
+
+			keys = nil
+			err = kv.db.ScanRange(_auditBucketKey, "", auditTimeKey(records[2].Time), func(k string, _ []byte) bool {
+				keys = append(keys, k)
+				return false
+			})
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{auditRecordKey(records[1])})
+		}
+
+		So(db.PruneAuditRecords(now.Add(-90*time.Minute)), ShouldBeNil)
+		rs, err = db.ListAuditRecords(auditFilter{})
+		So(err, ShouldBeNil)
+		So(rs, ShouldHaveLength, 2)
+		So(rs[1].Identity, ShouldEqual, "bob")
+	})
+
+	Convey("queue commands", func() {
+		cmds := []QueuedCmd{
+			newQueuedCmd("test_worker1", WorkerCmd{Cmd: CmdDisable, MirrorID: "arch-sync1"}, "alice", time.Hour),
+			newQueuedCmd("test_worker2", WorkerCmd{Cmd: CmdReload}, "", time.Hour),
+			newQueuedCmd("test_worker1", WorkerCmd{Cmd: CmdStart, MirrorID: "arch-sync2",
+				Options: map[string]bool{"force": true}}, "bob", time.Minute),
+		}
+		for _, q := range cmds {
+			So(db.AddQueuedCmd(q), ShouldBeNil)
+		}
+
+		qs, err := db.ListQueuedCmds("test_worker1")
+		So(err, ShouldBeNil)
+		So(qs, ShouldHaveLength, 2)
+		So(qs[0].ID, ShouldEqual, cmds[0].ID)
+		So(qs[0].Identity, ShouldEqual, "alice")
+		So(qs[0].Cmd.Cmd, ShouldEqual, CmdDisable)
+		So(qs[1].Cmd.Options, ShouldResemble, map[string]bool{"force": true})
+		So(qs[1].Expires.Equal(cmds[2].Expires), ShouldBeTrue)
+
+		qs, err = db.ListQueuedCmds("")
+		So(err, ShouldBeNil)
+		So(qs, ShouldHaveLength, 3)
+		So(qs[1].WorkerID, ShouldEqual, "test_worker2")
+
+		So(db.DeleteQueuedCmd("test_worker1", cmds[0].ID), ShouldBeNil)
+		So(db.DeleteQueuedCmd("test_worker2", cmds[0].ID), ShouldNotBeNil)
+		qs, err = db.ListQueuedCmds("test_worker1")
+		So(err, ShouldBeNil)
+		So(qs, ShouldHaveLength, 1)
+		So(qs[0].ID, ShouldEqual, cmds[2].ID)
+	})
 }
 
 func TestDBAdapter(t *testing.T) {
@@ -232,4 +433,47 @@ func TestDBAdapter(t *testing.T) {
 
 		DBAdapterTest(leveldbDB)
 	})
+
+	Convey("sqliteAdapter should work", t, func() {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		defer os.RemoveAll(tmpDir)
+		So(err, ShouldBeNil)
+
+		dbType, dbFile := "sqlite", filepath.Join(tmpDir, "tunasync.sqlite")
+		sqliteDB, err := makeDBAdapter(dbType, dbFile)
+		So(err, ShouldBeNil)
+
+		defer func() {
+			// close sqliteDB
+			err := sqliteDB.Close()
+			So(err, ShouldBeNil)
+		}()
+
+		DBAdapterTest(sqliteDB)
+
+		Convey("migrations are applied only once", func() {
+			again, err := makeDBAdapter(dbType, dbFile)
+			So(err, ShouldBeNil)
+			defer again.Close()
+			var version int
+			err = again.(*sqlAdapter).db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, len(sqlMigrations))
+		})
+	})
+
+	// set TUNASYNC_TEST_POSTGRES to a database URL to test postgres
+	if dsn := os.Getenv("TUNASYNC_TEST_POSTGRES"); dsn != "" {
+		Convey("postgresAdapter should work", t, func() {
+			pgDB, err := makeDBAdapter("postgres", dsn)
+			So(err, ShouldBeNil)
+			defer pgDB.Close()
+			for _, table := range []string{"workers", "mirror_status", "sync_records"} {
+				_, err := pgDB.(*sqlAdapter).exec(`DELETE FROM ` + table)
+				So(err, ShouldBeNil)
+			}
+
+			DBAdapterTest(pgDB)
+		})
+	}
 }
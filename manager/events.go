@@ -0,0 +1,224 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+const (
+	// number of recent events kept for resuming clients
+	eventBacklogSize = 1024
+	// events buffered for a client before it is dropped
+	eventSubscriberBuffer = 64
+	eventKeepAlive        = 30 * time.Second
+)
+
+type eventSubscriber struct {
+	ch     chan Event
+	worker string
+	mirror string
+}
+
+func (sub *eventSubscriber) match(ev Event) bool {
+	if sub.worker != "" && ev.Worker != "" && ev.Worker != sub.worker {
+		return false
+	}
+	if sub.mirror != "" && ev.Mirror != "" && ev.Mirror != sub.mirror {
+		return false
+	}
+	return true
+}
+
+// eventHub fans status events out to the subscribed clients,
+// and keeps the recent events for the clients to resume from
+type eventHub struct {
+	sync.Mutex
+	nextID      uint64
+	backlog     []Event
+	subscribers map[*eventSubscriber]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		// start from the current time so that the IDs seen
+		// before a restart are not mistaken for new ones
+		nextID:      uint64(time.Now().UnixMicro()),
+		backlog:     make([]Event, 0, eventBacklogSize),
+		subscribers: make(map[*eventSubscriber]struct{}),
+	}
+}
+
+// Publish assigns an ID to ev and sends it to the subscribers,
+// slow subscribers are dropped
+func (h *eventHub) Publish(ev Event) {
+	h.Lock()
+	defer h.Unlock()
+	ev.ID = h.nextID
+	h.nextID++
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if len(h.backlog) == eventBacklogSize {
+		copy(h.backlog, h.backlog[1:])
+		h.backlog = h.backlog[:len(h.backlog)-1]
+	}
+	h.backlog = append(h.backlog, ev)
+
+	for sub := range h.subscribers {
+		if !sub.match(ev) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			logger.Warningf("Dropped slow event subscriber")
+			delete(h.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// Subscribe registers a new subscriber. If resume is set, the events
+// after lastID are returned as missed; if some of them are no longer
+// kept, reset is set and the client should fetch the full status again.
+func (h *eventHub) Subscribe(lastID uint64, resume bool, worker, mirror string) (sub *eventSubscriber, missed []Event, reset bool, latest uint64) {
+	h.Lock()
+	defer h.Unlock()
+	sub = &eventSubscriber{
+		ch:     make(chan Event, eventSubscriberBuffer),
+		worker: worker,
+		mirror: mirror,
+	}
+	h.subscribers[sub] = struct{}{}
+	latest = h.nextID - 1
+
+	if !resume || lastID == latest {
+		return
+	}
+	if lastID > latest || len(h.backlog) == 0 || h.backlog[0].ID > lastID+1 {
+		reset = true
+		return
+	}
+	for _, ev := range h.backlog {
+		if ev.ID > lastID && sub.match(ev) {
+			missed = append(missed, ev)
+		}
+	}
+	return
+}
+
+// Unsubscribe removes sub if it is not dropped yet
+func (h *eventHub) Unsubscribe(sub *eventSubscriber) {
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// Close drops all the subscribers, which may resume later
+func (h *eventHub) Close() {
+	h.Lock()
+	defer h.Unlock()
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// publishJobEvent publishes a job event carrying the mirror status
+func (s *Manager) publishJobEvent(eventType EventType, status MirrorStatus) {
+	s.events.Publish(Event{
+		Type:   eventType,
+		Worker: status.Worker,
+		Mirror: status.Name,
+		Status: &status,
+	})
+}
+
+// streamEvents pushes the status events to the client as server-sent events
+func (s *Manager) streamEvents(c *gin.Context) {
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	var lastID uint64
+	resume := lastEventID != ""
+	if resume {
+		var err error
+		lastID, err = strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			err := fmt.Errorf("invalid last event ID %s", lastEventID)
+			s.returnErrJSON(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	sub, missed, reset, latest := s.events.Subscribe(lastID, resume, c.Query("worker"), c.Query("mirror"))
+	defer s.events.Unsubscribe(sub)
+
+	// the stream lives longer than the write timeout of the server
+	rc := http.NewResponseController(c.Writer)
+	rc.SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	write := func(ev Event) bool {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			logger.Errorf("Failed to marshal event %d: %s", ev.ID, err.Error())
+			return true
+		}
+		if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data); err != nil {
+			return false
+		}
+		return true
+	}
+
+	if reset {
+		write(Event{ID: latest, Type: EventReset, Time: time.Now()})
+	}
+	for _, ev := range missed {
+		if !write(ev) {
+			return
+		}
+	}
+	rc.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev, ok := <-sub.ch:
+			if !ok {
+				// dropped for being slow or on shutdown, the client may resume later
+				return
+			}
+			if !write(ev) {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-c.Request.Context().Done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package manager
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestEventHub(t *testing.T) {
+	Convey("Event hub should work", t, func() {
+		h := newEventHub()
+
+		sub, missed, reset, latest := h.Subscribe(0, false, "", "")
+		So(missed, ShouldBeEmpty)
+		So(reset, ShouldBeFalse)
+
+		h.Publish(Event{Type: EventWorkerRegistered, Worker: "worker1"})
+		h.Publish(Event{Type: EventJobStatus, Worker: "worker1", Mirror: "debian"})
+		h.Publish(Event{Type: EventJobStatus, Worker: "worker2", Mirror: "ubuntu"})
+		ev := <-sub.ch
+		So(ev.ID, ShouldEqual, latest+1)
+		So(ev.Type, ShouldEqual, EventWorkerRegistered)
+		So(ev.Time.IsZero(), ShouldBeFalse)
+		So(len(sub.ch), ShouldEqual, 2)
+		h.Unsubscribe(sub)
+
+		Convey("resume from the last event ID", func() {
+			sub, missed, reset, _ := h.Subscribe(ev.ID, true, "", "")
+			defer h.Unsubscribe(sub)
+			So(reset, ShouldBeFalse)
+			So(missed, ShouldHaveLength, 2)
+			So(missed[0].ID, ShouldEqual, ev.ID+1)
+		})
+
+		Convey("resume with filters", func() {
+			sub, missed, _, _ := h.Subscribe(ev.ID-1, true, "worker1", "")
+			defer h.Unsubscribe(sub)
+			So(missed, ShouldHaveLength, 2)
+			So(missed[1].Mirror, ShouldEqual, "debian")
+
+			h.Publish(Event{Type: EventJobStatus, Worker: "worker2", Mirror: "ubuntu"})
+			h.Publish(Event{Type: EventJobSize, Worker: "worker1", Mirror: "debian"})
+			ev := <-sub.ch
+			So(ev.Type, ShouldEqual, EventJobSize)
+		})
+
+		Convey("reset when the events are lost", func() {
+			sub, missed, reset, _ := h.Subscribe(ev.ID-100, true, "", "")
+			defer h.Unsubscribe(sub)
+			So(reset, ShouldBeTrue)
+			So(missed, ShouldBeEmpty)
+
+			sub2, _, reset, _ := h.Subscribe(ev.ID+100, true, "", "")
+			defer h.Unsubscribe(sub2)
+			So(reset, ShouldBeTrue)
+		})
+
+		Convey("drop slow subscribers", func() {
+			sub, _, _, _ := h.Subscribe(0, false, "", "")
+			for i := 0; i <= eventSubscriberBuffer; i++ {
+				h.Publish(Event{Type: EventJobStatus, Worker: "worker1", Mirror: "debian"})
+			}
+			n := 0
+			for range sub.ch {
+				n++
+			}
+			So(n, ShouldEqual, eventSubscriberBuffer)
+			// unsubscribing a dropped subscriber is harmless
+			h.Unsubscribe(sub)
+		})
+	})
+}
@@ -0,0 +1,151 @@
+package manager
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+func (s *Manager) config() *Config {
+	s.cfgLock.RLock()
+	defer s.cfgLock.RUnlock()
+	return s.cfg
+}
+
+// workerClient is the client with which the workers are reached
+func (s *Manager) workerClient() *http.Client {
+	s.cfgLock.RLock()
+	defer s.cfgLock.RUnlock()
+	return s.httpClient
+}
+
+func (s *Manager) notifier() *webhookNotifier {
+	s.cfgLock.RLock()
+	defer s.cfgLock.RUnlock()
+	return s.webhooks
+}
+
+func (s *Manager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.cfgLock.RLock()
+	defer s.cfgLock.RUnlock()
+	return s.cert, nil
+}
+
+// Reload applies a new config to the running manager. The listening
+// address, the database and the status file are kept until the manager
+// restarts, while the rest, including the TLS certificate and the CA
+// certificate, take effect for the following requests. Nothing is
+// changed if the new config is invalid.
+func (s *Manager) Reload(cfg *Config) error {
+	old := s.config()
+
+	httpClient, err := CreateHTTPClient(cfg.Files.CACert)
+	if err != nil {
+		return fmt.Errorf("failed to initialize HTTP client: %s", err.Error())
+	}
+	var cert *tls.Certificate
+	if cfg.Server.SSLCert != "" || cfg.Server.SSLKey != "" {
+		c, err := tls.LoadX509KeyPair(cfg.Server.SSLCert, cfg.Server.SSLKey)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %s", err.Error())
+		}
+		cert = &c
+	}
+	var webhooks *webhookNotifier
+	if len(cfg.Webhooks) > 0 {
+		if webhooks, err = newWebhookNotifier(cfg.Webhooks); err != nil {
+			return fmt.Errorf("failed to initialize webhooks: %s", err.Error())
+		}
+	}
+
+	newCfg := *cfg
+	for _, kept := range []struct {
+		name     string
+		cur, new interface{}
+		keep     func()
+	}{
+		{"server.addr", old.Server.Addr, cfg.Server.Addr, func() { newCfg.Server.Addr = old.Server.Addr }},
+		{"server.port", old.Server.Port, cfg.Server.Port, func() { newCfg.Server.Port = old.Server.Port }},
+		{"files.db_type", old.Files.DBType, cfg.Files.DBType, func() { newCfg.Files.DBType = old.Files.DBType }},
+		{"files.db_file", old.Files.DBFile, cfg.Files.DBFile, func() { newCfg.Files.DBFile = old.Files.DBFile }},
+		{"files.status_file", old.Files.StatusFile, cfg.Files.StatusFile, func() { newCfg.Files.StatusFile = old.Files.StatusFile }},
+		{"debug", old.Debug, cfg.Debug, func() { newCfg.Debug = old.Debug }},
+	} {
+		if kept.cur != kept.new {
+			logger.Warningf("Config %s changed from %v to %v, restart the manager to apply it",
+				kept.name, kept.cur, kept.new)
+			kept.keep()
+		}
+	}
+	s.cfgLock.RLock()
+	tlsEnabled := s.cert != nil
+	s.cfgLock.RUnlock()
+	if tlsEnabled != (cert != nil) {
+		logger.Warningf("TLS is turned on or off, restart the manager to apply it")
+		cert = nil
+		if tlsEnabled {
+			// the current one is still served
+			cert, _ = s.getCertificate(nil)
+		}
+	}
+
+	s.cfgLock.Lock()
+	oldWebhooks := s.webhooks
+	running := s.running
+	s.cfg = &newCfg
+	s.httpClient = httpClient
+	s.cert = cert
+	s.webhooks = webhooks
+	s.cfgLock.Unlock()
+
+	if oldWebhooks != nil {
+		if webhooks != nil {
+			webhooks.takeOver(oldWebhooks)
+		}
+		oldWebhooks.Stop()
+	}
+	if webhooks != nil && running {
+		webhooks.Run()
+	}
+	logger.Notice("Reloaded the manager config")
+	return nil
+}
+
+// Shutdown stops accepting requests, waits for the ongoing ones,
+// the status file and the queued webhook notifications until ctx is
+// done, then closes the database
+func (s *Manager) Shutdown(ctx context.Context) error {
+	s.cfgLock.Lock()
+	close(s.done)
+	running := s.running
+	server := s.server
+	s.cfgLock.Unlock()
+
+	var err error
+	if running {
+		err = server.Shutdown(ctx)
+		if s.statusFile != nil {
+			s.statusFile.Stop()
+		}
+	}
+	if webhooks := s.notifier(); webhooks != nil {
+		select {
+		case <-webhooks.Stop():
+		case <-ctx.Done():
+			logger.Warning("Gave up the queued webhook notifications")
+		}
+	}
+
+	s.rwmu.Lock()
+	if s.adapter != nil {
+		if closeErr := s.adapter.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	s.rwmu.Unlock()
+	close(s.stopped)
+	return err
+}
@@ -0,0 +1,140 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestManagerLifecycle(t *testing.T) {
+	// the manager is a singleton shared with the other tests
+	defer func(m *Manager) { manager = m }(manager)
+
+	Convey("Manager should reload its config", t, func() {
+		manager = nil
+		port := 5601
+		cfg := &Config{
+			Server: ServerConfig{
+				Addr:    "127.0.0.1",
+				Port:    port,
+				SSLCert: "../tests/manager.crt",
+				SSLKey:  "../tests/manager.key",
+			},
+			Auth: AuthConfig{AdminTokens: map[string]string{"alice": "alice-token"}},
+		}
+		s := GetTUNASyncManager(cfg)
+		So(s, ShouldNotBeNil)
+		s.setDBAdapter(&mockDBAdapter{
+			workerStore: map[string]WorkerStatus{},
+			statusStore: make(map[string]MirrorStatus),
+		})
+		go s.Run()
+		defer s.Shutdown(context.Background())
+		time.Sleep(50 * time.Millisecond)
+
+		servedNames := func() []string {
+			conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{InsecureSkipVerify: true})
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			return conn.ConnectionState().PeerCertificates[0].DNSNames
+		}
+		So(servedNames(), ShouldContain, "manager.localhost")
+
+		newCfg := *cfg
+		newCfg.Server.Port = port + 1
+		newCfg.Server.SSLCert = "../tests/worker.crt"
+		newCfg.Server.SSLKey = "../tests/worker.key"
+		newCfg.Auth = AuthConfig{AdminTokens: map[string]string{"bob": "bob-token"}}
+		So(s.Reload(&newCfg), ShouldBeNil)
+
+		// the listening port is kept until a restart
+		So(s.config().Server.Port, ShouldEqual, port)
+		So(servedNames(), ShouldContain, "worker.localhost")
+		So(s.config().Auth.AdminTokens, ShouldContainKey, "bob")
+
+		Convey("keep the config if the new one is invalid", func() {
+			badCfg := newCfg
+			badCfg.Auth = AuthConfig{}
+			badCfg.Files.CACert = "/nonexistent/rootCA.crt"
+			So(s.Reload(&badCfg), ShouldNotBeNil)
+			So(s.config().Auth.AdminTokens, ShouldContainKey, "bob")
+			So(servedNames(), ShouldContain, "worker.localhost")
+
+			badCfg = newCfg
+			badCfg.Server.SSLKey = "../tests/manager.key"
+			So(s.Reload(&badCfg), ShouldNotBeNil)
+			So(servedNames(), ShouldContain, "worker.localhost")
+		})
+	})
+
+	Convey("Manager should shut down gracefully", t, func() {
+		manager = nil
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+
+		port := 5611
+		baseURL := fmt.Sprintf("http://127.0.0.1:%d", port)
+		statusFile := filepath.Join(tmpDir, "tunasync.json")
+		s := GetTUNASyncManager(&Config{
+			Server: ServerConfig{Addr: "127.0.0.1", Port: port},
+			Files: FileConfig{
+				DBType:     "bolt",
+				DBFile:     filepath.Join(tmpDir, "tunasync.db"),
+				StatusFile: statusFile,
+			},
+		})
+		So(s, ShouldNotBeNil)
+		s.statusFile.delay = time.Hour
+
+		stopped := make(chan empty)
+		go func() {
+			s.Run()
+			close(stopped)
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		resp, err := http.Get(baseURL + "/events")
+		So(err, ShouldBeNil)
+		defer resp.Body.Close()
+
+		// the change is still written to the status file
+		_, err = s.adapter.UpdateMirrorStatus("worker1", "debian", MirrorStatus{
+			Name: "debian", Worker: "worker1", Status: Success,
+		})
+		So(err, ShouldBeNil)
+		s.statusChanged()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		So(s.Shutdown(ctx), ShouldBeNil)
+
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			So("Run is still running", ShouldBeEmpty)
+		}
+		// the event stream is ended
+		_, err = io.Copy(io.Discard, bufio.NewReader(resp.Body))
+		So(err, ShouldBeNil)
+
+		_, err = http.Get(baseURL + "/ping")
+		So(err, ShouldNotBeNil)
+		_, err = s.adapter.ListWorkers()
+		So(err, ShouldNotBeNil)
+
+		data, err := os.ReadFile(statusFile)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldContainSubstring, `"name":"debian"`)
+	})
+}
@@ -0,0 +1,156 @@
+package manager
+
+import (
+	"time"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// how often the liveness of the workers is checked
+const livenessCheckInterval = 10 * time.Second
+
+func (s *Manager) offlineTimeout() time.Duration {
+	return time.Duration(s.config().Status.OfflineTimeout) * time.Second
+}
+
+// workerOnline tells whether w has been heard from recently
+func (s *Manager) workerOnline(w WorkerStatus, now time.Time) bool {
+	timeout := s.offlineTimeout()
+	if timeout <= 0 {
+		return true
+	}
+	return now.Sub(w.LastOnline) < timeout
+}
+
+// offlineWorkers returns the IDs of the offline workers
+func (s *Manager) offlineWorkers(workers []WorkerStatus) map[string]bool {
+	offline := make(map[string]bool)
+	now := time.Now()
+	for _, w := range workers {
+		if !s.workerOnline(w, now) {
+			offline[w.ID] = true
+		}
+	}
+	return offline
+}
+
+// runLivenessChecker watches the workers going offline or
+// coming back online, and the mirrors going stale
+func (s *Manager) runLivenessChecker() {
+	online := make(map[string]bool)
+	stale := make(map[string]bool)
+	ticker := time.NewTicker(livenessCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		// both can be turned on and off by reloading the config
+		if s.offlineTimeout() > 0 {
+			s.checkWorkers(online)
+		}
+		if s.staleFactor() > 0 {
+			s.checkMirrors(stale)
+		}
+	}
+}
+
+// checkWorkers compares the liveness of the workers with the last
+// known one in online, and announces the changes
+func (s *Manager) checkWorkers(online map[string]bool) {
+	s.rwmu.RLock()
+	workers, err := s.adapter.ListWorkers()
+	s.rwmu.RUnlock()
+	if err != nil {
+		logger.Errorf("Failed to list workers: %s", err.Error())
+		return
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, w := range workers {
+		seen[w.ID] = true
+		cur := s.workerOnline(w, now)
+		prev, known := online[w.ID]
+		online[w.ID] = cur
+		if !known || prev == cur {
+			continue
+		}
+		if cur {
+			logger.Noticef("Worker <%s> is back online", w.ID)
+			s.events.Publish(Event{Type: EventWorkerOnline, Worker: w.ID})
+		} else {
+			logger.Warningf("Worker <%s> is offline, last seen at %s",
+				w.ID, w.LastOnline.Format("2006-01-02 15:04:05"))
+			s.events.Publish(Event{Type: EventWorkerOffline, Worker: w.ID})
+			s.notifyWorkerOffline(w)
+		}
+		s.statusChanged()
+	}
+	for id := range online {
+		if !seen[id] {
+			delete(online, id)
+		}
+	}
+}
+
+func (s *Manager) staleFactor() float64 {
+	return s.config().Status.StaleFactor
+}
+
+// mirrorStale tells whether m has not been updated for too long
+// compared with its sync interval. The mirrors never updated, as
+// well as the disabled or paused ones, are not judged.
+func (s *Manager) mirrorStale(m MirrorStatus, now time.Time) bool {
+	factor := s.staleFactor()
+	if factor <= 0 || m.Interval <= 0 || m.LastUpdate.IsZero() {
+		return false
+	}
+	if m.Status == Disabled || m.Status == Paused {
+		return false
+	}
+	maxAge := time.Duration(factor * float64(time.Duration(m.Interval)*time.Minute))
+	return now.Sub(m.LastUpdate) > maxAge
+}
+
+// checkMirrors compares the staleness of the mirrors with the last
+// known one in stale, the status file is rewritten on changes since
+// time alone makes a mirror stale
+func (s *Manager) checkMirrors(stale map[string]bool) {
+	s.rwmu.RLock()
+	mirrors, err := s.adapter.ListAllMirrorStatus()
+	s.rwmu.RUnlock()
+	if err != nil {
+		logger.Errorf("Failed to list all mirror status: %s", err.Error())
+		return
+	}
+
+	now := time.Now()
+	changed := false
+	seen := make(map[string]bool)
+	for _, m := range mirrors {
+		key := m.Name + "/" + m.Worker
+		seen[key] = true
+		cur := s.mirrorStale(m, now)
+		prev, known := stale[key]
+		stale[key] = cur
+		if !known || prev == cur {
+			continue
+		}
+		if cur {
+			logger.Warningf("Job [%s] @<%s> is stale, last updated at %s",
+				m.Name, m.Worker, m.LastUpdate.Format("2006-01-02 15:04:05"))
+		}
+		changed = true
+	}
+	for key := range stale {
+		if !seen[key] {
+			delete(stale, key)
+		}
+	}
+	if changed {
+		s.statusChanged()
+	}
+}
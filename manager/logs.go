@@ -0,0 +1,72 @@
+package manager
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// proxyJobLog relays the request for the logs of a job to the worker,
+// which serves them at /jobs/:job/log and /jobs/:job/logs. The query
+// and the byte ranges are passed on, and the followed logs streamed.
+func (s *Manager) proxyJobLog(resource string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workerID, mirrorID := c.Param("id"), c.Param("job")
+		s.rwmu.RLock()
+		w, err := s.adapter.GetWorker(workerID)
+		s.rwmu.RUnlock()
+		if err != nil {
+			err := fmt.Errorf("failed to get worker %s: %s", workerID, err.Error())
+			c.Error(err)
+			s.returnErrJSON(c, http.StatusInternalServerError, err)
+			return
+		}
+		if w.URL == "" {
+			err := fmt.Errorf("worker %s serves no logs, as it pulls the commands without a http server", workerID)
+			s.returnErrJSON(c, http.StatusNotImplemented, err)
+			return
+		}
+		target, err := url.Parse(w.URL)
+		if err != nil {
+			err := fmt.Errorf("invalid url of worker %s: %s", workerID, w.URL)
+			s.returnErrJSON(c, http.StatusInternalServerError, err)
+			return
+		}
+		target.Path = strings.TrimSuffix(target.Path, "/") + "/jobs/" + url.PathEscape(mirrorID) + "/" + resource
+		target.RawPath = ""
+		target.RawQuery = c.Request.URL.RawQuery
+
+		// the followed logs live longer than the write timeout of the server
+		http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+		proxy := &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.Out.URL = target
+				r.Out.Host = target.Host
+				// the admin token is not for the worker
+				r.Out.Header.Del("Authorization")
+				if w.Token != "" {
+					SignRequest(r.Out, nil, w.Token, time.Now())
+				}
+			},
+			Transport:     s.workerClient().Transport,
+			FlushInterval: -1,
+			ErrorHandler: func(rw http.ResponseWriter, r *http.Request, err error) {
+				if r.Context().Err() != nil {
+					// the client has gone
+					return
+				}
+				err = fmt.Errorf("worker %s (%s) is unreachable: %s", workerID, w.URL, err.Error())
+				c.Error(err)
+				s.returnErrJSON(c, http.StatusBadGateway, err)
+			},
+		}
+		proxy.ServeHTTP(c.Writer, c.Request)
+	}
+}
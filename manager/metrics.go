@@ -0,0 +1,212 @@
+package manager
+
+import (
+	"strconv"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+var allSyncStatus = []SyncStatus{
+	None, Failed, Success, Syncing, PreSyncing, Paused, Disabled,
+}
+
+var (
+	mirrorLabels = []string{"mirror", "worker"}
+
+	mirrorStatusDesc = prometheus.NewDesc(
+		"tunasync_mirror_status",
+		"Current sync status of a mirror on a worker, 1 for the current status",
+		append(mirrorLabels, "status"), nil,
+	)
+	mirrorIsMasterDesc = prometheus.NewDesc(
+		"tunasync_mirror_is_master",
+		"Whether the mirror is a master mirror",
+		mirrorLabels, nil,
+	)
+	mirrorLastSuccessDesc = prometheus.NewDesc(
+		"tunasync_mirror_last_success_timestamp_seconds",
+		"Unix time of the last successful sync of a mirror",
+		mirrorLabels, nil,
+	)
+	mirrorLastStartedDesc = prometheus.NewDesc(
+		"tunasync_mirror_last_started_timestamp_seconds",
+		"Unix time of the last started sync of a mirror",
+		mirrorLabels, nil,
+	)
+	mirrorLastEndedDesc = prometheus.NewDesc(
+		"tunasync_mirror_last_ended_timestamp_seconds",
+		"Unix time of the last ended sync of a mirror",
+		mirrorLabels, nil,
+	)
+	mirrorNextScheduleDesc = prometheus.NewDesc(
+		"tunasync_mirror_next_schedule_timestamp_seconds",
+		"Unix time of the next scheduled sync of a mirror",
+		mirrorLabels, nil,
+	)
+	mirrorSizeDesc = prometheus.NewDesc(
+		"tunasync_mirror_size_bytes",
+		"Approximate size of a mirror, parsed from the reported size",
+		mirrorLabels, nil,
+	)
+	workerLastOnlineDesc = prometheus.NewDesc(
+		"tunasync_worker_last_online_timestamp_seconds",
+		"Unix time when the worker was last seen",
+		[]string{"worker"}, nil,
+	)
+	workerLastOnlineAgeDesc = prometheus.NewDesc(
+		"tunasync_worker_last_online_age_seconds",
+		"Seconds since the worker was last seen",
+		[]string{"worker"}, nil,
+	)
+	workerOnlineDesc = prometheus.NewDesc(
+		"tunasync_worker_online",
+		"Whether the worker is online",
+		[]string{"worker"}, nil,
+	)
+	workerLastRegisterDesc = prometheus.NewDesc(
+		"tunasync_worker_last_register_timestamp_seconds",
+		"Unix time when the worker last registered",
+		[]string{"worker"}, nil,
+	)
+)
+
+// statusCollector exports the mirror and worker status
+// kept in the database at scrape time
+type statusCollector struct {
+	s *Manager
+}
+
+func (sc statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- mirrorStatusDesc
+	ch <- mirrorIsMasterDesc
+	ch <- mirrorLastSuccessDesc
+	ch <- mirrorLastStartedDesc
+	ch <- mirrorLastEndedDesc
+	ch <- mirrorNextScheduleDesc
+	ch <- mirrorSizeDesc
+	ch <- workerLastOnlineDesc
+	ch <- workerLastOnlineAgeDesc
+	ch <- workerOnlineDesc
+	ch <- workerLastRegisterDesc
+}
+
+func (sc statusCollector) Collect(ch chan<- prometheus.Metric) {
+	sc.s.rwmu.RLock()
+	mirrors, mErr := sc.s.adapter.ListAllMirrorStatus()
+	workers, wErr := sc.s.adapter.ListWorkers()
+	sc.s.rwmu.RUnlock()
+
+	if mErr != nil {
+		logger.Errorf("Failed to collect mirror metrics: %s", mErr.Error())
+		ch <- prometheus.NewInvalidMetric(mirrorStatusDesc, mErr)
+	}
+	for _, m := range mirrors {
+		for _, status := range allSyncStatus {
+			v := 0.0
+			if m.Status == status {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(mirrorStatusDesc,
+				prometheus.GaugeValue, v, m.Name, m.Worker, status.String())
+		}
+		isMaster := 0.0
+		if m.IsMaster {
+			isMaster = 1
+		}
+		ch <- prometheus.MustNewConstMetric(mirrorIsMasterDesc,
+			prometheus.GaugeValue, isMaster, m.Name, m.Worker)
+		collectTimestamp(ch, mirrorLastSuccessDesc, m.LastUpdate, m.Name, m.Worker)
+		collectTimestamp(ch, mirrorLastStartedDesc, m.LastStarted, m.Name, m.Worker)
+		collectTimestamp(ch, mirrorLastEndedDesc, m.LastEnded, m.Name, m.Worker)
+		collectTimestamp(ch, mirrorNextScheduleDesc, m.Scheduled, m.Name, m.Worker)
+		if size, ok := parseMirrorSize(m.Size); ok {
+			ch <- prometheus.MustNewConstMetric(mirrorSizeDesc,
+				prometheus.GaugeValue, size, m.Name, m.Worker)
+		}
+	}
+
+	if wErr != nil {
+		logger.Errorf("Failed to collect worker metrics: %s", wErr.Error())
+		ch <- prometheus.NewInvalidMetric(workerLastOnlineDesc, wErr)
+	}
+	now := time.Now()
+	for _, w := range workers {
+		collectTimestamp(ch, workerLastOnlineDesc, w.LastOnline, w.ID)
+		collectTimestamp(ch, workerLastRegisterDesc, w.LastRegister, w.ID)
+		if !w.LastOnline.IsZero() {
+			ch <- prometheus.MustNewConstMetric(workerLastOnlineAgeDesc,
+				prometheus.GaugeValue, now.Sub(w.LastOnline).Seconds(), w.ID)
+		}
+		online := 0.0
+		if sc.s.workerOnline(w, now) {
+			online = 1
+		}
+		ch <- prometheus.MustNewConstMetric(workerOnlineDesc,
+			prometheus.GaugeValue, online, w.ID)
+	}
+}
+
+// collectTimestamp exports t in unix seconds, zero time is skipped
+func collectTimestamp(ch chan<- prometheus.Metric, desc *prometheus.Desc, t time.Time, labels ...string) {
+	if t.IsZero() || t.Unix() <= 0 {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue,
+		float64(t.UnixNano())/1e9, labels...)
+}
+
+// parseMirrorSize parses sizes like "1.33T" or "3GB" reported by workers
+func parseMirrorSize(size string) (float64, bool) {
+	if size == "" || size == "unknown" {
+		return 0, false
+	}
+	v, err := units.RAMInBytes(size)
+	if err != nil {
+		return 0, false
+	}
+	return float64(v), true
+}
+
+// managerMetrics holds the metrics registry of a manager
+type managerMetrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+}
+
+func newManagerMetrics(s *Manager) *managerMetrics {
+	m := &managerMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tunasync_manager_http_requests_total",
+			Help: "Number of API requests handled by the manager",
+		}, []string{"method", "path", "code"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		statusCollector{s: s},
+	)
+	return m
+}
+
+// instrument counts the requests by the matched route
+func (m *managerMetrics) instrument(c *gin.Context) {
+	c.Next()
+	path := c.FullPath()
+	if path == "" {
+		path = "unmatched"
+	}
+	m.requests.WithLabelValues(c.Request.Method, path, strconv.Itoa(c.Writer.Status())).Inc()
+}
+
+func (m *managerMetrics) handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
@@ -1,10 +1,13 @@
 package manager
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
 )
 
 func contextErrorLogger(c *gin.Context) {
@@ -33,3 +36,45 @@ func (s *Manager) workerIDValidator(c *gin.Context) {
 	// pass on to the next middleware in chain
 	c.Next()
 }
+
+// workerAuthenticator ensures the request comes from the worker
+// with the session token issued on registration
+func (s *Manager) workerAuthenticator(c *gin.Context) {
+	if !s.config().Auth.workerAuthEnabled() {
+		c.Next()
+		return
+	}
+	workerID := c.Param("id")
+	s.rwmu.RLock()
+	w, err := s.adapter.GetWorker(workerID)
+	s.rwmu.RUnlock()
+	if err != nil || w.Token == "" || !TokenEqual(BearerToken(c.Request), w.Token) {
+		err := fmt.Errorf("worker %s is not authenticated", workerID)
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusUnauthorized, err)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// adminAuthenticator ensures the request carries one of the admin tokens,
+// the name of the token is saved as the identity of the request
+func (s *Manager) adminAuthenticator(c *gin.Context) {
+	if len(s.config().Auth.AdminTokens) == 0 {
+		c.Next()
+		return
+	}
+	token := BearerToken(c.Request)
+	for name, adminToken := range s.config().Auth.AdminTokens {
+		if adminToken != "" && TokenEqual(token, adminToken) {
+			c.Set(_identityKey, name)
+			c.Next()
+			return
+		}
+	}
+	err := errors.New("admin token required")
+	c.Error(err)
+	s.returnErrJSON(c, http.StatusUnauthorized, err)
+	c.Abort()
+}
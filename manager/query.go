@@ -0,0 +1,379 @@
+package manager
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// the header holding the cursor of the next page of jobs,
+// which is absent on the last page
+const nextCursorHeader = "X-Next-Cursor"
+
+// the fields the jobs can be sorted by, besides the mirror name
+// and the worker which break the ties
+var jobSortColumns = map[string]string{
+	"name":         "mirror",
+	"worker":       "worker",
+	"last_update":  "last_update",
+	"last_started": "last_started",
+	"last_ended":   "last_ended",
+}
+
+// a jobCursor is the sort key of the last job of a page
+type jobCursor struct {
+	Sort   string `json:"s"`
+	Name   string `json:"n"`
+	Worker string `json:"w"`
+	Time   int64  `json:"t,omitempty"`
+}
+
+// a jobFilter selects the jobs to list, the empty fields match everything
+type jobFilter struct {
+	// matched as shown, where the jobs of the offline workers
+	// are unknown unless disabled or paused
+	Statuses []SyncStatus
+	Offline  []string
+	Workers  []string
+	// a glob on the mirror name
+	Name     string
+	IsMaster *bool
+	// the jobs not updated since then
+	StaleSince time.Time
+	// one of jobSortColumns, prefixed by - for the descending order
+	Sort  string
+	After *jobCursor
+	Limit int
+}
+
+func (f jobFilter) sortField() (field string, desc bool) {
+	field = strings.TrimPrefix(f.Sort, "-")
+	if field == "" {
+		field = "name"
+	}
+	return field, strings.HasPrefix(f.Sort, "-")
+}
+
+// shownStatus is the status of m as shown, which is unknown if
+// its worker is offline
+func (f jobFilter) shownStatus(m MirrorStatus) SyncStatus {
+	if m.Status == Disabled || m.Status == Paused {
+		return m.Status
+	}
+	for _, w := range f.Offline {
+		if m.Worker == w {
+			return Unknown
+		}
+	}
+	return m.Status
+}
+
+func (f jobFilter) match(m MirrorStatus) bool {
+	if len(f.Statuses) > 0 {
+		found := false
+		status := f.shownStatus(m)
+		for _, s := range f.Statuses {
+			if status == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Workers) > 0 {
+		found := false
+		for _, w := range f.Workers {
+			if m.Worker == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Name != "" {
+		if ok, _ := path.Match(f.Name, m.Name); !ok {
+			return false
+		}
+	}
+	if f.IsMaster != nil && m.IsMaster != *f.IsMaster {
+		return false
+	}
+	if !f.StaleSince.IsZero() && !m.LastUpdate.Before(f.StaleSince) {
+		return false
+	}
+	return true
+}
+
+// jobTime is the time a job is sorted by
+func jobTime(m MirrorStatus, field string) int64 {
+	switch field {
+	case "last_update":
+		return toUnixNano(m.LastUpdate)
+	case "last_started":
+		return toUnixNano(m.LastStarted)
+	case "last_ended":
+		return toUnixNano(m.LastEnded)
+	}
+	return 0
+}
+
+// cursorOf is the cursor pointing past m
+func (f jobFilter) cursorOf(m MirrorStatus) jobCursor {
+	field, _ := f.sortField()
+	return jobCursor{
+		Sort:   f.Sort,
+		Name:   m.Name,
+		Worker: m.Worker,
+		Time:   jobTime(m, field),
+	}
+}
+
+// compareJobs compares the sort keys of two jobs in the ascending order
+func compareJobs(field string, a, b jobCursor) int {
+	if field != "name" && field != "worker" && a.Time != b.Time {
+		if a.Time < b.Time {
+			return -1
+		}
+		return 1
+	}
+	first, second := [2]string{a.Name, a.Worker}, [2]string{b.Name, b.Worker}
+	if field == "worker" {
+		first, second = [2]string{a.Worker, a.Name}, [2]string{b.Worker, b.Name}
+	}
+	for i := range first {
+		if c := strings.Compare(first[i], second[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// selectMirrorStatus applies the filter to the jobs in memory,
+// for the databases unable to query them
+func selectMirrorStatus(ms []MirrorStatus, filter jobFilter) []MirrorStatus {
+	field, desc := filter.sortField()
+	less := func(a, b MirrorStatus) bool {
+		c := compareJobs(field, filter.cursorOf(a), filter.cursorOf(b))
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	selected := make([]MirrorStatus, 0, len(ms))
+	for _, m := range ms {
+		if !filter.match(m) {
+			continue
+		}
+		if filter.After != nil {
+			c := compareJobs(field, filter.cursorOf(m), *filter.After)
+			if (!desc && c <= 0) || (desc && c >= 0) {
+				continue
+			}
+		}
+		selected = append(selected, m)
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		return less(selected[i], selected[j])
+	})
+	if filter.Limit > 0 && len(selected) > filter.Limit {
+		selected = selected[:filter.Limit]
+	}
+	return selected
+}
+
+// globToRegexp translates a glob on names into an anchored regular expression
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			j := strings.IndexByte(glob[i:], ']')
+			if j < 0 {
+				b.WriteString(regexp.QuoteMeta(glob[i:]))
+				i = len(glob)
+				break
+			}
+			// the classes of globs are written as in regular expressions
+			b.WriteString(glob[i : i+j+1])
+			i += j
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+func encodeJobCursor(cur jobCursor) string {
+	b, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeJobCursor(s string) (*jobCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var cur jobCursor
+	if err := json.Unmarshal(b, &cur); err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
+
+// splitParams splits the comma separated values of a repeated parameter
+func splitParams(values []string) (ss []string) {
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				ss = append(ss, s)
+			}
+		}
+	}
+	return
+}
+
+// parseJobFilter reads the filter from the query parameters:
+// status, worker, name, master, stale_since, sort, limit and cursor
+func parseJobFilter(c *gin.Context) (filter jobFilter, err error) {
+	for _, s := range splitParams(c.QueryArray("status")) {
+		status, err := parseSyncStatus(s)
+		if err != nil {
+			return filter, fmt.Errorf("invalid status: %s", s)
+		}
+		filter.Statuses = append(filter.Statuses, status)
+	}
+	filter.Workers = splitParams(c.QueryArray("worker"))
+	if filter.Name = c.Query("name"); filter.Name != "" {
+		if _, err := path.Match(filter.Name, ""); err != nil {
+			return filter, fmt.Errorf("invalid name: %s", filter.Name)
+		}
+	}
+	if v := c.Query("master"); v != "" {
+		isMaster, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid master: %s", v)
+		}
+		filter.IsMaster = &isMaster
+	}
+	if v := c.Query("stale_since"); v != "" {
+		// either a time or how long ago
+		if d, err := time.ParseDuration(v); err == nil {
+			filter.StaleSince = time.Now().Add(-d)
+		} else if filter.StaleSince, err = parseTimeParam(v); err != nil {
+			return filter, fmt.Errorf("invalid stale_since: %s", v)
+		}
+	}
+	filter.Sort = c.Query("sort")
+	if field, _ := filter.sortField(); jobSortColumns[field] == "" {
+		return filter, fmt.Errorf("invalid sort: %s", filter.Sort)
+	}
+	if v := c.Query("limit"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit: %s", v)
+		}
+	}
+	if v := c.Query("cursor"); v != "" {
+		filter.After, err = decodeJobCursor(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid cursor: %s", v)
+		}
+		if filter.After.Sort != filter.Sort {
+			return filter, fmt.Errorf("cursor of sort %q used with sort %q", filter.After.Sort, filter.Sort)
+		}
+	}
+	return filter, nil
+}
+
+// filterOfflineWorkers tells the filter the workers offline, so
+// that the statuses are matched as shown
+func (s *Manager) filterOfflineWorkers(filter *jobFilter) error {
+	if len(filter.Statuses) == 0 || s.offlineTimeout() <= 0 {
+		return nil
+	}
+	s.rwmu.RLock()
+	workers, err := s.adapter.ListWorkers()
+	s.rwmu.RUnlock()
+	if err != nil {
+		return err
+	}
+	filter.Offline = nil
+	for w := range s.offlineWorkers(workers) {
+		filter.Offline = append(filter.Offline, w)
+	}
+	sort.Strings(filter.Offline)
+	return nil
+}
+
+// queryJobs responds with the jobs selected by the query parameters,
+// fixed to the jobs of the worker when it is given, and tells the
+// cursor of the next page in a header
+func (s *Manager) queryJobs(c *gin.Context, workerID string) ([]MirrorStatus, bool) {
+	filter, err := parseJobFilter(c)
+	if err != nil {
+		s.returnErrJSON(c, http.StatusBadRequest, err)
+		return nil, false
+	}
+	if workerID != "" {
+		filter.Workers = []string{workerID}
+	}
+	if err := s.filterOfflineWorkers(&filter); err != nil {
+		err = fmt.Errorf("failed to list workers: %s", err.Error())
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	limit := filter.Limit
+	if limit > 0 {
+		// one more to tell whether there is a next page
+		filter.Limit++
+	}
+
+	s.rwmu.RLock()
+	ms, err := s.adapter.QueryMirrorStatus(filter)
+	s.rwmu.RUnlock()
+	if err != nil {
+		if workerID != "" {
+			err = fmt.Errorf("failed to list jobs of worker %s: %s", workerID, err.Error())
+		} else {
+			err = fmt.Errorf("failed to list all mirror status: %s", err.Error())
+		}
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	if limit > 0 && len(ms) > limit {
+		ms = ms[:limit]
+		c.Header(nextCursorHeader, encodeJobCursor(filter.cursorOf(ms[limit-1])))
+	}
+	if ms == nil {
+		ms = []MirrorStatus{}
+	}
+	return ms, true
+}
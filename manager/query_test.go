@@ -0,0 +1,22 @@
+package manager
+
+import (
+	"path"
+	"regexp"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	Convey("Globs should be translated as path.Match reads them", t, func() {
+		names := []string{"debian", "debian-security", "ubuntu", "ubuntu-ports", "a.b", "a*b", "ab", "c"}
+		for _, glob := range []string{"debian*", "*-*", "ubuntu?ports", "a.b", `a\*b`, "[a-c]", "[^a-c]*", "*"} {
+			re := regexp.MustCompile(globToRegexp(glob))
+			for _, name := range names {
+				matched, _ := path.Match(glob, name)
+				So(re.MatchString(name), ShouldEqual, matched)
+			}
+		}
+	})
+}
@@ -1,9 +1,16 @@
 package manager
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,19 +20,52 @@ import (
 )
 
 const (
-	_errorKey = "error"
-	_infoKey  = "message"
+	_errorKey    = "error"
+	_infoKey     = "message"
+	_identityKey = "identity"
+)
+
+const (
+	// seconds to wait for a worker to answer a command by default
+	defaultCmdTimeout = 5
+	// the replies of the workers are short messages
+	maxWorkerReplySize = 64 << 10
+)
+
+// options of the client commands without worker ID
+const (
+	// send the command to the master replica of the mirror
+	cmdOptMaster = "master"
+	// send the command to all the workers hosting the mirror
+	cmdOptAllWorkers = "all_workers"
 )
 
 var manager *Manager
 
 // A Manager represents a manager server
 type Manager struct {
+	// cfgLock guards the fields replaced on reloading the config
+	cfgLock    sync.RWMutex
 	cfg        *Config
+	httpClient *http.Client
+	cert       *tls.Certificate
+	webhooks   *webhookNotifier
+
 	engine     *gin.Engine
 	adapter    dbAdapter
 	rwmu       sync.RWMutex
-	httpClient *http.Client
+	metrics    *managerMetrics
+	statusFile *statusFileWriter
+	events     *eventHub
+	cmdQueues  cmdQueueLocks
+	pulls      cmdPuller
+	waiting    waitingLists
+
+	server  *http.Server
+	running bool // guarded by cfgLock
+	// closed when the manager starts shutting down and when it is down
+	done    chan empty
+	stopped chan empty
 }
 
 // GetTUNASyncManager returns the manager from config
@@ -41,6 +81,9 @@ func GetTUNASyncManager(cfg *Config) *Manager {
 	s := &Manager{
 		cfg:     cfg,
 		adapter: nil,
+		events:  newEventHub(),
+		done:    make(chan empty),
+		stopped: make(chan empty),
 	}
 
 	s.engine = gin.New()
@@ -49,13 +92,20 @@ func GetTUNASyncManager(cfg *Config) *Manager {
 		s.engine.Use(gin.Logger())
 	}
 
-	if cfg.Files.CACert != "" {
-		httpClient, err := CreateHTTPClient(cfg.Files.CACert)
+	httpClient, err := CreateHTTPClient(cfg.Files.CACert)
+	if err != nil {
+		logger.Errorf("Error initializing HTTP client: %s", err.Error())
+		return nil
+	}
+	s.httpClient = httpClient
+
+	if cfg.Server.SSLCert != "" || cfg.Server.SSLKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Server.SSLCert, cfg.Server.SSLKey)
 		if err != nil {
-			logger.Errorf("Error initializing HTTP client: %s", err.Error())
+			logger.Errorf("Error loading TLS certificate: %s", err.Error())
 			return nil
 		}
-		s.httpClient = httpClient
+		s.cert = &cert
 	}
 
 	if cfg.Files.DBFile != "" {
@@ -67,16 +117,42 @@ func GetTUNASyncManager(cfg *Config) *Manager {
 		s.setDBAdapter(adapter)
 	}
 
+	if len(cfg.Webhooks) > 0 {
+		webhooks, err := newWebhookNotifier(cfg.Webhooks)
+		if err != nil {
+			logger.Errorf("Error initializing webhooks: %s", err.Error())
+			return nil
+		}
+		s.webhooks = webhooks
+	}
+
+	if cfg.Files.StatusFile != "" {
+		s.statusFile = newStatusFileWriter(cfg.Files.StatusFile, s.listWebMirrorStatus)
+	}
+
 	// common log middleware
 	s.engine.Use(contextErrorLogger)
 
+	s.metrics = newManagerMetrics(s)
+	s.engine.Use(s.metrics.instrument)
+	// prometheus metrics
+	s.engine.GET("/metrics", s.metrics.handler())
+
 	s.engine.GET("/ping", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{_infoKey: "pong"})
 	})
 	// list jobs, status page
 	s.engine.GET("/jobs", s.listAllJobs)
+	// jobs waiting for a slot on all the workers
+	s.engine.GET("/jobs/waiting", s.listWaitingJobs)
+	// status of each mirror on all its workers
+	s.engine.GET("/mirrors", s.listMirrors)
+	// live stream of status events
+	s.engine.GET("/events", s.streamEvents)
 	// flush disabled jobs
-	s.engine.DELETE("/jobs/disabled", s.flushDisabledJobs)
+	s.engine.DELETE("/jobs/disabled", s.adminAuthenticator, s.flushDisabledJobs)
+	// audit log of the administrative operations
+	s.engine.GET("/audit", s.adminAuthenticator, s.listAuditRecords)
 
 	// list workers
 	s.engine.GET("/workers", s.listWorkers)
@@ -87,17 +163,35 @@ func GetTUNASyncManager(cfg *Config) *Manager {
 	workerValidateGroup := s.engine.Group("/workers", s.workerIDValidator)
 	{
 		// delete specified worker
-		workerValidateGroup.DELETE(":id", s.deleteWorker)
+		workerValidateGroup.DELETE(":id", s.adminAuthenticator, s.deleteWorker)
 		// get job list
 		workerValidateGroup.GET(":id/jobs", s.listJobsOfWorker)
 		// post job status
-		workerValidateGroup.POST(":id/jobs/:job", s.updateJobOfWorker)
-		workerValidateGroup.POST(":id/jobs/:job/size", s.updateMirrorSize)
-		workerValidateGroup.POST(":id/schedules", s.updateSchedulesOfWorker)
+		workerValidateGroup.POST(":id/jobs/:job", s.workerAuthenticator, s.updateJobOfWorker)
+		workerValidateGroup.POST(":id/jobs/:job/size", s.adminAuthenticator, s.updateMirrorSize)
+		// get sync history of a job
+		workerValidateGroup.GET(":id/jobs/:job/history", s.listSyncHistory)
+		// logs of a job served by the worker
+		workerValidateGroup.GET(":id/jobs/:job/log", s.adminAuthenticator, s.proxyJobLog("log"))
+		workerValidateGroup.GET(":id/jobs/:job/logs", s.adminAuthenticator, s.proxyJobLog("logs"))
+		workerValidateGroup.POST(":id/schedules", s.workerAuthenticator, s.updateSchedulesOfWorker)
+		// jobs waiting for a slot on the worker
+		workerValidateGroup.GET(":id/waiting", s.listWaitingOfWorker)
+		workerValidateGroup.POST(":id/waiting", s.workerAuthenticator, s.updateWaitingOfWorker)
+		// keep the worker online
+		workerValidateGroup.POST(":id/heartbeat", s.workerAuthenticator, s.workerHeartbeat)
+		// commands of the workers polling the manager
+		workerValidateGroup.GET(":id/cmd", s.workerAuthenticator, s.pollWorkerCmd)
+		workerValidateGroup.POST(":id/cmd/:cmd", s.workerAuthenticator, s.replyWorkerCmd)
 	}
 
 	// for tunasynctl to post commands
-	s.engine.POST("/cmd", s.handleClientCmd)
+	s.engine.POST("/cmd", s.adminAuthenticator, s.handleClientCmd)
+	// bulk commands to the jobs selected
+	s.engine.POST("/cmd/bulk", s.adminAuthenticator, s.handleBulkCmd)
+	// commands queued for the unreachable workers
+	s.engine.GET("/cmd/queue", s.adminAuthenticator, s.listQueuedCmds)
+	s.engine.DELETE("/cmd/queue/:worker/:id", s.adminAuthenticator, s.cancelQueuedCmd)
 
 	manager = s
 	return s
@@ -107,33 +201,60 @@ func (s *Manager) setDBAdapter(adapter dbAdapter) {
 	s.adapter = adapter
 }
 
-// Run runs the manager server forever
+// Run runs the manager server until it is shut down
 func (s *Manager) Run() {
-	addr := fmt.Sprintf("%s:%d", s.cfg.Server.Addr, s.cfg.Server.Port)
-
-	httpServer := &http.Server{
-		Addr:         addr,
+	cfg := s.config()
+	server := &http.Server{
+		Addr:         fmt.Sprintf("%s:%d", cfg.Server.Addr, cfg.Server.Port),
 		Handler:      s.engine,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
+	s.cfgLock.Lock()
+	select {
+	case <-s.done:
+		s.cfgLock.Unlock()
+		return
+	default:
+	}
+	if s.cert != nil {
+		// the certificate is looked up on each handshake
+		// to pick up the reloaded one
+		server.TLSConfig = &tls.Config{GetCertificate: s.getCertificate}
+	}
+	s.server = server
+	s.running = true
+	s.cfgLock.Unlock()
+	// the event streams never end by themselves
+	server.RegisterOnShutdown(s.events.Close)
 
-	if s.cfg.Server.SSLCert == "" && s.cfg.Server.SSLKey == "" {
-		if err := httpServer.ListenAndServe(); err != nil {
-			panic(err)
-		}
+	if s.statusFile != nil {
+		go s.statusFile.Run()
+	}
+	go s.runLivenessChecker()
+	if webhooks := s.notifier(); webhooks != nil {
+		webhooks.Run()
+	}
+
+	var err error
+	if server.TLSConfig == nil {
+		err = server.ListenAndServe()
 	} else {
-		if err := httpServer.ListenAndServeTLS(s.cfg.Server.SSLCert, s.cfg.Server.SSLKey); err != nil {
-			panic(err)
-		}
+		err = server.ListenAndServeTLS("", "")
 	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		panic(err)
+	}
+	<-s.stopped
 }
 
 // listAllJobs respond with all jobs of specified workers
 func (s *Manager) listAllJobs(c *gin.Context) {
-	s.rwmu.RLock()
-	mirrorStatusList, err := s.adapter.ListAllMirrorStatus()
-	s.rwmu.RUnlock()
+	mirrorStatusList, ok := s.queryJobs(c, "")
+	if !ok {
+		return
+	}
+	mirrorStatusList, err := s.shownMirrorStatus(mirrorStatusList)
 	if err != nil {
 		err := fmt.Errorf("failed to list all mirror status: %s",
 			err.Error(),
@@ -152,6 +273,76 @@ func (s *Manager) listAllJobs(c *gin.Context) {
 	c.JSON(http.StatusOK, webMirStatusList)
 }
 
+// listWebMirrorStatus returns the status of all jobs shown on the web page
+func (s *Manager) listWebMirrorStatus() ([]WebMirrorStatus, error) {
+	mirrorStatusList, err := s.listShownMirrorStatus()
+	if err != nil {
+		return nil, err
+	}
+	webMirStatusList := []WebMirrorStatus{}
+	for _, m := range mirrorStatusList {
+		webMirStatusList = append(
+			webMirStatusList,
+			BuildWebMirrorStatus(m),
+		)
+	}
+	return webMirStatusList, nil
+}
+
+// listShownMirrorStatus returns the status of all jobs as they are shown
+func (s *Manager) listShownMirrorStatus() ([]MirrorStatus, error) {
+	s.rwmu.RLock()
+	mirrorStatusList, err := s.adapter.ListAllMirrorStatus()
+	s.rwmu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return s.shownMirrorStatus(mirrorStatusList)
+}
+
+// shownMirrorStatus adjusts the status of the jobs to be shown,
+// that is with the staleness and the workers offline considered
+func (s *Manager) shownMirrorStatus(mirrorStatusList []MirrorStatus) ([]MirrorStatus, error) {
+	s.rwmu.RLock()
+	workers, err := s.adapter.ListWorkers()
+	s.rwmu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	// the status reported by an offline worker is not trustworthy
+	offline := s.offlineWorkers(workers)
+	now := time.Now()
+	for i, m := range mirrorStatusList {
+		m.Stale = s.mirrorStale(m, now)
+		if offline[m.Worker] && m.Status != Disabled && m.Status != Paused {
+			m.Status = Unknown
+		}
+		mirrorStatusList[i] = m
+	}
+	return mirrorStatusList, nil
+}
+
+// listMirrors respond with the status of each mirror on all its workers
+func (s *Manager) listMirrors(c *gin.Context) {
+	mirrorStatusList, err := s.listShownMirrorStatus()
+	if err != nil {
+		err := fmt.Errorf("failed to list all mirror status: %s",
+			err.Error(),
+		)
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, BuildWebMirrorGroups(mirrorStatusList))
+}
+
+// statusChanged notifies the status file writer
+func (s *Manager) statusChanged() {
+	if s.statusFile != nil {
+		s.statusFile.Notify()
+	}
+}
+
 // flushDisabledJobs deletes all jobs that marks as deleted
 func (s *Manager) flushDisabledJobs(c *gin.Context) {
 	s.rwmu.Lock()
@@ -162,9 +353,12 @@ func (s *Manager) flushDisabledJobs(c *gin.Context) {
 			err.Error(),
 		)
 		c.Error(err)
+		s.audit(c, AuditRecord{Action: auditFlushDisabled, Code: http.StatusInternalServerError, Message: err.Error()})
 		s.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
+	s.statusChanged()
+	s.audit(c, AuditRecord{Action: auditFlushDisabled, Code: http.StatusOK, Message: "flushed"})
 	c.JSON(http.StatusOK, gin.H{_infoKey: "flushed"})
 }
 
@@ -179,10 +373,16 @@ func (s *Manager) deleteWorker(c *gin.Context) {
 			err.Error(),
 		)
 		c.Error(err)
+		s.audit(c, AuditRecord{Action: auditDeleteWorker, WorkerID: workerID,
+			Code: http.StatusInternalServerError, Message: err.Error()})
 		s.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
+	s.waiting.forget(workerID)
+	s.statusChanged()
+	s.events.Publish(Event{Type: EventWorkerDeleted, Worker: workerID})
 	logger.Noticef("Worker <%s> deleted", workerID)
+	s.audit(c, AuditRecord{Action: auditDeleteWorker, WorkerID: workerID, Code: http.StatusOK, Message: "deleted"})
 	c.JSON(http.StatusOK, gin.H{_infoKey: "deleted"})
 }
 
@@ -200,6 +400,7 @@ func (s *Manager) listWorkers(c *gin.Context) {
 		s.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
+	now := time.Now()
 	for _, w := range workers {
 		workerInfos = append(workerInfos,
 			WorkerStatus{
@@ -208,6 +409,7 @@ func (s *Manager) listWorkers(c *gin.Context) {
 				Token:        "REDACTED",
 				LastOnline:   w.LastOnline,
 				LastRegister: w.LastRegister,
+				Online:       s.workerOnline(w, now),
 			})
 	}
 	c.JSON(http.StatusOK, workerInfos)
@@ -217,9 +419,34 @@ func (s *Manager) listWorkers(c *gin.Context) {
 func (s *Manager) registerWorker(c *gin.Context) {
 	var _worker WorkerStatus
 	c.BindJSON(&_worker)
+
+	// the session token is issued by the manager only
+	_worker.Token = ""
+	if s.config().Auth.workerAuthEnabled() {
+		secret := s.config().Auth.workerSecret(_worker.ID)
+		if secret == "" || !TokenEqual(BearerToken(c.Request), secret) {
+			err := fmt.Errorf("worker %s is not authenticated", _worker.ID)
+			c.Error(err)
+			s.returnErrJSON(c, http.StatusUnauthorized, err)
+			return
+		}
+		token, err := GenerateToken()
+		if err != nil {
+			err := fmt.Errorf("failed to generate session token: %s", err.Error())
+			c.Error(err)
+			s.returnErrJSON(c, http.StatusInternalServerError, err)
+			return
+		}
+		_worker.Token = token
+	}
+
 	_worker.LastOnline = time.Now()
 	_worker.LastRegister = time.Now()
+	// the refreshes read and write the worker back under RLock,
+	// which must not write the old token back
+	s.rwmu.Lock()
 	newWorker, err := s.adapter.CreateWorker(_worker)
+	s.rwmu.Unlock()
 	if err != nil {
 		err := fmt.Errorf("failed to register worker: %s",
 			err.Error(),
@@ -230,24 +457,38 @@ func (s *Manager) registerWorker(c *gin.Context) {
 	}
 
 	logger.Noticef("Worker <%s> registered", _worker.ID)
-	// create workerCmd channel for this worker
+	s.events.Publish(Event{Type: EventWorkerRegistered, Worker: _worker.ID})
+	s.triggerQueuedCmds(_worker.ID, cmdQueueRegisterDelay)
 	c.JSON(http.StatusOK, newWorker)
 }
 
-// listJobsOfWorker respond with all the jobs of the specified worker
-func (s *Manager) listJobsOfWorker(c *gin.Context) {
+// workerHeartbeat refreshes the last online time of a worker
+func (s *Manager) workerHeartbeat(c *gin.Context) {
 	workerID := c.Param("id")
 	s.rwmu.RLock()
-	mirrorStatusList, err := s.adapter.ListMirrorStatus(workerID)
+	_, err := s.adapter.RefreshWorker(workerID)
 	s.rwmu.RUnlock()
 	if err != nil {
-		err := fmt.Errorf("failed to list jobs of worker %s: %s",
-			workerID, err.Error(),
-		)
+		err := fmt.Errorf("failed to refresh worker %s: %s", workerID, err.Error())
 		c.Error(err)
 		s.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
+	s.triggerQueuedCmds(workerID, 0)
+	c.JSON(http.StatusOK, empty{})
+}
+
+// listJobsOfWorker respond with all the jobs of the specified worker
+func (s *Manager) listJobsOfWorker(c *gin.Context) {
+	workerID := c.Param("id")
+	mirrorStatusList, ok := s.queryJobs(c, workerID)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	for i := range mirrorStatusList {
+		mirrorStatusList[i].Stale = s.mirrorStale(mirrorStatusList[i], now)
+	}
 	c.JSON(http.StatusOK, mirrorStatusList)
 }
 
@@ -282,14 +523,15 @@ func (s *Manager) updateSchedulesOfWorker(c *gin.Context) {
 			continue
 		}
 
-		if curStatus.Scheduled == schedule.NextSchedule {
+		if curStatus.Scheduled == schedule.NextSchedule && curStatus.Deferred == schedule.Deferred {
 			// no changes, skip update
 			continue
 		}
 
 		curStatus.Scheduled = schedule.NextSchedule
+		curStatus.Deferred = schedule.Deferred
 		s.rwmu.Lock()
-		_, err = s.adapter.UpdateMirrorStatus(workerID, mirrorName, curStatus)
+		newStatus, err := s.adapter.UpdateMirrorStatus(workerID, mirrorName, curStatus)
 		s.rwmu.Unlock()
 		if err != nil {
 			err := fmt.Errorf("failed to update job %s of worker %s: %s",
@@ -299,8 +541,9 @@ func (s *Manager) updateSchedulesOfWorker(c *gin.Context) {
 			s.returnErrJSON(c, http.StatusInternalServerError, err)
 			return
 		}
+		s.statusChanged()
+		s.publishJobEvent(EventJobSchedule, newStatus)
 	}
-	type empty struct{}
 	c.JSON(http.StatusOK, empty{})
 }
 
@@ -346,6 +589,11 @@ func (s *Manager) updateJobOfWorker(c *gin.Context) {
 			status.Size = curStatus.Size
 		}
 	}
+	// workers of older versions do not report the interval
+	if status.Interval == 0 {
+		status.Interval = curStatus.Interval
+	}
+	status.Stale = false
 
 	// for logging
 	switch status.Status {
@@ -366,9 +614,104 @@ func (s *Manager) updateJobOfWorker(c *gin.Context) {
 		s.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
+	s.statusChanged()
+	s.publishJobEvent(EventJobStatus, newStatus)
+	if status.Status == Success || status.Status == Failed {
+		s.addSyncRecord(workerID, newStatus, curTime)
+		s.notifyJobResult(workerID, newStatus)
+	}
+	s.triggerQueuedCmds(workerID, 0)
 	c.JSON(http.StatusOK, newStatus)
 }
 
+// addSyncRecord saves the result of a sync run into the history
+func (s *Manager) addSyncRecord(workerID string, status MirrorStatus, ended time.Time) {
+	started := status.LastStarted
+	if started.IsZero() {
+		started = ended
+	}
+	record := SyncRecord{
+		Name:     status.Name,
+		Worker:   workerID,
+		Status:   status.Status,
+		Started:  started,
+		Ended:    ended,
+		Size:     status.Size,
+		ErrorMsg: status.ErrorMsg,
+	}
+
+	s.rwmu.Lock()
+	defer s.rwmu.Unlock()
+	if err := s.adapter.AddSyncRecord(workerID, status.Name, record); err != nil {
+		logger.Errorf("failed to add sync record of job %s of worker %s: %s",
+			status.Name, workerID, err.Error(),
+		)
+		return
+	}
+	if s.config().History.Retention > 0 {
+		before := ended.AddDate(0, 0, -s.config().History.Retention)
+		if err := s.adapter.PruneSyncRecords(workerID, status.Name, before); err != nil {
+			logger.Errorf("failed to prune sync records of job %s of worker %s: %s",
+				status.Name, workerID, err.Error(),
+			)
+		}
+	}
+}
+
+// listSyncHistory respond with the sync history of a job,
+// newest first
+func (s *Manager) listSyncHistory(c *gin.Context) {
+	workerID := c.Param("id")
+	mirrorName := c.Param("job")
+
+	since, err := parseTimeParam(c.Query("since"))
+	if err != nil {
+		s.returnErrJSON(c, http.StatusBadRequest, fmt.Errorf("invalid since: %s", err.Error()))
+		return
+	}
+	until, err := parseTimeParam(c.Query("until"))
+	if err != nil {
+		s.returnErrJSON(c, http.StatusBadRequest, fmt.Errorf("invalid until: %s", err.Error()))
+		return
+	}
+	limit := 0
+	if l := c.Query("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			s.returnErrJSON(c, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", l))
+			return
+		}
+	}
+
+	s.rwmu.RLock()
+	records, err := s.adapter.ListSyncRecords(workerID, mirrorName, since, until, limit)
+	s.rwmu.RUnlock()
+	if err != nil {
+		err := fmt.Errorf("failed to list sync history of job %s of worker %s: %s",
+			mirrorName, workerID, err.Error(),
+		)
+		c.Error(err)
+		s.returnErrJSON(c, http.StatusInternalServerError, err)
+		return
+	}
+	if records == nil {
+		records = []SyncRecord{}
+	}
+	c.JSON(http.StatusOK, records)
+}
+
+// parseTimeParam accepts either a unix timestamp or a RFC3339 time,
+// an empty string results in a zero time
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if ts, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
 func (s *Manager) updateMirrorSize(c *gin.Context) {
 	workerID := c.Param("id")
 	type SizeMsg struct {
@@ -379,6 +722,7 @@ func (s *Manager) updateMirrorSize(c *gin.Context) {
 	c.BindJSON(&msg)
 
 	mirrorName := msg.Name
+	auditRecord := AuditRecord{Action: auditSetSize, WorkerID: workerID, MirrorID: mirrorName, Args: []string{msg.Size}}
 	s.rwmu.RLock()
 	s.adapter.RefreshWorker(workerID)
 	status, err := s.adapter.GetMirrorStatus(workerID, mirrorName)
@@ -388,6 +732,8 @@ func (s *Manager) updateMirrorSize(c *gin.Context) {
 			"Failed to get status of mirror %s @<%s>: %s",
 			mirrorName, workerID, err.Error(),
 		)
+		auditRecord.Code, auditRecord.Message = http.StatusInternalServerError, err.Error()
+		s.audit(c, auditRecord)
 		s.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
@@ -407,69 +753,293 @@ func (s *Manager) updateMirrorSize(c *gin.Context) {
 			mirrorName, workerID, err.Error(),
 		)
 		c.Error(err)
+		auditRecord.Code, auditRecord.Message = http.StatusInternalServerError, err.Error()
+		s.audit(c, auditRecord)
 		s.returnErrJSON(c, http.StatusInternalServerError, err)
 		return
 	}
+	s.statusChanged()
+	s.publishJobEvent(EventJobSize, newStatus)
+	auditRecord.Code, auditRecord.Message = http.StatusOK, "size set to "+newStatus.Size
+	s.audit(c, auditRecord)
 	c.JSON(http.StatusOK, newStatus)
 }
 
 func (s *Manager) handleClientCmd(c *gin.Context) {
 	var clientCmd ClientCmd
 	c.BindJSON(&clientCmd)
-	workerID := clientCmd.WorkerID
-	if workerID == "" {
-		// TODO: decide which worker should do this mirror when WorkerID is null string
-		logger.Errorf("handleClientCmd case workerID == \" \" not implemented yet")
-		c.AbortWithStatus(http.StatusInternalServerError)
+	auditRecord := func(workerID string, code int, msg string) AuditRecord {
+		return AuditRecord{Action: clientCmd.Cmd.String(), WorkerID: workerID,
+			MirrorID: clientCmd.MirrorID, Args: cmdAuditArgs(clientCmd.Args, clientCmd.Options),
+			Code: code, Message: msg}
+	}
+	if clientCmd.QueueTTL < 0 || clientCmd.QueueTTL > maxCmdQueueTTL {
+		err := fmt.Errorf("invalid queue_ttl: %d, at most %d seconds", clientCmd.QueueTTL, maxCmdQueueTTL)
+		s.returnErrJSON(c, http.StatusBadRequest, err)
+		return
+	}
+	workerIDs := []string{clientCmd.WorkerID}
+	if clientCmd.WorkerID == "" {
+		if clientCmd.MirrorID == "" {
+			err := errors.New("worker ID is required for worker commands")
+			s.audit(c, auditRecord("", http.StatusBadRequest, err.Error()))
+			s.returnErrJSON(c, http.StatusBadRequest, err)
+			return
+		}
+		ids, code, err := s.resolveCmdWorkers(clientCmd)
+		if err != nil {
+			c.Error(err)
+			s.audit(c, auditRecord("", code, err.Error()))
+			s.returnErrJSON(c, code, err)
+			return
+		}
+		workerIDs = ids
+	}
+
+	// the notes of the workers on the commands accepted
+	var sent, queued, failed, notes []string
+	for _, workerID := range workerIDs {
+		result := s.dispatchClientCmd(c, workerID, clientCmd)
+		if result.Code == http.StatusAccepted {
+			queued = append(queued, result.Message)
+			continue
+		}
+		if result.Code != http.StatusOK {
+			err := errors.New(result.Message)
+			c.Error(err)
+			if len(workerIDs) == 1 {
+				s.returnErrJSON(c, result.Code, err)
+				return
+			}
+			failed = append(failed, result.Message)
+			continue
+		}
+		if note, ok := strings.CutPrefix(result.Message, "command accepted: "); ok {
+			notes = append(notes, fmt.Sprintf("worker %s: %s", workerID, note))
+		}
+		sent = append(sent, workerID)
+	}
+	if len(failed) > 0 {
+		msg := strings.Join(failed, "; ")
+		if len(sent) > 0 {
+			msg = fmt.Sprintf("command sent to worker %s, but %s", strings.Join(sent, ", "), msg)
+		}
+		if len(queued) > 0 {
+			msg = fmt.Sprintf("%s; %s", msg, strings.Join(queued, "; "))
+		}
+		s.returnErrJSON(c, http.StatusInternalServerError, errors.New(msg))
 		return
 	}
+	if len(sent) == 0 {
+		c.JSON(http.StatusAccepted, gin.H{_infoKey: strings.Join(queued, "; ")})
+		return
+	}
+	msg := "successfully send command to worker " + strings.Join(sent, ", ")
+	if len(notes) > 0 {
+		msg = fmt.Sprintf("%s; %s", msg, strings.Join(notes, "; "))
+	}
+	if len(queued) > 0 {
+		msg = fmt.Sprintf("%s; %s", msg, strings.Join(queued, "; "))
+	}
+	c.JSON(http.StatusOK, gin.H{_infoKey: msg})
+}
+
+// dispatchClientCmd sends the command to the worker, queues it if the
+// worker is unreachable and the command asks for it, and audits it
+func (s *Manager) dispatchClientCmd(c *gin.Context, workerID string, clientCmd ClientCmd) CmdResult {
+	result := s.sendClientCmd(workerID, clientCmd)
+	if clientCmd.QueueTTL > 0 && cmdRetryable(result.Code) {
+		result = s.queueClientCmd(c, workerID, clientCmd, result)
+	}
+	s.audit(c, AuditRecord{Action: clientCmd.Cmd.String(), WorkerID: workerID,
+		MirrorID: clientCmd.MirrorID, Args: cmdAuditArgs(clientCmd.Args, clientCmd.Options),
+		Code: result.Code, Message: result.Message})
+	return result
+}
+
+// queueClientCmd queues the command the worker is unreachable for,
+// the result of the queued command has the status code 202
+func (s *Manager) queueClientCmd(c *gin.Context, workerID string, clientCmd ClientCmd, result CmdResult) CmdResult {
+	q := newQueuedCmd(workerID, workerCmdOf(clientCmd), c.GetString(_identityKey),
+		time.Duration(clientCmd.QueueTTL)*time.Second)
+	if err := s.queueCmd(q); err != nil {
+		result.Code = http.StatusInternalServerError
+		result.Message = fmt.Sprintf("%s, and failed to queue the command: %s", result.Message, err.Error())
+		return result
+	}
+	result.Code = http.StatusAccepted
+	result.Message = fmt.Sprintf("%s, command queued as %s until %s",
+		result.Message, q.ID, q.Expires.Format(time.RFC3339))
+	return result
+}
 
+// resolveCmdWorkers finds the workers hosting the mirror of a command
+// without worker ID. The command goes to the only worker hosting the
+// mirror, or the master replica if there are several of them, unless
+// the options ask for the master or all the replicas explicitly.
+func (s *Manager) resolveCmdWorkers(clientCmd ClientCmd) ([]string, int, error) {
 	s.rwmu.RLock()
-	w, err := s.adapter.GetWorker(workerID)
+	mirrorStatusList, err := s.adapter.ListAllMirrorStatus()
 	s.rwmu.RUnlock()
 	if err != nil {
-		err := fmt.Errorf("worker %s is not registered yet", workerID)
-		s.returnErrJSON(c, http.StatusBadRequest, err)
-		return
+		err := fmt.Errorf("failed to list all mirror status: %s", err.Error())
+		return nil, http.StatusInternalServerError, err
+	}
+
+	var hosts, masters []string
+	for _, m := range mirrorStatusList {
+		if m.Name != clientCmd.MirrorID {
+			continue
+		}
+		hosts = append(hosts, m.Worker)
+		if m.IsMaster {
+			masters = append(masters, m.Worker)
+		}
+	}
+	sort.Strings(hosts)
+	sort.Strings(masters)
+	if len(hosts) == 0 {
+		return nil, http.StatusNotFound,
+			fmt.Errorf("mirror %s is not found on any worker", clientCmd.MirrorID)
+	}
+
+	if clientCmd.Options[cmdOptAllWorkers] {
+		return hosts, http.StatusOK, nil
 	}
-	workerURL := w.URL
-	// parse client cmd into worker cmd
-	workerCmd := WorkerCmd{
+	if clientCmd.Options[cmdOptMaster] {
+		if len(masters) == 0 {
+			return nil, http.StatusNotFound,
+				fmt.Errorf("mirror %s has no master on any worker", clientCmd.MirrorID)
+		}
+		hosts = masters
+	} else if len(hosts) > 1 && len(masters) == 1 {
+		hosts = masters
+	}
+	if len(hosts) > 1 {
+		return nil, http.StatusConflict,
+			fmt.Errorf("mirror %s is hosted on workers %s, specify one of them or send to all of them",
+				clientCmd.MirrorID, strings.Join(hosts, ", "))
+	}
+	return hosts, http.StatusOK, nil
+}
+
+// workerCmdOf parses the client command into the worker command
+func workerCmdOf(clientCmd ClientCmd) WorkerCmd {
+	return WorkerCmd{
 		Cmd:      clientCmd.Cmd,
 		MirrorID: clientCmd.MirrorID,
 		Args:     clientCmd.Args,
 		Options:  clientCmd.Options,
 	}
+}
+
+// sendClientCmd sends the command to the worker, after the commands
+// queued for the worker
+func (s *Manager) sendClientCmd(workerID string, clientCmd ClientCmd) CmdResult {
+	s.flushQueuedCmds(workerID)
+	return s.sendWorkerCmd(workerID, workerCmdOf(clientCmd))
+}
 
-	// update job status, even if the job did not disable successfully,
-	// this status should be set as disabled
+// sendWorkerCmd posts the command to the worker and waits for its
+// reply, the status of the mirror is changed only once the worker
+// has accepted the command
+func (s *Manager) sendWorkerCmd(workerID string, workerCmd WorkerCmd) CmdResult {
+	result := CmdResult{WorkerID: workerID, MirrorID: workerCmd.MirrorID}
 	s.rwmu.RLock()
-	curStat, _ := s.adapter.GetMirrorStatus(clientCmd.WorkerID, clientCmd.MirrorID)
+	w, err := s.adapter.GetWorker(workerID)
 	s.rwmu.RUnlock()
-	changed := false
-	switch clientCmd.Cmd {
+	if err != nil {
+		result.Code = http.StatusBadRequest
+		result.Message = fmt.Sprintf("worker %s is not registered yet", workerID)
+		return result
+	}
+
+	send := s.postWorkerCmd
+	if w.Pull {
+		send = s.pullWorkerCmd
+	}
+	logger.Noticef("Posting command '%s %s' to <%s>", workerCmd.Cmd, workerCmd.MirrorID, workerID)
+	code, msg, err := send(w, workerCmd)
+	if err != nil {
+		result.Code = code
+		result.Message = err.Error()
+		return result
+	}
+	if code/100 != 2 {
+		result.Code = code
+		result.Message = fmt.Sprintf("worker %s rejected the command: %s", workerID, msg)
+		return result
+	}
+	result.Code = http.StatusOK
+	result.Message = "command accepted"
+	if msg != "" && msg != "OK" {
+		// e.g. the start is deferred by the sync windows
+		result.Message = fmt.Sprintf("command accepted: %s", msg)
+	}
+
+	var newStat SyncStatus
+	switch workerCmd.Cmd {
 	case CmdDisable:
-		curStat.Status = Disabled
-		changed = true
+		newStat = Disabled
 	case CmdStop:
-		curStat.Status = Paused
-		changed = true
+		newStat = Paused
+	default:
+		return result
 	}
-	if changed {
-		s.rwmu.Lock()
-		s.adapter.UpdateMirrorStatus(clientCmd.WorkerID, clientCmd.MirrorID, curStat)
-		s.rwmu.Unlock()
+	// the worker reports the status on its own as well,
+	// which could come later than the next status query
+	s.rwmu.Lock()
+	curStat, _ := s.adapter.GetMirrorStatus(workerID, workerCmd.MirrorID)
+	curStat.Status = newStat
+	newStatus, err := s.adapter.UpdateMirrorStatus(workerID, workerCmd.MirrorID, curStat)
+	s.rwmu.Unlock()
+	if err != nil {
+		logger.Errorf("Failed to update status of job %s of worker %s: %s",
+			workerCmd.MirrorID, workerID, err.Error())
+		return result
 	}
+	s.statusChanged()
+	s.publishJobEvent(EventJobStatus, newStatus)
+	return result
+}
+
+// cmdTimeout is how long the workers are waited for to reply the commands
+func (s *Manager) cmdTimeout() time.Duration {
+	timeout := time.Duration(s.config().Server.CmdTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultCmdTimeout * time.Second
+	}
+	return timeout
+}
 
-	logger.Noticef("Posting command '%s %s' to <%s>", clientCmd.Cmd, clientCmd.MirrorID, clientCmd.WorkerID)
-	// post command to worker
-	_, err = PostJSON(workerURL, workerCmd, s.httpClient)
+// postWorkerCmd posts the command to the worker, and returns the
+// status code and the message of its reply. The error tells why the
+// worker is not reached, with the status code for the client.
+func (s *Manager) postWorkerCmd(w WorkerStatus, cmd WorkerCmd) (int, string, error) {
+	timeout := s.cmdTimeout()
+	client := *s.workerClient()
+	client.Timeout = timeout
+
+	resp, err := PostSignedJSON(w.URL, cmd, w.Token, &client)
 	if err != nil {
-		err := fmt.Errorf("post command to worker %s(%s) fail: %s", workerID, workerURL, err.Error())
-		c.Error(err)
-		s.returnErrJSON(c, http.StatusInternalServerError, err)
-		return
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return http.StatusGatewayTimeout, "",
+				fmt.Errorf("worker %s (%s) did not answer in %s", w.ID, w.URL, timeout)
+		}
+		return http.StatusBadGateway, "",
+			fmt.Errorf("worker %s (%s) is unreachable: %s", w.ID, w.URL, err.Error())
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxWorkerReplySize))
+	var reply struct {
+		Msg string `json:"msg"`
+	}
+	msg := http.StatusText(resp.StatusCode)
+	if err := json.Unmarshal(body, &reply); err == nil && reply.Msg != "" {
+		msg = reply.Msg
+	} else if text := strings.TrimSpace(string(body)); text != "" && err != nil {
+		msg = text
 	}
-	// TODO: check response for success
-	c.JSON(http.StatusOK, gin.H{_infoKey: "successfully send command to worker " + workerID})
+	return resp.StatusCode, msg, nil
 }
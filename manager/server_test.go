@@ -1,11 +1,15 @@
 package manager
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
+	"net/http/httptest"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -94,6 +98,140 @@ func TestHTTPServer(t *testing.T) {
 			})
 		})
 
+		Convey("when authentication is enabled", func(ctx C) {
+			s.cfg.Auth = AuthConfig{
+				WorkerToken:  "worker_secret",
+				WorkerTokens: map[string]string{"test_worker_special": "special_secret"},
+				AdminTokens:  map[string]string{"alice": "admin_secret"},
+			}
+			defer func() { s.cfg.Auth = AuthConfig{} }()
+
+			w := WorkerStatus{
+				ID: "test_worker_auth",
+			}
+			resp, err := PostJSON(baseURL+"/workers", w, nil)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			resp, err = PostJSONWithToken(baseURL+"/workers", WorkerStatus{ID: "test_worker_special"}, "worker_secret", nil)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			resp, err = PostJSONWithToken(baseURL+"/workers", w, "worker_secret", nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			var registered WorkerStatus
+			err = json.NewDecoder(resp.Body).Decode(&registered)
+			resp.Body.Close()
+			So(err, ShouldBeNil)
+			So(registered.Token, ShouldNotBeEmpty)
+
+			status := MirrorStatus{
+				Name:     "arch-sync-auth",
+				Worker:   w.ID,
+				IsMaster: true,
+				Status:   Success,
+			}
+			statusURL := fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL, w.ID, status.Name)
+			resp, err = PostJSON(statusURL, status, nil)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			resp, err = PostJSONWithToken(statusURL, status, "worker_secret", nil)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			resp, err = PostJSONWithToken(statusURL, status, registered.Token, nil)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			Convey("the token of a worker registering again is kept from the refreshes", func(ctx C) {
+				stop := make(chan empty)
+				refreshed := make(chan empty)
+				go func() {
+					defer close(refreshed)
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						// as the heartbeats and the reports do
+						s.rwmu.RLock()
+						s.adapter.RefreshWorker(w.ID)
+						s.rwmu.RUnlock()
+					}
+				}()
+				for i := 0; i < 20; i++ {
+					resp, err := PostJSONWithToken(baseURL+"/workers", w, "worker_secret", nil)
+					So(err, ShouldBeNil)
+					var again WorkerStatus
+					So(json.NewDecoder(resp.Body).Decode(&again), ShouldBeNil)
+					resp.Body.Close()
+					stored, err := s.adapter.GetWorker(w.ID)
+					So(err, ShouldBeNil)
+					So(stored.Token, ShouldEqual, again.Token)
+				}
+				close(stop)
+				<-refreshed
+			})
+
+			Convey("admin endpoints require an admin token", func(ctx C) {
+				req, err := http.NewRequest("DELETE", baseURL+"/jobs/disabled", nil)
+				So(err, ShouldBeNil)
+				resp, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+				SetBearerToken(req, registered.Token)
+				resp, err = http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+				SetBearerToken(req, "admin_secret")
+				resp, err = http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+				Convey("and are audited", func(ctx C) {
+					resp, err := http.Get(baseURL + "/audit")
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+					req, err := http.NewRequest("GET", baseURL+"/audit?identity=alice", nil)
+					So(err, ShouldBeNil)
+					SetBearerToken(req, "admin_secret")
+					resp, err = http.DefaultClient.Do(req)
+					So(err, ShouldBeNil)
+					defer resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					var records []AuditRecord
+					So(json.NewDecoder(resp.Body).Decode(&records), ShouldBeNil)
+					So(records, ShouldHaveLength, 1)
+					So(records[0].Action, ShouldEqual, auditFlushDisabled)
+					So(records[0].Identity, ShouldEqual, "alice")
+					So(records[0].Source, ShouldEqual, "127.0.0.1")
+					So(records[0].Code, ShouldEqual, http.StatusOK)
+				})
+			})
+
+			Convey("read-only endpoints stay public", func(ctx C) {
+				resp, err := http.Get(baseURL + "/jobs")
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			})
+		})
+
 		Convey("when register a worker", func(ctx C) {
 			w := WorkerStatus{
 				ID: "test_worker1",
@@ -152,6 +290,137 @@ func TestHTTPServer(t *testing.T) {
 				So(res[_infoKey], ShouldEqual, "flushed")
 			})
 
+			Convey("when the worker goes offline", func(ctx C) {
+				s.cfg.Status.OfflineTimeout = 60
+				defer func() { s.cfg.Status.OfflineTimeout = 0 }()
+
+				status := MirrorStatus{
+					Name:     "arch-sync-offline",
+					Worker:   w.ID,
+					IsMaster: true,
+					Status:   Syncing,
+				}
+				resp, err := PostJSON(fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL, w.ID, status.Name), status, nil)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+
+				online := map[string]bool{}
+				s.checkWorkers(online)
+				So(online[w.ID], ShouldBeTrue)
+
+				// the worker is silent for a long time
+				mock := s.adapter.(*mockDBAdapter)
+				mock.workerLock.Lock()
+				ws := mock.workerStore[w.ID]
+				ws.LastOnline = time.Now().Add(-time.Hour)
+				mock.workerStore[w.ID] = ws
+				mock.workerLock.Unlock()
+
+				sub, _, _, _ := s.events.Subscribe(0, false, w.ID, "")
+				defer s.events.Unsubscribe(sub)
+				s.checkWorkers(online)
+				So(online[w.ID], ShouldBeFalse)
+				ev := <-sub.ch
+				So(ev.Type, ShouldEqual, EventWorkerOffline)
+
+				var workers []WorkerStatus
+				_, err = GetJSON(baseURL+"/workers", &workers, nil)
+				So(err, ShouldBeNil)
+				for _, worker := range workers {
+					if worker.ID == w.ID {
+						So(worker.Online, ShouldBeFalse)
+					}
+				}
+
+				var jobs []WebMirrorStatus
+				_, err = GetJSON(baseURL+"/jobs", &jobs, nil)
+				So(err, ShouldBeNil)
+				So(jobs, ShouldHaveLength, 1)
+				So(jobs[0].Status, ShouldEqual, Unknown)
+
+				// filtered by the status as shown
+				_, err = GetJSON(baseURL+"/jobs?status=unknown", &jobs, nil)
+				So(err, ShouldBeNil)
+				So(jobs, ShouldHaveLength, 1)
+				_, err = GetJSON(baseURL+"/jobs?status=syncing", &jobs, nil)
+				So(err, ShouldBeNil)
+				So(jobs, ShouldBeEmpty)
+
+				Convey("and comes back with a heartbeat", func(ctx C) {
+					resp, err := PostJSON(fmt.Sprintf("%s/workers/%s/heartbeat", baseURL, w.ID), struct{}{}, nil)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+					s.checkWorkers(online)
+					So(online[w.ID], ShouldBeTrue)
+					ev := <-sub.ch
+					So(ev.Type, ShouldEqual, EventWorkerOnline)
+
+					var jobs []WebMirrorStatus
+					_, err = GetJSON(baseURL+"/jobs", &jobs, nil)
+					So(err, ShouldBeNil)
+					So(jobs[0].Status, ShouldEqual, Syncing)
+				})
+			})
+
+			Convey("stream status events", func(ctx C) {
+				readEvent := func(r *bufio.Reader) (id string, data Event) {
+					for {
+						line, err := r.ReadString('\n')
+						So(err, ShouldBeNil)
+						line = strings.TrimSpace(line)
+						if line == "" && id != "" {
+							return
+						}
+						if v, ok := strings.CutPrefix(line, "id: "); ok {
+							id = v
+						} else if v, ok := strings.CutPrefix(line, "data: "); ok {
+							So(json.Unmarshal([]byte(v), &data), ShouldBeNil)
+						}
+					}
+				}
+
+				resp, err := http.Get(baseURL + "/events?worker=" + w.ID)
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+
+				status := MirrorStatus{
+					Name:     "arch-sync-events",
+					Worker:   w.ID,
+					IsMaster: true,
+					Status:   PreSyncing,
+				}
+				for _, st := range []SyncStatus{PreSyncing, Syncing} {
+					status.Status = st
+					resp, err := PostJSON(fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL, w.ID, status.Name), status, nil)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+				}
+
+				r := bufio.NewReader(resp.Body)
+				firstID, ev := readEvent(r)
+				So(ev.Type, ShouldEqual, EventJobStatus)
+				So(ev.Mirror, ShouldEqual, status.Name)
+				So(ev.Status.Status, ShouldEqual, PreSyncing)
+				_, ev = readEvent(r)
+				So(ev.Status.Status, ShouldEqual, Syncing)
+				resp.Body.Close()
+
+				Convey("resume from the last event", func(ctx C) {
+					req, err := http.NewRequest("GET", baseURL+"/events", nil)
+					So(err, ShouldBeNil)
+					req.Header.Set("Last-Event-ID", firstID)
+					resp, err := http.DefaultClient.Do(req)
+					So(err, ShouldBeNil)
+					defer resp.Body.Close()
+					_, ev := readEvent(bufio.NewReader(resp.Body))
+					So(ev.Type, ShouldEqual, EventJobStatus)
+					So(ev.Status.Status, ShouldEqual, Syncing)
+				})
+			})
+
 			Convey("update mirror status of a existed worker", func(ctx C) {
 				status := MirrorStatus{
 					Name:     "arch-sync1",
@@ -166,6 +435,128 @@ func TestHTTPServer(t *testing.T) {
 				defer resp.Body.Close()
 				So(resp.StatusCode, ShouldEqual, http.StatusOK)
 
+				Convey("export metrics of mirrors and workers", func(ctx C) {
+					resp, err := http.Get(baseURL + "/metrics")
+					So(err, ShouldBeNil)
+					defer resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					body, err := io.ReadAll(resp.Body)
+					So(err, ShouldBeNil)
+					metrics := string(body)
+					So(metrics, ShouldContainSubstring,
+						`tunasync_mirror_status{mirror="arch-sync1",status="success",worker="test_worker1"} 1`)
+					So(metrics, ShouldContainSubstring,
+						`tunasync_mirror_status{mirror="arch-sync1",status="failed",worker="test_worker1"} 0`)
+					So(metrics, ShouldContainSubstring,
+						`tunasync_mirror_last_success_timestamp_seconds{mirror="arch-sync1",worker="test_worker1"}`)
+					So(metrics, ShouldNotContainSubstring, `tunasync_mirror_size_bytes{mirror="arch-sync1"`)
+					So(metrics, ShouldContainSubstring, `tunasync_worker_last_online_age_seconds{worker="test_worker1"}`)
+					So(metrics, ShouldContainSubstring,
+						`tunasync_manager_http_requests_total{code="200",method="POST",path="/workers/:id/jobs/:job"}`)
+				})
+
+				Convey("mark the mirrors not updated for long as stale", func(ctx C) {
+					defer func() { s.cfg.Status.StaleFactor = 0 }()
+					status.Interval = 1
+					resp, err := PostJSON(fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL, status.Worker, status.Name), status, nil)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					// a status without the interval keeps the reported one
+					status.Status = PreSyncing
+					status.Interval = 0
+					resp, err = PostJSON(fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL, status.Worker, status.Name), status, nil)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+
+					s.cfg.Status.StaleFactor = 3
+					var ms []WebMirrorStatus
+					_, err = GetJSON(baseURL+"/jobs", &ms, nil)
+					So(err, ShouldBeNil)
+					So(ms, ShouldHaveLength, 1)
+					So(ms[0].Interval, ShouldEqual, 1)
+					So(ms[0].Stale, ShouldBeFalse)
+
+					// one minute times 1e-6 has passed
+					s.cfg.Status.StaleFactor = 1e-6
+					time.Sleep(10 * time.Millisecond)
+					_, err = GetJSON(baseURL+"/jobs", &ms, nil)
+					So(err, ShouldBeNil)
+					So(ms[0].Stale, ShouldBeTrue)
+					var workerJobs []MirrorStatus
+					_, err = GetJSON(baseURL+"/workers/test_worker1/jobs", &workerJobs, nil)
+					So(err, ShouldBeNil)
+					So(workerJobs[0].Stale, ShouldBeTrue)
+
+					stale := make(map[string]bool)
+					s.checkMirrors(stale)
+					So(stale["arch-sync1/test_worker1"], ShouldBeTrue)
+
+					s.cfg.Status.StaleFactor = 0
+					_, err = GetJSON(baseURL+"/jobs", &ms, nil)
+					So(err, ShouldBeNil)
+					So(ms[0].Stale, ShouldBeFalse)
+				})
+
+				Convey("query the jobs", func(ctx C) {
+					for _, name := range []string{"arch-sync2", "debian"} {
+						status := status
+						status.Name = name
+						status.Status = Failed
+						resp, err := PostJSON(fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL, status.Worker, status.Name), status, nil)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+					}
+
+					var ms []WebMirrorStatus
+					resp, err := GetJSON(baseURL+"/jobs?status=failed&name=arch-*", &ms, nil)
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					So(ms, ShouldHaveLength, 1)
+					So(ms[0].Name, ShouldEqual, "arch-sync2")
+
+					resp, err = GetJSON(baseURL+"/jobs?sort=-name&limit=2", &ms, nil)
+					So(err, ShouldBeNil)
+					So(ms, ShouldHaveLength, 2)
+					So(ms[0].Name, ShouldEqual, "debian")
+					So(ms[1].Name, ShouldEqual, "arch-sync2")
+					cursor := resp.Header.Get("X-Next-Cursor")
+					So(cursor, ShouldNotBeEmpty)
+					resp, err = GetJSON(baseURL+"/jobs?sort=-name&limit=2&cursor="+cursor, &ms, nil)
+					So(err, ShouldBeNil)
+					So(ms, ShouldHaveLength, 1)
+					So(ms[0].Name, ShouldEqual, "arch-sync1")
+					So(resp.Header.Get("X-Next-Cursor"), ShouldBeEmpty)
+
+					var workerJobs []MirrorStatus
+					_, err = GetJSON(baseURL+"/workers/test_worker1/jobs?status=success,syncing", &workerJobs, nil)
+					So(err, ShouldBeNil)
+					So(workerJobs, ShouldHaveLength, 1)
+					So(workerJobs[0].Name, ShouldEqual, "arch-sync1")
+
+					for _, query := range []string{"status=exploded", "sort=size", "limit=-1", "master=maybe", "cursor=xyz", "name=[a-"} {
+						resp, err := http.Get(baseURL + "/jobs?" + query)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+						So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+					}
+				})
+
+				Convey("list the status of each mirror on all its workers", func(ctx C) {
+					var groups []WebMirrorGroup
+					resp, err := GetJSON(baseURL+"/mirrors", &groups, nil)
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					So(groups, ShouldHaveLength, 1)
+					g := groups[0]
+					So(g.Name, ShouldEqual, status.Name)
+					So(g.Master, ShouldEqual, status.Worker)
+					So(g.Status, ShouldEqual, Success)
+					So(g.FailedWorkers, ShouldBeEmpty)
+					So(g.Replicas, ShouldHaveLength, 1)
+					So(g.Replicas[0].Worker, ShouldEqual, status.Worker)
+					So(*g.Replicas[0].Lag, ShouldEqual, 0)
+				})
+
 				Convey("list mirror status of an existed worker", func(ctx C) {
 					var ms []MirrorStatus
 					resp, err := GetJSON(baseURL+"/workers/test_worker1/jobs", &ms, nil)
@@ -263,12 +654,23 @@ func TestHTTPServer(t *testing.T) {
 						So(time.Since(m.LastStarted), ShouldBeLessThan, 2*time.Second)
 						So(time.Since(m.LastEnded), ShouldBeLessThan, 3*time.Second)
 					})
+
+					Convey("Export new size of a mirror", func(ctx C) {
+						resp, err := http.Get(baseURL + "/metrics")
+						So(err, ShouldBeNil)
+						defer resp.Body.Close()
+						body, err := io.ReadAll(resp.Body)
+						So(err, ShouldBeNil)
+						So(string(body), ShouldContainSubstring,
+							`tunasync_mirror_size_bytes{mirror="arch-sync1",worker="test_worker1"} 5.36870912e+09`)
+					})
 				})
 
 				Convey("Update schedule of valid mirrors", func(ctx C) {
 					msg := MirrorSchedules{
 						Schedules: []MirrorSchedule{
-							{MirrorName: "arch-sync1", NextSchedule: time.Now().Add(time.Minute * 10)},
+							{MirrorName: "arch-sync1", NextSchedule: time.Now().Add(time.Minute * 10),
+								Deferred: "outside sync windows 01:00-07:00"},
 							{MirrorName: "arch-sync2", NextSchedule: time.Now().Add(time.Minute * 7)},
 						},
 					}
@@ -277,6 +679,42 @@ func TestHTTPServer(t *testing.T) {
 					resp, err := PostJSON(url, msg, nil)
 					So(err, ShouldBeNil)
 					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+					var ms []WebMirrorStatus
+					_, err = GetJSON(baseURL+"/jobs?name=arch-sync1", &ms, nil)
+					So(err, ShouldBeNil)
+					So(ms, ShouldHaveLength, 1)
+					So(ms[0].Deferred, ShouldEqual, "outside sync windows 01:00-07:00")
+				})
+
+				Convey("Report the jobs waiting for a slot", func(ctx C) {
+					msg := WaitingJobs{
+						Running: 2,
+						Limit:   2,
+						Jobs: []WaitingJob{
+							{Name: "arch-sync2", Priority: 1, Due: time.Now().Add(-time.Minute), Since: time.Now()},
+							{Name: "arch-sync1", Due: time.Now().Add(-time.Hour), Since: time.Now()},
+						},
+					}
+					url := fmt.Sprintf("%s/workers/%s/waiting", baseURL, status.Worker)
+					resp, err := PostJSON(url, msg, nil)
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+					var list WaitingJobs
+					_, err = GetJSON(url, &list, nil)
+					So(err, ShouldBeNil)
+					So(list.Worker, ShouldEqual, status.Worker)
+					So(list.Running, ShouldEqual, 2)
+					So(list.Jobs, ShouldHaveLength, 2)
+					So(list.Jobs[0].Name, ShouldEqual, "arch-sync2")
+					So(time.Since(list.Updated), ShouldBeLessThan, time.Second)
+
+					var lists []WaitingJobs
+					_, err = GetJSON(baseURL+"/jobs/waiting", &lists, nil)
+					So(err, ShouldBeNil)
+					So(lists, ShouldHaveLength, 1)
+					So(lists[0].Worker, ShouldEqual, status.Worker)
 				})
 
 				Convey("Update size of an invalid mirror", func(ctx C) {
@@ -299,6 +737,26 @@ func TestHTTPServer(t *testing.T) {
 				defer resp.Body.Close()
 				So(resp.StatusCode, ShouldEqual, http.StatusOK)
 
+				Convey("list sync history of a job", func(ctx C) {
+					var rs []SyncRecord
+					url := fmt.Sprintf("%s/workers/%s/jobs/%s/history", baseURL, status.Worker, status.Name)
+					resp, err := GetJSON(url, &rs, nil)
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					So(len(rs), ShouldEqual, 2)
+					So(rs[0].Status, ShouldEqual, Failed)
+					So(rs[1].Status, ShouldEqual, Success)
+					So(time.Since(rs[0].Ended), ShouldBeLessThan, 1*time.Second)
+
+					resp, err = GetJSON(url+"?limit=1", &rs, nil)
+					So(err, ShouldBeNil)
+					So(len(rs), ShouldEqual, 1)
+
+					resp, err = http.Get(url + "?since=yesterday")
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+				})
+
 				Convey("What if syncing job failed", func(ctx C) {
 					var ms []MirrorStatus
 					resp, err := GetJSON(baseURL+"/workers/test_worker1/jobs", &ms, nil)
@@ -397,6 +855,450 @@ func TestHTTPServer(t *testing.T) {
 					So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
 				})
 
+				Convey("when client send cmd without worker ID", func(ctx C) {
+					status := MirrorStatus{
+						Name:     "ubuntu-sync",
+						Worker:   w.ID,
+						IsMaster: true,
+						Status:   Success,
+					}
+					resp, err := PostJSON(fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL, w.ID, status.Name), status, nil)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+
+					clientCmd := ClientCmd{
+						Cmd:      CmdStart,
+						MirrorID: "ubuntu-sync",
+					}
+					resp, err = PostJSON(baseURL+"/cmd", clientCmd, nil)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					cmd := <-cmdChan
+					So(cmd.MirrorID, ShouldEqual, clientCmd.MirrorID)
+
+					Convey("of an unknown mirror", func(ctx C) {
+						clientCmd.MirrorID = "not-exist-mirror"
+						resp, err := PostJSON(baseURL+"/cmd", clientCmd, nil)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+						So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+					})
+
+					Convey("of a mirror on several workers", func(ctx C) {
+						w2 := WorkerStatus{
+							ID:  "test_worker_cmd2",
+							URL: w.URL,
+						}
+						resp, err := PostJSON(baseURL+"/workers", w2, nil)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+						status.Worker = w2.ID
+						status.IsMaster = false
+						resp, err = PostJSON(fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL, w2.ID, status.Name), status, nil)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+
+						// the master is preferred
+						var res map[string]string
+						resp, err = PostJSON(baseURL+"/cmd", clientCmd, nil)
+						So(err, ShouldBeNil)
+						So(resp.StatusCode, ShouldEqual, http.StatusOK)
+						json.NewDecoder(resp.Body).Decode(&res)
+						resp.Body.Close()
+						So(res[_infoKey], ShouldEqual, "successfully send command to worker "+w.ID)
+						<-cmdChan
+
+						// fan out to all the replicas
+						clientCmd.Options = map[string]bool{"all_workers": true}
+						go func() {
+							<-cmdChan
+							<-cmdChan
+						}()
+						resp, err = PostJSON(baseURL+"/cmd", clientCmd, nil)
+						So(err, ShouldBeNil)
+						So(resp.StatusCode, ShouldEqual, http.StatusOK)
+						json.NewDecoder(resp.Body).Decode(&res)
+						resp.Body.Close()
+						So(res[_infoKey], ShouldEqual, "successfully send command to worker "+w.ID+", "+w2.ID)
+
+						// ambiguous without a single master
+						status.IsMaster = true
+						resp, err = PostJSON(fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL, w2.ID, status.Name), status, nil)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+						clientCmd.Options = nil
+						resp, err = PostJSON(baseURL+"/cmd", clientCmd, nil)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+						So(resp.StatusCode, ShouldEqual, http.StatusConflict)
+					})
+				})
+
+				Convey("when the worker does not accept the cmd", func(ctx C) {
+					status := MirrorStatus{
+						Name:     "not-on-worker",
+						Worker:   w.ID,
+						IsMaster: true,
+						Status:   Success,
+					}
+					resp, err := PostJSON(fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL, w.ID, status.Name), status, nil)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+
+					clientCmd := ClientCmd{
+						Cmd:      CmdDisable,
+						MirrorID: status.Name,
+						WorkerID: w.ID,
+					}
+					resp, err = PostJSON(baseURL+"/cmd", clientCmd, nil)
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+					var msg map[string]string
+					So(json.NewDecoder(resp.Body).Decode(&msg), ShouldBeNil)
+					resp.Body.Close()
+					So(msg[_errorKey], ShouldEqual, "worker test_worker_cmd rejected the command: Mirror ``not-on-worker'' not found")
+
+					// the status is kept as the worker does not disable it
+					m, err := s.adapter.GetMirrorStatus(w.ID, status.Name)
+					So(err, ShouldBeNil)
+					So(m.Status, ShouldEqual, Success)
+
+					var records []AuditRecord
+					_, err = GetJSON(baseURL+"/audit?worker="+w.ID, &records, nil)
+					So(err, ShouldBeNil)
+					So(records, ShouldHaveLength, 1)
+					So(records[0].Code, ShouldEqual, http.StatusNotFound)
+				})
+
+				Convey("when the worker accepts the disable cmd", func(ctx C) {
+					clientCmd := ClientCmd{
+						Cmd:      CmdDisable,
+						MirrorID: "ubuntu-sync",
+						WorkerID: w.ID,
+					}
+					resp, err := PostJSON(baseURL+"/cmd", clientCmd, nil)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					<-cmdChan
+					m, err := s.adapter.GetMirrorStatus(w.ID, clientCmd.MirrorID)
+					So(err, ShouldBeNil)
+					So(m.Status, ShouldEqual, Disabled)
+				})
+
+				Convey("when the worker does not answer", func(ctx C) {
+					defer func() { s.cfg.Server.CmdTimeout = 0 }()
+					s.cfg.Server.CmdTimeout = 1
+					clientCmd := ClientCmd{
+						Cmd:      CmdStart,
+						MirrorID: "slow-mirror",
+						WorkerID: w.ID,
+					}
+					resp, err := PostJSON(baseURL+"/cmd", clientCmd, nil)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusGatewayTimeout)
+					<-cmdChan
+				})
+
+				Convey("when the worker is unreachable", func(ctx C) {
+					w3 := WorkerStatus{
+						ID:  "test_worker_down",
+						URL: "http://127.0.0.1:1/cmd",
+					}
+					resp, err := PostJSON(baseURL+"/workers", w3, nil)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					clientCmd := ClientCmd{
+						Cmd:      CmdStart,
+						MirrorID: "ubuntu-sync",
+						WorkerID: w3.ID,
+					}
+					resp, err = PostJSON(baseURL+"/cmd", clientCmd, nil)
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusBadGateway)
+					var msg map[string]string
+					So(json.NewDecoder(resp.Body).Decode(&msg), ShouldBeNil)
+					resp.Body.Close()
+					So(msg[_errorKey], ShouldStartWith, "worker test_worker_down (http://127.0.0.1:1/cmd) is unreachable")
+				})
+
+				Convey("proxy the logs of a job", func(ctx C) {
+					req, err := http.NewRequest(http.MethodGet,
+						fmt.Sprintf("%s/workers/%s/jobs/ubuntu-sync/log?tail=10", baseURL, w.ID), nil)
+					So(err, ShouldBeNil)
+					SetBearerToken(req, "admin-token")
+					resp, err := http.DefaultClient.Do(req)
+					So(err, ShouldBeNil)
+					body, err := io.ReadAll(resp.Body)
+					resp.Body.Close()
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					So(resp.Header.Get(LogFileHeader), ShouldEqual, "ubuntu-sync_2024-05-02_10_00.log")
+					So(string(body), ShouldEqual, "log of ubuntu-sync, tail 10, authorization \"\"\n")
+
+					w6 := WorkerStatus{ID: "test_worker_without_server", Pull: true}
+					resp, err = PostJSON(baseURL+"/workers", w6, nil)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					resp, err = http.Get(fmt.Sprintf("%s/workers/%s/jobs/ubuntu-sync/log", baseURL, w6.ID))
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusNotImplemented)
+				})
+
+				Convey("when the worker pulls the cmd", func(ctx C) {
+					w5 := WorkerStatus{ID: "test_worker_nat", Pull: true}
+					resp, err := PostJSON(baseURL+"/workers", w5, nil)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+
+					polled := make(chan WorkerCmd, 1)
+					go func() {
+						resp, err := http.Get(fmt.Sprintf("%s/workers/%s/cmd?wait=5", baseURL, w5.ID))
+						if err != nil {
+							return
+						}
+						var pulled PulledCmd
+						json.NewDecoder(resp.Body).Decode(&pulled)
+						resp.Body.Close()
+						polled <- pulled.Cmd
+						reply := CmdReply{Code: http.StatusOK, Message: "OK"}
+						resp, err = PostJSON(fmt.Sprintf("%s/workers/%s/cmd/%s", baseURL, w5.ID, pulled.ID), reply, nil)
+						if err == nil {
+							resp.Body.Close()
+						}
+					}()
+
+					clientCmd := ClientCmd{
+						Cmd:      CmdDisable,
+						MirrorID: "ubuntu-sync",
+						WorkerID: w5.ID,
+					}
+					resp, err = PostJSON(baseURL+"/cmd", clientCmd, nil)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					So(<-polled, ShouldResemble, workerCmdOf(clientCmd))
+					m, err := s.adapter.GetMirrorStatus(w5.ID, clientCmd.MirrorID)
+					So(err, ShouldBeNil)
+					So(m.Status, ShouldEqual, Disabled)
+
+					Convey("but is not polling", func(ctx C) {
+						defer func() { s.cfg.Server.CmdTimeout = 0 }()
+						s.cfg.Server.CmdTimeout = 1
+						resp, err := PostJSON(baseURL+"/cmd", clientCmd, nil)
+						So(err, ShouldBeNil)
+						var msg map[string]string
+						So(json.NewDecoder(resp.Body).Decode(&msg), ShouldBeNil)
+						resp.Body.Close()
+						So(resp.StatusCode, ShouldEqual, http.StatusBadGateway)
+						So(msg[_errorKey], ShouldEqual, "worker test_worker_nat is not polling for commands")
+					})
+
+					Convey("and drops the poll as the cmd is handed", func(ctx C) {
+						result := make(chan int, 1)
+						go func() {
+							code, _, _ := s.pullWorkerCmd(w5, workerCmdOf(clientCmd))
+							result <- code
+						}()
+
+						// the polls over do not take the cmd away
+						over, cancel := context.WithCancel(context.Background())
+						cancel()
+						for i := 0; i < 10; i++ {
+							req := httptest.NewRequest(http.MethodGet,
+								fmt.Sprintf("/workers/%s/cmd?wait=1", w5.ID), nil).WithContext(over)
+							rec := httptest.NewRecorder()
+							s.engine.ServeHTTP(rec, req)
+							So(rec.Body.Len(), ShouldEqual, 0)
+						}
+
+						resp, err := http.Get(fmt.Sprintf("%s/workers/%s/cmd?wait=5", baseURL, w5.ID))
+						So(err, ShouldBeNil)
+						var pulled PulledCmd
+						So(json.NewDecoder(resp.Body).Decode(&pulled), ShouldBeNil)
+						resp.Body.Close()
+						So(pulled.Cmd, ShouldResemble, workerCmdOf(clientCmd))
+						reply := CmdReply{Code: http.StatusOK, Message: "OK"}
+						resp, err = PostJSON(fmt.Sprintf("%s/workers/%s/cmd/%s", baseURL, w5.ID, pulled.ID), reply, nil)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+						So(<-result, ShouldEqual, http.StatusOK)
+					})
+
+					Convey("and replies an unknown cmd", func(ctx C) {
+						reply := CmdReply{Code: http.StatusOK, Message: "OK"}
+						resp, err := PostJSON(fmt.Sprintf("%s/workers/%s/cmd/%s", baseURL, w5.ID, "none"), reply, nil)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+						So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+					})
+				})
+
+				Convey("when the cmd is queued for an unreachable worker", func(ctx C) {
+					downPort := rand.Intn(10000) + 40000
+					downAddress := fmt.Sprintf("127.0.0.1:%d", downPort)
+					w4 := WorkerStatus{
+						ID:  "test_worker_rebooting",
+						URL: fmt.Sprintf("http://%s/cmd", downAddress),
+					}
+					resp, err := PostJSON(baseURL+"/workers", w4, nil)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+
+					clientCmd := ClientCmd{
+						Cmd:      CmdDisable,
+						MirrorID: "ubuntu-sync",
+						WorkerID: w4.ID,
+						QueueTTL: 60,
+					}
+					resp, err = PostJSON(baseURL+"/cmd", clientCmd, nil)
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusAccepted)
+					var msg map[string]string
+					So(json.NewDecoder(resp.Body).Decode(&msg), ShouldBeNil)
+					resp.Body.Close()
+					So(msg[_infoKey], ShouldContainSubstring, "command queued as")
+
+					var queued []QueuedCmd
+					_, err = GetJSON(baseURL+"/cmd/queue?worker="+w4.ID, &queued, nil)
+					So(err, ShouldBeNil)
+					So(queued, ShouldHaveLength, 1)
+					So(queued[0].Cmd.Cmd, ShouldEqual, CmdDisable)
+					So(queued[0].Expires.Sub(queued[0].Created), ShouldEqual, time.Minute)
+
+					Convey("deliver it when the worker reports", func(ctx C) {
+						downChan := make(chan WorkerCmd, 1)
+						go makeMockWorkerServer(downChan).Run(downAddress)
+						time.Sleep(50 * time.Millisecond)
+
+						status := MirrorStatus{Name: "ubuntu-sync", Worker: w4.ID, Status: Success}
+						resp, err := PostJSON(fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL, w4.ID, status.Name), status, nil)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+						select {
+						case cmd := <-downChan:
+							So(cmd.Cmd, ShouldEqual, CmdDisable)
+						case <-time.After(3 * time.Second):
+							So("the queued cmd is not delivered", ShouldBeEmpty)
+						}
+						time.Sleep(100 * time.Millisecond)
+
+						m, err := s.adapter.GetMirrorStatus(w4.ID, status.Name)
+						So(err, ShouldBeNil)
+						So(m.Status, ShouldEqual, Disabled)
+						_, err = GetJSON(baseURL+"/cmd/queue", &queued, nil)
+						So(err, ShouldBeNil)
+						So(queued, ShouldBeEmpty)
+
+						var records []AuditRecord
+						_, err = GetJSON(baseURL+"/audit?worker="+w4.ID, &records, nil)
+						So(err, ShouldBeNil)
+						So(records, ShouldHaveLength, 2)
+						So(records[0].Source, ShouldEqual, cmdQueueSource)
+						So(records[0].Code, ShouldEqual, http.StatusOK)
+						So(records[1].Code, ShouldEqual, http.StatusAccepted)
+					})
+
+					Convey("cancel it", func(ctx C) {
+						req, err := http.NewRequest("DELETE",
+							fmt.Sprintf("%s/cmd/queue/%s/%s", baseURL, w4.ID, queued[0].ID), nil)
+						So(err, ShouldBeNil)
+						resp, err := http.DefaultClient.Do(req)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+						So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+						_, err = GetJSON(baseURL+"/cmd/queue", &queued, nil)
+						So(err, ShouldBeNil)
+						So(queued, ShouldBeEmpty)
+
+						resp, err = http.DefaultClient.Do(req)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+						So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+					})
+				})
+
+				Convey("when client send bulk cmd", func(ctx C) {
+					for name, status := range map[string]SyncStatus{
+						"bulk-a": Failed, "bulk-b": Failed, "bulk-c": Success,
+						"not-on-worker": Failed, "other-mirror": Failed,
+					} {
+						ms := MirrorStatus{Name: name, Worker: w.ID, IsMaster: true, Status: status}
+						resp, err := PostJSON(fmt.Sprintf("%s/workers/%s/jobs/%s", baseURL, w.ID, name), ms, nil)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+					}
+					bulkCmd := BulkCmd{
+						Cmd: CmdRestart,
+						Selector: JobSelector{
+							Names:    []string{"bulk-*", "not-on-worker"},
+							Statuses: []SyncStatus{Failed},
+							Workers:  []string{w.ID},
+						},
+					}
+
+					Convey("select nothing by mistake", func(ctx C) {
+						bulkCmd.Selector = JobSelector{}
+						resp, err := PostJSON(baseURL+"/cmd/bulk", bulkCmd, nil)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+						So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+						bulkCmd.Selector = JobSelector{All: true}
+						bulkCmd.Cmd = CmdReload
+						resp, err = PostJSON(baseURL+"/cmd/bulk", bulkCmd, nil)
+						So(err, ShouldBeNil)
+						resp.Body.Close()
+						So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+					})
+
+					Convey("show the jobs selected", func(ctx C) {
+						bulkCmd.DryRun = true
+						var results []CmdResult
+						resp, err := PostJSON(baseURL+"/cmd/bulk", bulkCmd, nil)
+						So(err, ShouldBeNil)
+						So(resp.StatusCode, ShouldEqual, http.StatusOK)
+						So(json.NewDecoder(resp.Body).Decode(&results), ShouldBeNil)
+						resp.Body.Close()
+						So(results, ShouldHaveLength, 3)
+						So(results[0].MirrorID, ShouldEqual, "bulk-a")
+						So(results[1].MirrorID, ShouldEqual, "bulk-b")
+						So(results[2].MirrorID, ShouldEqual, "not-on-worker")
+						So(len(cmdChan), ShouldEqual, 0)
+					})
+
+					Convey("send it to each job selected", func(ctx C) {
+						received := make(chan []string, 1)
+						go func() {
+							names := []string{(<-cmdChan).MirrorID, (<-cmdChan).MirrorID}
+							sort.Strings(names)
+							received <- names
+						}()
+						var results []CmdResult
+						resp, err := PostJSON(baseURL+"/cmd/bulk", bulkCmd, nil)
+						So(err, ShouldBeNil)
+						So(resp.StatusCode, ShouldEqual, http.StatusOK)
+						So(json.NewDecoder(resp.Body).Decode(&results), ShouldBeNil)
+						resp.Body.Close()
+						So(<-received, ShouldResemble, []string{"bulk-a", "bulk-b"})
+
+						So(results, ShouldHaveLength, 3)
+						So(results[0].Code, ShouldEqual, http.StatusOK)
+						So(results[1].Code, ShouldEqual, http.StatusOK)
+						So(results[2].WorkerID, ShouldEqual, w.ID)
+						So(results[2].Code, ShouldEqual, http.StatusNotFound)
+
+						var records []AuditRecord
+						_, err = GetJSON(baseURL+"/audit?worker="+w.ID+"&action=restart", &records, nil)
+						So(err, ShouldBeNil)
+						So(records, ShouldHaveLength, 3)
+					})
+				})
+
 				Convey("when client send correct cmd", func(ctx C) {
 					clientCmd := ClientCmd{
 						Cmd:      CmdStart,
@@ -416,6 +1318,29 @@ func TestHTTPServer(t *testing.T) {
 					default:
 						ctx.So(0, ShouldEqual, 1)
 					}
+
+					var records []AuditRecord
+					_, err = GetJSON(baseURL+"/audit?worker="+w.ID, &records, nil)
+					So(err, ShouldBeNil)
+					So(records, ShouldHaveLength, 1)
+					So(records[0].Action, ShouldEqual, "start")
+					So(records[0].MirrorID, ShouldEqual, "ubuntu-sync")
+					So(records[0].Code, ShouldEqual, http.StatusOK)
+
+					resp, err = GetJSON(baseURL+"/audit?limit=-1", &records, nil)
+					So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+					// the records out of the retention are pruned as new ones are added
+					s.cfg.Audit.Retention = 1
+					defer func() { s.cfg.Audit.Retention = 0 }()
+					So(s.adapter.AddAuditRecord(AuditRecord{Time: time.Now().AddDate(0, 0, -2),
+						Action: auditSetSize, WorkerID: w.ID}), ShouldBeNil)
+					s.addAudit(AuditRecord{Time: time.Now(), Action: auditFlushDisabled})
+					_, err = GetJSON(baseURL+"/audit", &records, nil)
+					So(err, ShouldBeNil)
+					So(records, ShouldHaveLength, 2)
+					So(records[0].Action, ShouldEqual, auditFlushDisabled)
+					So(records[1].Action, ShouldEqual, "start")
 				})
 			})
 		})
@@ -423,10 +1348,13 @@ func TestHTTPServer(t *testing.T) {
 }
 
 type mockDBAdapter struct {
-	workerStore map[string]WorkerStatus
-	statusStore map[string]MirrorStatus
-	workerLock  sync.RWMutex
-	statusLock  sync.RWMutex
+	workerStore  map[string]WorkerStatus
+	statusStore  map[string]MirrorStatus
+	historyStore map[string][]SyncRecord
+	auditStore   []AuditRecord
+	cmdQueue     []QueuedCmd
+	workerLock   sync.RWMutex
+	statusLock   sync.RWMutex
 }
 
 func (b *mockDBAdapter) Init() error {
@@ -533,6 +1461,130 @@ func (b *mockDBAdapter) ListAllMirrorStatus() ([]MirrorStatus, error) {
 	return mirrorStatusList, nil
 }
 
+func (b *mockDBAdapter) QueryMirrorStatus(filter jobFilter) ([]MirrorStatus, error) {
+	// simulating a database fail
+	if len(filter.Workers) == 1 && filter.Workers[0] == _magicBadWorkerID {
+		return []MirrorStatus{}, fmt.Errorf("database fail")
+	}
+	ms, _ := b.ListAllMirrorStatus()
+	return selectMirrorStatus(ms, filter), nil
+}
+
+func (b *mockDBAdapter) AddSyncRecord(workerID, mirrorID string, record SyncRecord) error {
+	id := mirrorID + "/" + workerID
+	b.statusLock.Lock()
+	defer b.statusLock.Unlock()
+	if b.historyStore == nil {
+		b.historyStore = make(map[string][]SyncRecord)
+	}
+	records := b.historyStore[id]
+	if n := len(records); n > 0 && records[n-1].Started.Equal(record.Started) {
+		records[n-1] = record
+	} else {
+		b.historyStore[id] = append(records, record)
+	}
+	return nil
+}
+
+func (b *mockDBAdapter) ListSyncRecords(workerID, mirrorID string, since, until time.Time, limit int) ([]SyncRecord, error) {
+	id := mirrorID + "/" + workerID
+	var records []SyncRecord
+	b.statusLock.RLock()
+	defer b.statusLock.RUnlock()
+	stored := b.historyStore[id]
+	for i := len(stored) - 1; i >= 0; i-- {
+		r := stored[i]
+		if (!since.IsZero() && r.Started.Before(since)) || (!until.IsZero() && !r.Started.Before(until)) {
+			continue
+		}
+		records = append(records, r)
+		if limit > 0 && len(records) >= limit {
+			break
+		}
+	}
+	return records, nil
+}
+
+func (b *mockDBAdapter) PruneSyncRecords(workerID, mirrorID string, before time.Time) error {
+	id := mirrorID + "/" + workerID
+	b.statusLock.Lock()
+	defer b.statusLock.Unlock()
+	var records []SyncRecord
+	for _, r := range b.historyStore[id] {
+		if !r.Ended.Before(before) {
+			records = append(records, r)
+		}
+	}
+	b.historyStore[id] = records
+	return nil
+}
+
+func (b *mockDBAdapter) AddAuditRecord(r AuditRecord) error {
+	b.statusLock.Lock()
+	defer b.statusLock.Unlock()
+	b.auditStore = append(b.auditStore, r)
+	return nil
+}
+
+func (b *mockDBAdapter) ListAuditRecords(filter auditFilter) ([]AuditRecord, error) {
+	var records []AuditRecord
+	b.statusLock.RLock()
+	defer b.statusLock.RUnlock()
+	for i := len(b.auditStore) - 1; i >= 0; i-- {
+		if r := b.auditStore[i]; filter.match(r) {
+			records = append(records, r)
+			if filter.Limit > 0 && len(records) >= filter.Limit {
+				break
+			}
+		}
+	}
+	return records, nil
+}
+
+func (b *mockDBAdapter) PruneAuditRecords(before time.Time) error {
+	b.statusLock.Lock()
+	defer b.statusLock.Unlock()
+	var records []AuditRecord
+	for _, r := range b.auditStore {
+		if !r.Time.Before(before) {
+			records = append(records, r)
+		}
+	}
+	b.auditStore = records
+	return nil
+}
+
+func (b *mockDBAdapter) AddQueuedCmd(q QueuedCmd) error {
+	b.statusLock.Lock()
+	defer b.statusLock.Unlock()
+	b.cmdQueue = append(b.cmdQueue, q)
+	return nil
+}
+
+func (b *mockDBAdapter) ListQueuedCmds(workerID string) ([]QueuedCmd, error) {
+	var qs []QueuedCmd
+	b.statusLock.RLock()
+	defer b.statusLock.RUnlock()
+	for _, q := range b.cmdQueue {
+		if workerID == "" || q.WorkerID == workerID {
+			qs = append(qs, q)
+		}
+	}
+	return qs, nil
+}
+
+func (b *mockDBAdapter) DeleteQueuedCmd(workerID, id string) error {
+	b.statusLock.Lock()
+	defer b.statusLock.Unlock()
+	for i, q := range b.cmdQueue {
+		if q.WorkerID == workerID && q.ID == id {
+			b.cmdQueue = append(b.cmdQueue[:i], b.cmdQueue[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid queued command %s of worker %s", id, workerID)
+}
+
 func (b *mockDBAdapter) Close() error {
 	return nil
 }
@@ -546,9 +1598,21 @@ func makeMockWorkerServer(cmdChan chan WorkerCmd) *gin.Engine {
 	r.GET("/ping", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{_infoKey: "pong"})
 	})
+	r.GET("/cmd/jobs/:job/log", func(c *gin.Context) {
+		c.Header(LogFileHeader, c.Param("job")+"_2024-05-02_10_00.log")
+		c.String(http.StatusOK, "log of %s, tail %s, authorization %q\n",
+			c.Param("job"), c.Query("tail"), c.GetHeader("Authorization"))
+	})
 	r.POST("/cmd", func(c *gin.Context) {
 		var cmd WorkerCmd
 		c.BindJSON(&cmd)
+		switch cmd.MirrorID {
+		case "not-on-worker":
+			c.JSON(http.StatusNotFound, gin.H{"msg": fmt.Sprintf("Mirror ``%s'' not found", cmd.MirrorID)})
+			return
+		case "slow-mirror":
+			time.Sleep(1500 * time.Millisecond)
+		}
 		cmdChan <- cmd
 	})
 
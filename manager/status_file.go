@@ -0,0 +1,125 @@
+package manager
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// status changes within this delay are written to the status file at once
+const statusFileDelay = time.Second
+
+// statusFileWriter dumps the mirror status to the status file,
+// so that it can be served as a static file
+type statusFileWriter struct {
+	path    string
+	delay   time.Duration
+	changed chan empty
+	list    func() ([]WebMirrorStatus, error)
+	stop    chan empty
+	stopped chan empty
+}
+
+type empty struct{}
+
+func newStatusFileWriter(path string, list func() ([]WebMirrorStatus, error)) *statusFileWriter {
+	return &statusFileWriter{
+		path:    path,
+		delay:   statusFileDelay,
+		changed: make(chan empty, 1),
+		list:    list,
+		stop:    make(chan empty),
+		stopped: make(chan empty),
+	}
+}
+
+// Notify marks the status as changed, without blocking
+func (f *statusFileWriter) Notify() {
+	select {
+	case f.changed <- empty{}:
+	default:
+	}
+}
+
+// Run writes the status file once at start, then again whenever
+// the status changes, until it is stopped
+func (f *statusFileWriter) Run() {
+	defer close(f.stopped)
+	f.write()
+	for {
+		select {
+		case <-f.changed:
+		case <-f.stop:
+			// the last changes are not left behind
+			select {
+			case <-f.changed:
+				f.write()
+			default:
+			}
+			return
+		}
+		select {
+		case <-time.After(f.delay):
+		case <-f.stop:
+		}
+		// changes during the delay are covered by this write
+		select {
+		case <-f.changed:
+		default:
+		}
+		f.write()
+	}
+}
+
+// Stop stops Run after the pending write
+func (f *statusFileWriter) Stop() {
+	close(f.stop)
+	<-f.stopped
+}
+
+func (f *statusFileWriter) write() {
+	status, err := f.list()
+	if err != nil {
+		logger.Errorf("Failed to list mirror status for %s: %s", f.path, err.Error())
+		return
+	}
+	if err := writeFileAtomic(f.path, status); err != nil {
+		logger.Errorf("Failed to write status file %s: %s", f.path, err.Error())
+	}
+}
+
+// writeFileAtomic writes obj as json to a temporary file in the same
+// directory, then renames it to path
+func writeFileAtomic(path string, obj interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
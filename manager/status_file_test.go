@@ -0,0 +1,61 @@
+package manager
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestStatusFile(t *testing.T) {
+	Convey("Status file should be written on changes", t, func() {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+		statusFile := filepath.Join(tmpDir, "status", "tunasync.json")
+
+		var listed int32
+		f := newStatusFileWriter(statusFile, func() ([]WebMirrorStatus, error) {
+			n := atomic.AddInt32(&listed, 1)
+			status := []WebMirrorStatus{
+				BuildWebMirrorStatus(MirrorStatus{Name: "debian", Status: Success}),
+			}
+			if n > 1 {
+				status = append(status,
+					BuildWebMirrorStatus(MirrorStatus{Name: "ubuntu", Status: Syncing}))
+			}
+			return status, nil
+		})
+		f.delay = 100 * time.Millisecond
+		go f.Run()
+
+		readStatus := func() []WebMirrorStatus {
+			var status []WebMirrorStatus
+			data, err := os.ReadFile(statusFile)
+			So(err, ShouldBeNil)
+			So(json.Unmarshal(data, &status), ShouldBeNil)
+			return status
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		So(readStatus(), ShouldHaveLength, 1)
+
+		for i := 0; i < 10; i++ {
+			f.Notify()
+		}
+		time.Sleep(300 * time.Millisecond)
+		So(atomic.LoadInt32(&listed), ShouldEqual, 2)
+		status := readStatus()
+		So(status, ShouldHaveLength, 2)
+		So(status[1].Name, ShouldEqual, "ubuntu")
+
+		files, err := os.ReadDir(filepath.Dir(statusFile))
+		So(err, ShouldBeNil)
+		So(files, ShouldHaveLength, 1)
+	})
+}
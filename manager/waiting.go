@@ -0,0 +1,86 @@
+package manager
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// waitingLists keeps the jobs waiting for a slot, as last reported
+// by each worker. They are not stored, since the workers report
+// them again once they change.
+type waitingLists struct {
+	sync.Mutex
+	lists map[string]WaitingJobs
+}
+
+func (l *waitingLists) update(list WaitingJobs) {
+	l.Lock()
+	defer l.Unlock()
+	if l.lists == nil {
+		l.lists = make(map[string]WaitingJobs)
+	}
+	l.lists[list.Worker] = list
+}
+
+func (l *waitingLists) get(workerID string) (WaitingJobs, bool) {
+	l.Lock()
+	defer l.Unlock()
+	list, ok := l.lists[workerID]
+	return list, ok
+}
+
+func (l *waitingLists) all() []WaitingJobs {
+	l.Lock()
+	defer l.Unlock()
+	lists := make([]WaitingJobs, 0, len(l.lists))
+	for _, list := range l.lists {
+		lists = append(lists, list)
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].Worker < lists[j].Worker
+	})
+	return lists
+}
+
+func (l *waitingLists) forget(workerID string) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.lists, workerID)
+}
+
+// updateWaitingOfWorker keeps the jobs waiting reported by a worker
+func (s *Manager) updateWaitingOfWorker(c *gin.Context) {
+	workerID := c.Param("id")
+	var list WaitingJobs
+	if err := c.BindJSON(&list); err != nil {
+		return
+	}
+	if list.Jobs == nil {
+		list.Jobs = []WaitingJob{}
+	}
+	list.Worker = workerID
+	list.Updated = time.Now()
+	s.waiting.update(list)
+	c.JSON(http.StatusOK, empty{})
+}
+
+// listWaitingOfWorker responds with the jobs waiting on a worker
+func (s *Manager) listWaitingOfWorker(c *gin.Context) {
+	workerID := c.Param("id")
+	list, ok := s.waiting.get(workerID)
+	if !ok {
+		list = WaitingJobs{Worker: workerID, Jobs: []WaitingJob{}}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// listWaitingJobs responds with the jobs waiting on all the workers
+func (s *Manager) listWaitingJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, s.waiting.all())
+}
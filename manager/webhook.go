@@ -0,0 +1,418 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sync"
+	"text/template"
+	"time"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// the events which can be sent to webhooks
+const (
+	// a mirror fails after succeeding
+	webhookFailed = "failed"
+	// a mirror succeeds after failing
+	webhookRecovered = "recovered"
+	// a mirror fails consecutive_failures runs in a row
+	webhookConsecutiveFailures = "consecutive_failures"
+	// a worker is not heard from for the offline timeout
+	webhookWorkerOffline = "worker_offline"
+)
+
+const (
+	webhookQueueSize                  = 128
+	defaultWebhookTimeout             = 10
+	defaultWebhookMaxRetries          = 3
+	defaultWebhookRetryBackoff        = 5
+	defaultWebhookConsecutiveFailures = 3
+	maxWebhookRetryBackoff            = 5 * time.Minute
+)
+
+// A WebhookPayload is what a webhook is told, it is posted as JSON
+// unless a template is configured, which is executed on it
+type WebhookPayload struct {
+	Event               string     `json:"event"`
+	Time                time.Time  `json:"time"`
+	Worker              string     `json:"worker"`
+	Mirror              string     `json:"mirror,omitempty"`
+	Status              SyncStatus `json:"status,omitempty"`
+	PreviousStatus      SyncStatus `json:"previous_status,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures,omitempty"`
+	Upstream            string     `json:"upstream,omitempty"`
+	ErrorMsg            string     `json:"error_msg,omitempty"`
+	LastOnline          *time.Time `json:"last_online,omitempty"`
+}
+
+type webhook struct {
+	cfg      WebhookConfig
+	events   map[string]bool
+	tmpl     *template.Template
+	client   *http.Client
+	retries  int
+	backoff  time.Duration
+	failures int
+	queue    chan WebhookPayload
+}
+
+var webhookFuncs = template.FuncMap{
+	// json quotes a value, for building JSON bodies in templates
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func newWebhook(cfg WebhookConfig) (*webhook, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook without url")
+	}
+	h := &webhook{
+		cfg:      cfg,
+		retries:  cfg.MaxRetries,
+		backoff:  time.Duration(cfg.RetryBackoff) * time.Second,
+		failures: cfg.ConsecutiveFailures,
+		queue:    make(chan WebhookPayload, webhookQueueSize),
+	}
+	if len(cfg.Events) > 0 {
+		h.events = make(map[string]bool)
+		for _, ev := range cfg.Events {
+			switch ev {
+			case webhookFailed, webhookRecovered, webhookConsecutiveFailures, webhookWorkerOffline:
+				h.events[ev] = true
+			default:
+				return nil, fmt.Errorf("webhook %s: unknown event %s", cfg.URL, ev)
+			}
+		}
+	}
+	for _, pattern := range append(cfg.Mirrors, cfg.Workers...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("webhook %s: bad pattern %s", cfg.URL, pattern)
+		}
+	}
+	if cfg.Template != "" {
+		tmpl, err := template.New(cfg.URL).Funcs(webhookFuncs).Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: %s", cfg.URL, err.Error())
+		}
+		h.tmpl = tmpl
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	h.client = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	if h.retries == 0 {
+		h.retries = defaultWebhookMaxRetries
+	} else if h.retries < 0 {
+		h.retries = 0
+	}
+	if h.backoff <= 0 {
+		h.backoff = defaultWebhookRetryBackoff * time.Second
+	}
+	if h.failures <= 0 {
+		h.failures = defaultWebhookConsecutiveFailures
+	}
+	return h, nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// wants tells whether the webhook subscribes to p
+func (h *webhook) wants(p WebhookPayload) bool {
+	if h.events != nil && !h.events[p.Event] {
+		return false
+	}
+	if p.Event == webhookConsecutiveFailures && p.ConsecutiveFailures != h.failures {
+		return false
+	}
+	if !matchAny(h.cfg.Workers, p.Worker) {
+		return false
+	}
+	// worker events concern all the mirrors
+	if p.Mirror != "" && !matchAny(h.cfg.Mirrors, p.Mirror) {
+		return false
+	}
+	return true
+}
+
+func (h *webhook) render(p WebhookPayload) ([]byte, error) {
+	if h.tmpl == nil {
+		return json.Marshal(p)
+	}
+	var buf bytes.Buffer
+	if err := h.tmpl.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends the body once, the error tells whether to retry
+func (h *webhook) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	contentType := h.cfg.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range h.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("status code %d", resp.StatusCode)
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
+
+// deliver posts p, retrying with an exponential backoff
+func (h *webhook) deliver(p WebhookPayload) {
+	body, err := h.render(p)
+	if err != nil {
+		logger.Errorf("Failed to render webhook %s: %s", h.cfg.URL, err.Error())
+		return
+	}
+	backoff := h.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := h.post(body)
+		if err == nil {
+			logger.Debugf("Sent %s event of %s to webhook %s", p.Event, p.Worker, h.cfg.URL)
+			return
+		}
+		if !retry || attempt >= h.retries {
+			logger.Errorf("Failed to send %s event to webhook %s: %s", p.Event, h.cfg.URL, err.Error())
+			return
+		}
+		logger.Warningf("Failed to send %s event to webhook %s: %s, retry in %s",
+			p.Event, h.cfg.URL, err.Error(), backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxWebhookRetryBackoff {
+			backoff = maxWebhookRetryBackoff
+		}
+	}
+}
+
+func (h *webhook) run() {
+	for p := range h.queue {
+		h.deliver(p)
+	}
+}
+
+// a lastResult is the last reported result of a mirror
+type lastResult struct {
+	status  SyncStatus
+	started time.Time
+}
+
+// webhookNotifier detects the transitions worth notifying and
+// queues them to the webhooks, each of which is delivered in order
+type webhookNotifier struct {
+	sync.Mutex
+	hooks   []*webhook
+	results map[string]lastResult
+	stopped bool
+	running sync.WaitGroup
+}
+
+func newWebhookNotifier(cfgs []WebhookConfig) (*webhookNotifier, error) {
+	n := &webhookNotifier{results: make(map[string]lastResult)}
+	for _, cfg := range cfgs {
+		h, err := newWebhook(cfg)
+		if err != nil {
+			return nil, err
+		}
+		n.hooks = append(n.hooks, h)
+	}
+	return n, nil
+}
+
+// Run delivers the queued notifications
+func (n *webhookNotifier) Run() {
+	for _, h := range n.hooks {
+		n.running.Add(1)
+		go func(h *webhook) {
+			defer n.running.Done()
+			h.run()
+		}(h)
+	}
+}
+
+// Stop drops the later notifications, the queued ones are still
+// delivered, and done is closed once they are
+func (n *webhookNotifier) Stop() (done <-chan empty) {
+	n.Lock()
+	if !n.stopped {
+		n.stopped = true
+		for _, h := range n.hooks {
+			close(h.queue)
+		}
+	}
+	n.Unlock()
+	ch := make(chan empty)
+	go func() {
+		n.running.Wait()
+		close(ch)
+	}()
+	return ch
+}
+
+// takeOver carries on the results known to old, which is replaced by n
+func (n *webhookNotifier) takeOver(old *webhookNotifier) {
+	old.Lock()
+	defer old.Unlock()
+	n.Lock()
+	defer n.Unlock()
+	for k, r := range old.results {
+		n.results[k] = r
+	}
+}
+
+func (n *webhookNotifier) notify(p WebhookPayload) {
+	if p.Time.IsZero() {
+		p.Time = time.Now()
+	}
+	n.Lock()
+	defer n.Unlock()
+	if n.stopped {
+		return
+	}
+	for _, h := range n.hooks {
+		if !h.wants(p) {
+			continue
+		}
+		select {
+		case h.queue <- p:
+		default:
+			logger.Warningf("Webhook %s is too slow, dropped %s event of %s",
+				h.cfg.URL, p.Event, p.Worker)
+		}
+	}
+}
+
+// maxFailures is the largest number of consecutive failures
+// some webhook is waiting for
+func (n *webhookNotifier) maxFailures() int {
+	max := 0
+	for _, h := range n.hooks {
+		if h.failures > max {
+			max = h.failures
+		}
+	}
+	return max
+}
+
+// jobResult compares the result of a sync run with the previous ones,
+// records holds the runs of the mirror up to the current one, newest first
+func (n *webhookNotifier) jobResult(status MirrorStatus, records []SyncRecord) {
+	if len(records) == 0 {
+		return
+	}
+	cur := records[0]
+
+	n.Lock()
+	key := status.Name + "/" + status.Worker
+	last, known := n.results[key]
+	if !known && len(records) > 1 {
+		// the manager has restarted, resume from the history
+		last = lastResult{records[1].Status, records[1].Started}
+	}
+	n.results[key] = lastResult{cur.Status, cur.Started}
+	n.Unlock()
+
+	// the failed attempts of a run are reported each,
+	// and only the first one is notified
+	if last.status == cur.Status {
+		if cur.Status != Failed || last.started.Equal(cur.Started) {
+			return
+		}
+	}
+
+	p := WebhookPayload{
+		Worker:         status.Worker,
+		Mirror:         status.Name,
+		Status:         cur.Status,
+		PreviousStatus: last.status,
+		Upstream:       status.Upstream,
+		ErrorMsg:       status.ErrorMsg,
+		Time:           cur.Ended,
+	}
+	switch {
+	case last.status == Success && cur.Status == Failed:
+		p.Event = webhookFailed
+		n.notify(p)
+	case last.status == Failed && cur.Status == Success:
+		p.Event = webhookRecovered
+		n.notify(p)
+	}
+
+	if cur.Status == Failed {
+		failures := 0
+		for _, r := range records {
+			if r.Status != Failed {
+				break
+			}
+			failures++
+		}
+		p.Event = webhookConsecutiveFailures
+		p.ConsecutiveFailures = failures
+		n.notify(p)
+	}
+}
+
+// notifyJobResult tells the webhooks about the end of a sync run
+func (s *Manager) notifyJobResult(workerID string, status MirrorStatus) {
+	webhooks := s.notifier()
+	if webhooks == nil {
+		return
+	}
+	s.rwmu.RLock()
+	records, err := s.adapter.ListSyncRecords(workerID, status.Name, time.Time{}, time.Time{}, webhooks.maxFailures()+1)
+	s.rwmu.RUnlock()
+	if err != nil {
+		logger.Errorf("Failed to list sync records of job %s of worker %s: %s",
+			status.Name, workerID, err.Error())
+		return
+	}
+	webhooks.jobResult(status, records)
+}
+
+// notifyWorkerOffline tells the webhooks that w has gone offline
+func (s *Manager) notifyWorkerOffline(w WorkerStatus) {
+	webhooks := s.notifier()
+	if webhooks == nil {
+		return
+	}
+	lastOnline := w.LastOnline
+	webhooks.notify(WebhookPayload{
+		Event:      webhookWorkerOffline,
+		Worker:     w.ID,
+		LastOnline: &lastOnline,
+	})
+}
@@ -0,0 +1,157 @@
+package manager
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestWebhook(t *testing.T) {
+	Convey("Webhooks should be notified of transitions", t, func() {
+		received := make(chan []byte, 16)
+		var failFirst int32 = 1
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			if r.URL.Path == "/flaky" && atomic.CompareAndSwapInt32(&failFirst, 1, 0) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			received <- append([]byte(r.URL.Path+" "), body...)
+		}))
+		defer ts.Close()
+
+		n, err := newWebhookNotifier([]WebhookConfig{
+			{URL: ts.URL + "/all", ConsecutiveFailures: 2},
+			{
+				URL:      ts.URL + "/flaky",
+				Events:   []string{webhookRecovered},
+				Mirrors:  []string{"debian*"},
+				Template: `{"text": {{printf "%s is back on %s" .Mirror .Worker | json}}}`,
+			},
+		})
+		So(err, ShouldBeNil)
+		n.hooks[1].backoff = 10 * time.Millisecond
+		n.Run()
+
+		next := func() (path string, p WebhookPayload) {
+			select {
+			case b := <-received:
+				var i int
+				for i = 0; b[i] != ' '; i++ {
+				}
+				path = string(b[:i])
+				if path == "/all" {
+					So(json.Unmarshal(b[i+1:], &p), ShouldBeNil)
+				} else {
+					p.Event = string(b[i+1:])
+				}
+			case <-time.After(time.Second):
+				path = "timeout"
+			}
+			return
+		}
+		nothing := func() {
+			select {
+			case b := <-received:
+				So(string(b), ShouldBeEmpty)
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+
+		now := time.Now()
+		var records []SyncRecord
+		run := func(mirror string, status SyncStatus, newRun bool) {
+			if newRun {
+				now = now.Add(time.Hour)
+			}
+			r := SyncRecord{Name: mirror, Worker: "worker1", Status: status, Started: now, Ended: now}
+			if !newRun {
+				records = records[1:]
+			}
+			records = append([]SyncRecord{r}, records...)
+			n.jobResult(MirrorStatus{Name: mirror, Worker: "worker1", Status: status}, records)
+		}
+
+		run("debian", Success, true)
+		nothing()
+
+		run("debian", Failed, true)
+		path, p := next()
+		So(path, ShouldEqual, "/all")
+		So(p.Event, ShouldEqual, webhookFailed)
+		So(p.Mirror, ShouldEqual, "debian")
+		So(p.PreviousStatus, ShouldEqual, Success)
+		So(p.Status, ShouldEqual, Failed)
+
+		// another failed attempt of the same run
+		run("debian", Failed, false)
+		nothing()
+
+		run("debian", Failed, true)
+		path, p = next()
+		So(path, ShouldEqual, "/all")
+		So(p.Event, ShouldEqual, webhookConsecutiveFailures)
+		So(p.ConsecutiveFailures, ShouldEqual, 2)
+
+		run("debian", Failed, true)
+		nothing()
+
+		run("debian", Success, true)
+		got := map[string]string{}
+		for i := 0; i < 2; i++ {
+			path, p := next()
+			got[path] = p.Event
+		}
+		So(got["/all"], ShouldEqual, webhookRecovered)
+		// delivered after a retry
+		So(got["/flaky"], ShouldEqual, `{"text": "debian is back on worker1"}`)
+
+		Convey("filter the mirrors", func() {
+			records = nil
+			run("ubuntu", Failed, true)
+			run("ubuntu", Success, true)
+			path, p := next()
+			So(path, ShouldEqual, "/all")
+			So(p.Event, ShouldEqual, webhookRecovered)
+			nothing()
+		})
+
+		Convey("resume from the history", func() {
+			n.results = make(map[string]lastResult)
+			run("debian", Failed, true)
+			path, p := next()
+			So(path, ShouldEqual, "/all")
+			So(p.Event, ShouldEqual, webhookFailed)
+		})
+
+		Convey("notify the workers going offline", func() {
+			s := &Manager{webhooks: n}
+			lastOnline := time.Now().Add(-time.Hour)
+			s.notifyWorkerOffline(WorkerStatus{ID: "worker2", LastOnline: lastOnline})
+			path, p := next()
+			So(path, ShouldEqual, "/all")
+			So(p.Event, ShouldEqual, webhookWorkerOffline)
+			So(p.Worker, ShouldEqual, "worker2")
+			So(p.LastOnline.Equal(lastOnline), ShouldBeTrue)
+			nothing()
+		})
+	})
+
+	Convey("Invalid webhooks should be rejected", t, func() {
+		_, err := newWebhookNotifier([]WebhookConfig{{URL: ""}})
+		So(err, ShouldNotBeNil)
+		_, err = newWebhookNotifier([]WebhookConfig{{URL: "http://x", Events: []string{"exploded"}}})
+		So(err, ShouldNotBeNil)
+		_, err = newWebhookNotifier([]WebhookConfig{{URL: "http://x", Template: "{{.Mirror"}})
+		So(err, ShouldNotBeNil)
+		_, err = newWebhookNotifier([]WebhookConfig{{URL: "http://x", Mirrors: []string{"[a-"}}})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,229 @@
+package worker
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	. "github.com/tuna/tunasync/internal"
+)
+
+// admissionQueue admits at most limit jobs to sync at once, and the
+// jobs of a concurrency group at most the limit of the group. The jobs
+// waiting for slots are admitted by their priority, and the ones
+// overdue for the longest first within a priority.
+type admissionQueue struct {
+	sync.Mutex
+	limit   int
+	running int
+	// the limits of the groups declared, and how many jobs of each
+	// group are admitted
+	groupLimits  map[string]int
+	groupRunning map[string]int
+	waiting      []*admissionTicket
+	// signaled when the jobs waiting or running change
+	changed chan empty
+}
+
+// an admissionTicket is a job waiting for slots, admitted once
+// the channel is closed
+type admissionTicket struct {
+	WaitingJob
+	admitted chan empty
+}
+
+func newAdmissionQueue(limit int) *admissionQueue {
+	return &admissionQueue{
+		limit:        limit,
+		groupLimits:  make(map[string]int),
+		groupRunning: make(map[string]int),
+		changed:      make(chan empty, 1),
+	}
+}
+
+// setGroupLimits sets the limits of the concurrency groups, the jobs
+// of the groups not limited only need a global slot
+func (q *admissionQueue) setGroupLimits(limits map[string]int) {
+	q.Lock()
+	defer q.Unlock()
+	q.groupLimits = limits
+	q.admit()
+	q.notify()
+}
+
+// wait queues the job for slots, the job is due since the time given
+func (q *admissionQueue) wait(name, group string, priority int, due time.Time) *admissionTicket {
+	now := time.Now()
+	if due.IsZero() || due.After(now) {
+		due = now
+	}
+	t := &admissionTicket{
+		WaitingJob: WaitingJob{Name: name, Group: group, Priority: priority, Due: due, Since: now},
+		admitted:   make(chan empty),
+	}
+	q.Lock()
+	defer q.Unlock()
+	q.waiting = append(q.waiting, t)
+	sort.SliceStable(q.waiting, func(i, j int) bool {
+		a, b := q.waiting[i], q.waiting[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return a.Due.Before(b.Due)
+	})
+	q.admit()
+	q.notify()
+	return t
+}
+
+// cancel stops the job waiting, and frees the slots if it is
+// admitted meanwhile
+func (q *admissionQueue) cancel(t *admissionTicket) {
+	q.Lock()
+	defer q.Unlock()
+	for i, waiting := range q.waiting {
+		if waiting == t {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			q.notify()
+			return
+		}
+	}
+	q.free(t)
+}
+
+// release frees the slots of a job admitted
+func (q *admissionQueue) release(t *admissionTicket) {
+	q.Lock()
+	defer q.Unlock()
+	q.free(t)
+}
+
+func (q *admissionQueue) free(t *admissionTicket) {
+	q.running--
+	if t.Group != "" {
+		q.groupRunning[t.Group]--
+	}
+	q.admit()
+	q.notify()
+}
+
+// admit lets the jobs waiting run while there are free global slots,
+// a job whose group is full is passed over for the ones after it
+func (q *admissionQueue) admit() {
+	for i := 0; i < len(q.waiting) && q.running < q.limit; {
+		t := q.waiting[i]
+		if limit, ok := q.groupLimits[t.Group]; ok && q.groupRunning[t.Group] >= limit {
+			i++
+			continue
+		}
+		q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+		q.running++
+		if t.Group != "" {
+			q.groupRunning[t.Group]++
+		}
+		close(t.admitted)
+	}
+}
+
+// notify signals the change of the jobs waiting or running
+func (q *admissionQueue) notify() {
+	select {
+	case q.changed <- empty{}:
+	default:
+	}
+}
+
+// Running returns how many jobs are admitted
+func (q *admissionQueue) Running() int {
+	q.Lock()
+	defer q.Unlock()
+	return q.running
+}
+
+// Limit returns how many jobs can be admitted at once
+func (q *admissionQueue) Limit() int {
+	return q.limit
+}
+
+// Groups returns the slots of the concurrency groups declared
+func (q *admissionQueue) Groups() []ConcurrencyGroup {
+	q.Lock()
+	defer q.Unlock()
+	groups := make([]ConcurrencyGroup, 0, len(q.groupLimits))
+	for name, limit := range q.groupLimits {
+		groups = append(groups, ConcurrencyGroup{
+			Name: name, Running: q.groupRunning[name], Limit: limit,
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// Waiting returns the jobs waiting, in the order of precedence
+func (q *admissionQueue) Waiting() []WaitingJob {
+	q.Lock()
+	defer q.Unlock()
+	jobs := make([]WaitingJob, len(q.waiting))
+	for i, t := range q.waiting {
+		jobs[i] = t.WaitingJob
+	}
+	return jobs
+}
+
+// how long the changes of the jobs waiting settle before reported
+const waitingReportDelay = time.Second
+
+// waitingJobs returns the jobs waiting for a slot on the worker
+func (w *Worker) waitingJobs() WaitingJobs {
+	return WaitingJobs{
+		Worker:  w.Name(),
+		Running: w.admission.Running(),
+		Limit:   w.admission.Limit(),
+		Groups:  w.admission.Groups(),
+		Jobs:    w.admission.Waiting(),
+	}
+}
+
+// listWaitingJobs responds with the jobs waiting for a slot
+func (w *Worker) listWaitingJobs(c *gin.Context) {
+	if !w.verifyRequest(c, nil) {
+		return
+	}
+	c.JSON(http.StatusOK, w.waitingJobs())
+}
+
+// runWaitingReporter reports the jobs waiting to the managers
+// whenever they change
+func (w *Worker) runWaitingReporter() {
+	for {
+		select {
+		case <-w.admission.changed:
+			// a burst of changes, e.g. many jobs due at once, is
+			// reported once
+			select {
+			case <-time.After(waitingReportDelay):
+			case <-w.exit:
+				return
+			}
+			w.reportWaitingJobs()
+		case <-w.exit:
+			return
+		}
+	}
+}
+
+func (w *Worker) reportWaitingJobs() {
+	msg := w.waitingJobs()
+	for _, root := range w.cfg.Manager.APIBaseList() {
+		url := fmt.Sprintf("%s/workers/%s/waiting", root, w.Name())
+		if err := w.postReport(root, url, msg, "waiting"); err != nil {
+			logger.Errorf("Failed to report the jobs waiting to %s: %s", root, err.Error())
+		}
+	}
+}
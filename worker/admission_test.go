@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestAdmissionQueue(t *testing.T) {
+	admitted := func(t *admissionTicket) bool {
+		select {
+		case <-t.admitted:
+			return true
+		default:
+			return false
+		}
+	}
+	names := func(jobs []WaitingJob) (ns []string) {
+		for _, job := range jobs {
+			ns = append(ns, job.Name)
+		}
+		return
+	}
+
+	Convey("Jobs should be admitted by priority and overdue time", t, func() {
+		q := newAdmissionQueue(1)
+		now := time.Now()
+
+		a := q.wait("a", "", 0, time.Time{})
+		So(admitted(a), ShouldBeTrue)
+		b := q.wait("b", "", 0, now.Add(-time.Hour))
+		c := q.wait("c", "", 0, now.Add(-10*time.Minute))
+		d := q.wait("d", "", 5, now)
+		e := q.wait("e", "", 0, now.Add(-10*time.Minute))
+		So(admitted(b) || admitted(c) || admitted(d) || admitted(e), ShouldBeFalse)
+		So(q.Running(), ShouldEqual, 1)
+		So(names(q.Waiting()), ShouldResemble, []string{"d", "b", "c", "e"})
+
+		q.release(a)
+		So(admitted(d), ShouldBeTrue)
+		So(names(q.Waiting()), ShouldResemble, []string{"b", "c", "e"})
+
+		q.cancel(c)
+		So(names(q.Waiting()), ShouldResemble, []string{"b", "e"})
+		q.release(d)
+		So(admitted(b), ShouldBeTrue)
+
+		// the slot of a job admitted while canceled is freed
+		q.cancel(b)
+		So(admitted(e), ShouldBeTrue)
+		So(q.Waiting(), ShouldBeEmpty)
+		q.release(e)
+		So(q.Running(), ShouldEqual, 0)
+	})
+
+	Convey("Jobs due later should wait as if due now", t, func() {
+		q := newAdmissionQueue(0)
+		ticket := q.wait("a", "", 0, time.Now().Add(time.Hour))
+		So(ticket.Due.After(time.Now()), ShouldBeFalse)
+		So(ticket.Since.IsZero(), ShouldBeFalse)
+	})
+
+	Convey("Jobs should hold both a global slot and a group slot", t, func() {
+		q := newAdmissionQueue(3)
+		q.setGroupLimits(map[string]int{"rsync.example.org": 2})
+
+		a := q.wait("a", "rsync.example.org", 0, time.Time{})
+		b := q.wait("b", "rsync.example.org", 0, time.Time{})
+		c := q.wait("c", "rsync.example.org", 0, time.Time{})
+		// the job of a full group does not block the others
+		d := q.wait("d", "", 0, time.Time{})
+		So(admitted(a) && admitted(b) && admitted(d), ShouldBeTrue)
+		So(admitted(c), ShouldBeFalse)
+		So(q.Groups(), ShouldResemble, []ConcurrencyGroup{
+			{Name: "rsync.example.org", Running: 2, Limit: 2},
+		})
+
+		e := q.wait("e", "rsync.example.org", 10, time.Time{})
+		So(names(q.Waiting()), ShouldResemble, []string{"e", "c"})
+		q.release(d)
+		So(admitted(e), ShouldBeFalse)
+		So(q.Running(), ShouldEqual, 2)
+
+		q.release(a)
+		So(admitted(e), ShouldBeTrue)
+		So(names(q.Waiting()), ShouldResemble, []string{"c"})
+
+		q.setGroupLimits(map[string]int{"rsync.example.org": 3})
+		So(admitted(c), ShouldBeTrue)
+		So(q.Running(), ShouldEqual, 3)
+	})
+
+	Convey("The changes of the jobs waiting should be signaled", t, func() {
+		q := newAdmissionQueue(1)
+		a := q.wait("a", "", 0, time.Time{})
+		<-q.changed
+		b := q.wait("b", "", 0, time.Time{})
+		<-q.changed
+		q.cancel(b)
+		<-q.changed
+		q.release(a)
+		<-q.changed
+		So(q.Running(), ShouldEqual, 0)
+	})
+
+	Convey("The jobs waiting should be listed by the worker", t, func() {
+		cfg := &Config{}
+		cfg.Global.Name = "dut"
+		w := &Worker{
+			cfg:       cfg,
+			jobs:      make(map[string]*mirrorJob),
+			admission: newAdmissionQueue(1),
+			tokens:    make(map[string]string),
+		}
+		w.makeHTTPServer()
+		w.admission.wait("a", "", 0, time.Time{})
+		w.admission.wait("b", "", 1, time.Time{})
+
+		rec := httptest.NewRecorder()
+		w.httpEngine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/waiting", nil))
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		var list WaitingJobs
+		So(json.Unmarshal(rec.Body.Bytes(), &list), ShouldBeNil)
+		So(list.Worker, ShouldEqual, "dut")
+		So(list.Running, ShouldEqual, 1)
+		So(list.Limit, ShouldEqual, 1)
+		So(list.Jobs, ShouldHaveLength, 1)
+		So(list.Jobs[0].Name, ShouldEqual, "b")
+		So(list.Jobs[0].Priority, ShouldEqual, 1)
+
+		// signed by the managers once they issue the tokens
+		w.tokens["http://manager"] = "token"
+		rec = httptest.NewRecorder()
+		w.httpEngine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/waiting", nil))
+		So(rec.Code, ShouldEqual, http.StatusUnauthorized)
+	})
+}
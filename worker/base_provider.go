@@ -15,6 +15,10 @@ type baseProvider struct {
 	ctx      *Context
 	name     string
 	interval time.Duration
+	schedule *cronSchedule
+	windows  *syncWindows
+	priority int
+	group    string
 	retry    int
 	timeout  time.Duration
 	isMaster bool
@@ -54,6 +58,38 @@ func (p *baseProvider) Interval() time.Duration {
 	return p.interval
 }
 
+func (p *baseProvider) Schedule() *cronSchedule {
+	return p.schedule
+}
+
+func (p *baseProvider) SetSchedule(s *cronSchedule) {
+	p.schedule = s
+}
+
+func (p *baseProvider) Windows() *syncWindows {
+	return p.windows
+}
+
+func (p *baseProvider) SetWindows(sw *syncWindows) {
+	p.windows = sw
+}
+
+func (p *baseProvider) Priority() int {
+	return p.priority
+}
+
+func (p *baseProvider) SetPriority(priority int) {
+	p.priority = priority
+}
+
+func (p *baseProvider) ConcurrencyGroup() string {
+	return p.group
+}
+
+func (p *baseProvider) SetConcurrencyGroup(group string) {
+	p.group = group
+}
+
 func (p *baseProvider) Retry() int {
 	return p.retry
 }
@@ -3,6 +3,8 @@ package worker
 // put global variables and types here
 
 import (
+	"time"
+
 	tunasync "github.com/tuna/tunasync/internal"
 )
 
@@ -10,4 +12,20 @@ type empty struct{}
 
 const defaultMaxRetry = 2
 
+// commands signed earlier than this are rejected
+const cmdSignatureMaxAge = 5 * time.Minute
+
+// how often the worker tells the managers it is alive
+const heartbeatInterval = time.Minute
+
+// how often a worker failing to register tries again in the background
+const registerRetryInterval = 30 * time.Second
+
+// how long a poll for the commands waits on the manager, and how
+// long to wait before polling again after a failure
+const (
+	cmdPollWait       = 30 * time.Second
+	cmdPollRetryDelay = 5 * time.Second
+)
+
 var logger = tunasync.MustGetLogger("tunasync")
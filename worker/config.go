@@ -2,8 +2,11 @@ package worker
 
 import (
 	"errors"
+	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"dario.cat/mergo"
 	"github.com/BurntSushi/toml"
@@ -47,6 +50,14 @@ type Config struct {
 	Include       includeConfig       `toml:"include"`
 	MirrorsConf   []mirrorConfig      `toml:"mirrors"`
 	Mirrors       []mirrorConfig
+
+	// the jobs of a group hold a slot of the group besides a global one
+	ConcurrencyGroups []concurrencyGroupConfig `toml:"concurrency_groups"`
+}
+
+type concurrencyGroupConfig struct {
+	Name       string `toml:"name"`
+	Concurrent int    `toml:"concurrent"`
 }
 
 type globalConfig struct {
@@ -58,6 +69,15 @@ type globalConfig struct {
 	Retry      int    `toml:"retry"`
 	Timeout    int    `toml:"timeout"`
 
+	// the cron expression like "0 */6 * * *" to sync on instead of the
+	// interval, in the timezone like "Asia/Shanghai", the local one by default
+	Schedule string `toml:"schedule"`
+	Timezone string `toml:"timezone"`
+	// the jobs start only within the windows like "01:00-07:00" if any,
+	// but never during the blackouts like "Sat 02:00-04:00"
+	SyncWindows []string `toml:"sync_windows"`
+	Blackouts   []string `toml:"blackouts"`
+
 	// appended to the options generated by rsync_provider, but before mirror-specific options
 	RsyncOptions []string `toml:"rsync_options"`
 
@@ -75,7 +95,11 @@ type managerConfig struct {
 	// this option overrides the APIBase
 	APIList []string `toml:"api_base_list"`
 	CACert  string   `toml:"ca_cert"`
-	// Token   string `toml:"token"`
+	// the secret to register on the manager
+	Token string `toml:"token"`
+	// poll the manager for the commands, for the workers the
+	// manager cannot connect to
+	PullCommands bool `toml:"pull_commands"`
 }
 
 func (mc managerConfig) APIBaseList() []string {
@@ -158,6 +182,11 @@ type mirrorConfig struct {
 	Provider     providerEnum      `toml:"provider"`
 	Upstream     string            `toml:"upstream"`
 	Interval     int               `toml:"interval"`
+	Schedule     string            `toml:"schedule"`
+	Timezone     string            `toml:"timezone"`
+	SyncWindows  []string          `toml:"sync_windows"`
+	Blackouts    []string          `toml:"blackouts"`
+	Priority     int               `toml:"priority"`
 	Retry        int               `toml:"retry"`
 	Timeout      int               `toml:"timeout"`
 	MirrorDir    string            `toml:"mirror_dir"`
@@ -166,6 +195,9 @@ type mirrorConfig struct {
 	Env          map[string]string `toml:"env"`
 	Role         string            `toml:"role"`
 
+	// the concurrency group of the mirror, the upstream host by default
+	ConcurrencyGroup string `toml:"concurrency_group"`
+
 	// These two options over-write the global options
 	ExecOnSuccess []string `toml:"exec_on_success"`
 	ExecOnFailure []string `toml:"exec_on_failure"`
@@ -239,9 +271,115 @@ func LoadConfig(cfgFile string) (*Config, error) {
 		}
 	}
 
+	if _, err := cfg.concurrencyGroupLimits(); err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	for _, m := range cfg.Mirrors {
+		_, err := mirrorSchedule(m, cfg)
+		if err == nil {
+			_, err = mirrorWindows(m, cfg)
+		}
+		if err == nil {
+			_, err = mirrorConcurrencyGroup(m, cfg)
+		}
+		if err != nil {
+			err := fmt.Errorf("mirror %s: %s", m.Name, err.Error())
+			logger.Error(err.Error())
+			return nil, err
+		}
+	}
+
 	return cfg, nil
 }
 
+// mirrorSchedule returns the cron schedule of the mirror, nil if it
+// is synced on the interval. The schedule of the mirror comes first,
+// then the interval of the mirror, then the global schedule.
+func mirrorSchedule(mirror mirrorConfig, cfg *Config) (*cronSchedule, error) {
+	spec := mirror.Schedule
+	if spec == "" && mirror.Interval == 0 {
+		spec = cfg.Global.Schedule
+	}
+	if spec == "" {
+		return nil, nil
+	}
+	return parseCronSchedule(spec, mirrorTimezone(mirror, cfg))
+}
+
+// mirrorWindows returns the sync windows of the mirror, nil if it may
+// start at any time. The windows of the mirror override the global
+// ones, while the global blackouts apply to the mirror as well.
+func mirrorWindows(mirror mirrorConfig, cfg *Config) (*syncWindows, error) {
+	windows := mirror.SyncWindows
+	if len(windows) == 0 {
+		windows = cfg.Global.SyncWindows
+	}
+	blackouts := append(append([]string{}, cfg.Global.Blackouts...), mirror.Blackouts...)
+	loc, err := loadTimezone(mirrorTimezone(mirror, cfg))
+	if err != nil {
+		return nil, err
+	}
+	return newSyncWindows(windows, blackouts, loc)
+}
+
+// concurrencyGroupLimits returns the concurrent limits of the groups
+// by their names
+func (cfg *Config) concurrencyGroupLimits() (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, g := range cfg.ConcurrencyGroups {
+		if g.Name == "" {
+			return nil, errors.New("concurrency group without name")
+		}
+		if _, ok := limits[g.Name]; ok {
+			return nil, fmt.Errorf("duplicated concurrency group %s", g.Name)
+		}
+		if g.Concurrent < 1 {
+			return nil, fmt.Errorf("invalid concurrent of concurrency group %s: %d", g.Name, g.Concurrent)
+		}
+		limits[g.Name] = g.Concurrent
+	}
+	return limits, nil
+}
+
+// mirrorConcurrencyGroup returns the concurrency group of the mirror,
+// the host of its upstream unless it is given. A group given must be
+// declared, while the jobs of a host undeclared are not limited.
+func mirrorConcurrencyGroup(mirror mirrorConfig, cfg *Config) (string, error) {
+	if mirror.ConcurrencyGroup == "" {
+		return upstreamHost(mirror.Upstream), nil
+	}
+	for _, g := range cfg.ConcurrencyGroups {
+		if g.Name == mirror.ConcurrencyGroup {
+			return g.Name, nil
+		}
+	}
+	return "", fmt.Errorf("undeclared concurrency group %s", mirror.ConcurrencyGroup)
+}
+
+// upstreamHost returns the host of an upstream URL, or of the rsync
+// daemon like "user@host::module", empty if there is no host
+func upstreamHost(upstream string) string {
+	if u, err := url.Parse(upstream); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	if host, _, ok := strings.Cut(upstream, "::"); ok {
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
+		return host
+	}
+	return ""
+}
+
+func mirrorTimezone(mirror mirrorConfig, cfg *Config) string {
+	if mirror.Timezone != "" {
+		return mirror.Timezone
+	}
+	return cfg.Global.Timezone
+}
+
 func recursiveMirrors(cfg *Config, parent *mirrorConfig, mirror mirrorConfig) error {
 	var curMir mirrorConfig
 	if parent != nil {
@@ -703,4 +703,183 @@ rsync_success_exit_codes = [24, 25, 24]
 		So(ok, ShouldBeTrue)
 		So(rp.successExitCodes, ShouldResemble, []int{10, 20, 30, 23, 24, 25})
 	})
+
+	Convey("cron schedules should work", t, func() {
+		tmpfile, err := os.CreateTemp("", "tunasync")
+		So(err, ShouldEqual, nil)
+		defer os.Remove(tmpfile.Name())
+
+		cfgBlob1 := `
+[global]
+name = "test_worker"
+log_dir = "/var/log/tunasync/{{.Name}}"
+mirror_dir = "/data/mirrors"
+concurrent = 10
+interval = 240
+retry = 3
+timeout = 86400
+schedule = "0 */6 * * *"
+timezone = "Asia/Shanghai"
+
+[manager]
+api_base = "https://127.0.0.1:5000"
+
+[server]
+hostname = "worker1.example.com"
+listen_addr = "127.0.0.1"
+listen_port = 6000
+
+[[mirrors]]
+name = "global"
+provider = "command"
+command = "true"
+
+[[mirrors]]
+name = "debian"
+provider = "command"
+command = "true"
+schedule = "30 3,9,15,21 * * *"
+timezone = "UTC"
+priority = 10
+
+[[mirrors]]
+name = "interval"
+provider = "command"
+command = "true"
+interval = 60
+`
+
+		err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob1), 0644)
+		So(err, ShouldEqual, nil)
+		defer tmpfile.Close()
+
+		cfg, err := LoadConfig(tmpfile.Name())
+		So(err, ShouldBeNil)
+
+		providers := map[string]mirrorProvider{}
+		for _, m := range cfg.Mirrors {
+			p := newMirrorProvider(m, cfg)
+			providers[p.Name()] = p
+		}
+
+		last := time.Date(2024, 5, 1, 12, 10, 0, 0, time.UTC)
+		p := providers["global"]
+		So(p.Schedule(), ShouldNotBeNil)
+		// 20:10 in Shanghai
+		So(nextSchedule(p, last).Equal(time.Date(2024, 5, 1, 16, 0, 0, 0, time.UTC)), ShouldBeTrue)
+		So(syncInterval(p), ShouldEqual, 6*time.Hour)
+
+		p = providers["debian"]
+		So(p.Schedule().String(), ShouldEqual, "30 3,9,15,21 * * * (UTC)")
+		So(nextSchedule(p, last).Equal(time.Date(2024, 5, 1, 15, 30, 0, 0, time.UTC)), ShouldBeTrue)
+		So(p.Priority(), ShouldEqual, 10)
+		So(providers["global"].Priority(), ShouldEqual, 0)
+
+		p = providers["interval"]
+		So(p.Schedule(), ShouldBeNil)
+		So(nextSchedule(p, last), ShouldEqual, last.Add(time.Hour))
+		So(syncInterval(p), ShouldEqual, time.Hour)
+
+		for _, invalid := range []string{
+			`schedule = "0 */6 * *"`,
+			`schedule = "0 0 30 2 *"`,
+			`schedule = "@daily"` + "\n" + `timezone = "Mars/Olympus"`,
+		} {
+			err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob1+`
+[[mirrors]]
+name = "invalid"
+provider = "command"
+command = "true"
+`+invalid+"\n"), 0644)
+			So(err, ShouldEqual, nil)
+			_, err = LoadConfig(tmpfile.Name())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "mirror invalid: ")
+		}
+	})
+}
+
+func TestConcurrencyGroups(t *testing.T) {
+	Convey("Mirrors should be in the concurrency groups of their upstream hosts", t, func() {
+		for upstream, host := range map[string]string{
+			"rsync://rsync.example.org/debian/":      "rsync.example.org",
+			"https://mirrors.example.org:8443/pypi/": "mirrors.example.org",
+			"rsync.example.org::ubuntu/":             "rsync.example.org",
+			"mirror@rsync.example.org::ubuntu/":      "rsync.example.org",
+			"":                                       "",
+		} {
+			So(upstreamHost(upstream), ShouldEqual, host)
+		}
+	})
+
+	Convey("Concurrency groups should be loaded", t, func() {
+		tmpfile, err := os.CreateTemp("", "tunasync")
+		So(err, ShouldEqual, nil)
+		defer os.Remove(tmpfile.Name())
+
+		cfgBlob := `
+[global]
+name = "test_worker"
+log_dir = "/var/log/tunasync/{{.Name}}"
+mirror_dir = "/data/mirrors"
+concurrent = 10
+interval = 240
+
+[manager]
+api_base = "https://127.0.0.1:5000"
+
+[[concurrency_groups]]
+name = "rsync.example.org"
+concurrent = 2
+
+[[concurrency_groups]]
+name = "heavy"
+concurrent = 1
+
+[[mirrors]]
+name = "debian"
+provider = "rsync"
+upstream = "rsync://rsync.example.org/debian/"
+
+[[mirrors]]
+name = "archlinux"
+provider = "rsync"
+upstream = "rsync://rsync.example.org/archlinux/"
+concurrency_group = "heavy"
+
+[[mirrors]]
+name = "local"
+provider = "command"
+command = "true"
+`
+		err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob), 0644)
+		So(err, ShouldEqual, nil)
+		defer tmpfile.Close()
+
+		cfg, err := LoadConfig(tmpfile.Name())
+		So(err, ShouldBeNil)
+		limits, err := cfg.concurrencyGroupLimits()
+		So(err, ShouldBeNil)
+		So(limits, ShouldResemble, map[string]int{"rsync.example.org": 2, "heavy": 1})
+
+		groups := map[string]string{}
+		for _, m := range cfg.Mirrors {
+			p := newMirrorProvider(m, cfg)
+			groups[p.Name()] = p.ConcurrencyGroup()
+		}
+		So(groups, ShouldResemble, map[string]string{
+			"debian": "rsync.example.org", "archlinux": "heavy", "local": "",
+		})
+
+		for _, invalid := range []string{
+			"[[mirrors]]\nname = \"invalid\"\nprovider = \"command\"\ncommand = \"true\"\nconcurrency_group = \"light\"\n",
+			"[[concurrency_groups]]\nname = \"heavy\"\nconcurrent = 2\n",
+			"[[concurrency_groups]]\nname = \"light\"\n",
+		} {
+			err = os.WriteFile(tmpfile.Name(), []byte(cfgBlob+invalid), 0644)
+			So(err, ShouldEqual, nil)
+			_, err = LoadConfig(tmpfile.Name())
+			So(err, ShouldNotBeNil)
+		}
+	})
 }
@@ -35,7 +35,7 @@ func TestExecPost(t *testing.T) {
 			So(err, ShouldBeNil)
 			provider.AddHook(hook)
 			managerChan := make(chan jobMessage)
-			semaphore := make(chan empty, 1)
+			admission := newAdmissionQueue(1)
 			job := newMirrorJob(provider)
 
 			scriptContent := `#!/bin/bash
@@ -48,7 +48,7 @@ echo $TUNASYNC_LOG_FILE
 			err = os.WriteFile(scriptFile, []byte(scriptContent), 0755)
 			So(err, ShouldBeNil)
 
-			go job.Run(managerChan, semaphore)
+			go job.Run(managerChan, admission)
 			job.ctrlChan <- jobStart
 			msg := <-managerChan
 			So(msg.status, ShouldEqual, PreSyncing)
@@ -73,7 +73,7 @@ echo $TUNASYNC_LOG_FILE
 			So(err, ShouldBeNil)
 			provider.AddHook(hook)
 			managerChan := make(chan jobMessage)
-			semaphore := make(chan empty, 1)
+			admission := newAdmissionQueue(1)
 			job := newMirrorJob(provider)
 
 			scriptContent := `#!/bin/bash
@@ -87,7 +87,7 @@ exit 1
 			err = os.WriteFile(scriptFile, []byte(scriptContent), 0755)
 			So(err, ShouldBeNil)
 
-			go job.Run(managerChan, semaphore)
+			go job.Run(managerChan, admission)
 			job.ctrlChan <- jobStart
 			msg := <-managerChan
 			So(msg.status, ShouldEqual, PreSyncing)
@@ -54,6 +54,8 @@ type mirrorJob struct {
 	disabled chan empty
 	state    uint32
 	size     string
+	// when the sync is due, in unix nanoseconds, zero if due when started
+	due int64
 }
 
 func newMirrorJob(provider mirrorProvider) *mirrorJob {
@@ -76,6 +78,24 @@ func (m *mirrorJob) SetState(state uint32) {
 	atomic.StoreUint32(&(m.state), state)
 }
 
+// Due returns when the job started by the schedule was due
+func (m *mirrorJob) Due() time.Time {
+	due := atomic.LoadInt64(&(m.due))
+	if due == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, due)
+}
+
+// SetDue sets when the job is due, a zero time means it is due once started
+func (m *mirrorJob) SetDue(due time.Time) {
+	var nsec int64
+	if !due.IsZero() {
+		nsec = due.UnixNano()
+	}
+	atomic.StoreInt64(&(m.due), nsec)
+}
+
 func (m *mirrorJob) SetProvider(provider mirrorProvider) error {
 	s := m.State()
 	if (s != stateNone) && (s != stateDisabled) {
@@ -91,10 +111,10 @@ func (m *mirrorJob) SetProvider(provider mirrorProvider) error {
 //	provider: mirror provider object
 //	ctrlChan: receives messages from the manager
 //	managerChan: push messages to the manager, this channel should have a larger buffer
-//	sempaphore: make sure the concurrent running syncing job won't explode
+//	admission: make sure the concurrent running syncing job won't explode
 //
 // TODO: message struct for managerChan
-func (m *mirrorJob) Run(managerChan chan<- jobMessage, semaphore chan empty) error {
+func (m *mirrorJob) Run(managerChan chan<- jobMessage, admission *admissionQueue) error {
 	jobsDone.Add(1)
 	m.disabled = make(chan empty)
 	defer func() {
@@ -146,6 +166,7 @@ func (m *mirrorJob) Run(managerChan chan<- jobMessage, semaphore chan empty) err
 
 			if retry > 0 {
 				logger.Noticef("retry syncing: %s, retry: %d", m.Name(), retry)
+				jobRetries.WithLabelValues(m.Name()).Inc()
 			}
 			err := runHooks(Hooks, func(h jobHook) error { return h.preExec() }, "pre-exec")
 			if err != nil {
@@ -156,6 +177,7 @@ func (m *mirrorJob) Run(managerChan chan<- jobMessage, semaphore chan empty) err
 			managerChan <- jobMessage{tunasync.Syncing, m.Name(), "", false}
 
 			var syncErr error
+			runStarted := time.Now()
 			syncDone := make(chan error, 1)
 			started := make(chan empty, 10) // we may receive "started" more than one time (e.g. two_stage_rsync)
 			go func() {
@@ -194,6 +216,7 @@ func (m *mirrorJob) Run(managerChan chan<- jobMessage, semaphore chan empty) err
 				logger.Errorf("failed to terminate provider %s: %s", m.Name(), termErr.Error())
 				return termErr
 			}
+			observeJobRun(m.Name(), runStarted, syncErr)
 
 			// post-exec hooks
 			herr := runHooks(rHooks, func(h jobHook) error { return h.postExec() }, "post-exec")
@@ -242,14 +265,18 @@ func (m *mirrorJob) Run(managerChan chan<- jobMessage, semaphore chan empty) err
 	}
 
 	runJob := func(kill <-chan empty, jobDone chan<- empty, bypassSemaphore <-chan empty) {
+		ticket := admission.wait(m.Name(), provider.ConcurrencyGroup(), provider.Priority(), m.Due())
+		m.SetDue(time.Time{})
 		select {
-		case semaphore <- empty{}:
-			defer func() { <-semaphore }()
+		case <-ticket.admitted:
+			defer admission.release(ticket)
 			runJobWrapper(kill, jobDone)
 		case <-bypassSemaphore:
+			admission.cancel(ticket)
 			logger.Noticef("Concurrent limit ignored by %s", m.Name())
 			runJobWrapper(kill, jobDone)
 		case <-kill:
+			admission.cancel(ticket)
 			jobDone <- empty{}
 			return
 		}
@@ -65,10 +65,10 @@ func TestMirrorJob(t *testing.T) {
 
 			Convey("If we let it run several times", func(ctx C) {
 				managerChan := make(chan jobMessage, 10)
-				semaphore := make(chan empty, 1)
+				admission := newAdmissionQueue(1)
 				job := newMirrorJob(provider)
 
-				go job.Run(managerChan, semaphore)
+				go job.Run(managerChan, admission)
 				// job should not start if we don't start it
 				select {
 				case <-managerChan:
@@ -134,11 +134,11 @@ echo '------'
 			provider.AddHook(h)
 
 			managerChan := make(chan jobMessage, 10)
-			semaphore := make(chan empty, 1)
+			admission := newAdmissionQueue(1)
 			job := newMirrorJob(provider)
 
 			Convey("If we kill it", func(ctx C) {
-				go job.Run(managerChan, semaphore)
+				go job.Run(managerChan, admission)
 				job.ctrlChan <- jobStart
 
 				time.Sleep(1 * time.Second)
@@ -157,7 +157,7 @@ echo '------'
 			})
 
 			Convey("If we kill it then start it", func(ctx C) {
-				go job.Run(managerChan, semaphore)
+				go job.Run(managerChan, admission)
 				job.ctrlChan <- jobStart
 
 				time.Sleep(1 * time.Second)
@@ -191,11 +191,11 @@ echo $TUNASYNC_WORKING_DIR
 			So(err, ShouldBeNil)
 
 			managerChan := make(chan jobMessage, 10)
-			semaphore := make(chan empty, 1)
+			admission := newAdmissionQueue(1)
 			job := newMirrorJob(provider)
 
 			Convey("If we kill it", func(ctx C) {
-				go job.Run(managerChan, semaphore)
+				go job.Run(managerChan, admission)
 				job.ctrlChan <- jobStart
 
 				time.Sleep(1 * time.Second)
@@ -220,7 +220,7 @@ echo $TUNASYNC_WORKING_DIR
 			})
 
 			Convey("If we don't kill it", func(ctx C) {
-				go job.Run(managerChan, semaphore)
+				go job.Run(managerChan, admission)
 				job.ctrlChan <- jobStart
 
 				msg := <-managerChan
@@ -243,7 +243,7 @@ echo $TUNASYNC_WORKING_DIR
 			})
 
 			Convey("If we restart it", func(ctx C) {
-				go job.Run(managerChan, semaphore)
+				go job.Run(managerChan, admission)
 				job.ctrlChan <- jobStart
 
 				msg := <-managerChan
@@ -277,7 +277,7 @@ echo $TUNASYNC_WORKING_DIR
 			})
 
 			Convey("If we disable it", func(ctx C) {
-				go job.Run(managerChan, semaphore)
+				go job.Run(managerChan, admission)
 				job.ctrlChan <- jobStart
 
 				msg := <-managerChan
@@ -295,7 +295,7 @@ echo $TUNASYNC_WORKING_DIR
 			})
 
 			Convey("If we stop it twice, than start it", func(ctx C) {
-				go job.Run(managerChan, semaphore)
+				go job.Run(managerChan, admission)
 				job.ctrlChan <- jobStart
 
 				msg := <-managerChan
@@ -344,11 +344,11 @@ echo $TUNASYNC_WORKING_DIR
 			So(err, ShouldBeNil)
 
 			managerChan := make(chan jobMessage, 10)
-			semaphore := make(chan empty, 1)
+			admission := newAdmissionQueue(1)
 			job := newMirrorJob(provider)
 
 			Convey("It should be automatically terminated", func(ctx C) {
-				go job.Run(managerChan, semaphore)
+				go job.Run(managerChan, admission)
 				job.ctrlChan <- jobStart
 
 				time.Sleep(1 * time.Second)
@@ -371,7 +371,7 @@ echo $TUNASYNC_WORKING_DIR
 			})
 
 			Convey("It should be retried", func(ctx C) {
-				go job.Run(managerChan, semaphore)
+				go job.Run(managerChan, admission)
 				job.ctrlChan <- jobStart
 				time.Sleep(1 * time.Second)
 				msg := <-managerChan
@@ -429,7 +429,7 @@ func TestConcurrentMirrorJobs(t *testing.T) {
 		}
 
 		managerChan := make(chan jobMessage, 10)
-		semaphore := make(chan empty, CONCURRENT-2)
+		admission := newAdmissionQueue(CONCURRENT - 2)
 
 		countingJobs := func(managerChan chan jobMessage, totalJobs, concurrentCheck int) (peakConcurrent, counterFailed int) {
 			counterEnded := 0
@@ -451,7 +451,7 @@ func TestConcurrentMirrorJobs(t *testing.T) {
 				default:
 					So(0, ShouldEqual, 1)
 				}
-				// Test if semaphore works
+				// Test if the concurrent limit works
 				So(counterRunning, ShouldBeLessThanOrEqualTo, concurrentCheck)
 				if counterRunning > peakConcurrent {
 					peakConcurrent = counterRunning
@@ -468,7 +468,7 @@ func TestConcurrentMirrorJobs(t *testing.T) {
 
 		Convey("When we run them all", func(ctx C) {
 			for _, job := range jobs {
-				go job.Run(managerChan, semaphore)
+				go job.Run(managerChan, admission)
 				job.ctrlChan <- jobStart
 			}
 
@@ -484,12 +484,12 @@ func TestConcurrentMirrorJobs(t *testing.T) {
 		})
 		Convey("If we cancel one job", func(ctx C) {
 			for _, job := range jobs {
-				go job.Run(managerChan, semaphore)
+				go job.Run(managerChan, admission)
 				job.ctrlChan <- jobRestart
 				time.Sleep(200 * time.Millisecond)
 			}
 
-			// Cancel the one waiting for semaphore
+			// Cancel the one waiting for a slot
 			jobs[len(jobs)-1].ctrlChan <- jobStop
 
 			peakConcurrent, counterFailed := countingJobs(managerChan, CONCURRENT-1, CONCURRENT-2)
@@ -504,7 +504,7 @@ func TestConcurrentMirrorJobs(t *testing.T) {
 		})
 		Convey("If we override the concurrent limit", func(ctx C) {
 			for _, job := range jobs {
-				go job.Run(managerChan, semaphore)
+				go job.Run(managerChan, admission)
 				job.ctrlChan <- jobStart
 				time.Sleep(200 * time.Millisecond)
 			}
@@ -47,7 +47,7 @@ func TestLogLimiter(t *testing.T) {
 			So(len(matches), ShouldEqual, 15)
 
 			managerChan := make(chan jobMessage)
-			semaphore := make(chan empty, 1)
+			admission := newAdmissionQueue(1)
 			job := newMirrorJob(provider)
 
 			scriptContent := `#!/bin/bash
@@ -60,7 +60,7 @@ echo $TUNASYNC_LOG_FILE
 			err = os.WriteFile(scriptFile, []byte(scriptContent), 0755)
 			So(err, ShouldBeNil)
 
-			go job.Run(managerChan, semaphore)
+			go job.Run(managerChan, admission)
 			job.ctrlChan <- jobStart
 			msg := <-managerChan
 			So(msg.status, ShouldEqual, PreSyncing)
@@ -92,7 +92,7 @@ echo $TUNASYNC_LOG_FILE
 
 		Convey("If job failed simply", func() {
 			managerChan := make(chan jobMessage)
-			semaphore := make(chan empty, 1)
+			admission := newAdmissionQueue(1)
 			job := newMirrorJob(provider)
 
 			scriptContent := `#!/bin/bash
@@ -106,7 +106,7 @@ sleep 5
 			err = os.WriteFile(scriptFile, []byte(scriptContent), 0755)
 			So(err, ShouldBeNil)
 
-			go job.Run(managerChan, semaphore)
+			go job.Run(managerChan, admission)
 			job.ctrlChan <- jobStart
 			msg := <-managerChan
 			So(msg.status, ShouldEqual, PreSyncing)
@@ -0,0 +1,250 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/tuna/tunasync/internal"
+)
+
+// how often a followed log is checked for new content
+var logFollowInterval = 500 * time.Millisecond
+
+// logFilePattern matches the log files named by the logLimiter
+func logFilePattern(name string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(name) +
+		`_\d{4}-\d{2}-\d{2}_\d{2}_\d{2}\.log(\.fail)?$`)
+}
+
+// latestLog returns the path of the log of the latest run, which
+// is empty if there is none
+func latestLog(p mirrorProvider) string {
+	target, err := os.Readlink(filepath.Join(p.LogDir(), "latest"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(p.LogDir(), target)
+}
+
+// jobLogFiles lists the log files of the job, newest first
+func jobLogFiles(p mirrorProvider) ([]JobLogFile, error) {
+	entries, err := os.ReadDir(p.LogDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []JobLogFile{}, nil
+		}
+		return nil, err
+	}
+	pattern := logFilePattern(p.Name())
+	latest := filepath.Base(latestLog(p))
+	files := []JobLogFile{}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !pattern.MatchString(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, JobLogFile{
+			Name:    e.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Failed:  filepath.Ext(e.Name()) == ".fail",
+			Latest:  e.Name() == latest,
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].ModTime.Equal(files[j].ModTime) {
+			return files[i].ModTime.After(files[j].ModTime)
+		}
+		return files[i].Name > files[j].Name
+	})
+	return files, nil
+}
+
+// tailOffset returns the offset of the last n lines of the
+// first size bytes of f
+func tailOffset(f io.ReaderAt, size int64, n int) (int64, error) {
+	const chunk = 4096
+	buf := make([]byte, chunk)
+	end := size
+	// the newline ending the last line does not count
+	if end > 0 {
+		if _, err := f.ReadAt(buf[:1], end-1); err != nil {
+			return 0, err
+		}
+		if buf[0] == '\n' {
+			end--
+		}
+	}
+	for end > 0 {
+		start := end - chunk
+		if start < 0 {
+			start = 0
+		}
+		b := buf[:end-start]
+		if _, err := f.ReadAt(b, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := len(b) - 1; i >= 0; i-- {
+			if b[i] == '\n' {
+				n--
+				if n <= 0 {
+					return start + int64(i) + 1, nil
+				}
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// jobOfRequest finds the job of the request to its logs, and
+// responds with the error if the request is invalid
+func (w *Worker) jobOfRequest(c *gin.Context) (*mirrorJob, bool) {
+	if !w.verifyRequest(c, nil) {
+		return nil, false
+	}
+	w.L.Lock()
+	job, ok := w.jobs[c.Param("job")]
+	w.L.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"msg": fmt.Sprintf("Mirror ``%s'' not found", c.Param("job"))})
+		return nil, false
+	}
+	return job, true
+}
+
+// listJobLogs responds with the log files of a job
+func (w *Worker) listJobLogs(c *gin.Context) {
+	job, ok := w.jobOfRequest(c)
+	if !ok {
+		return
+	}
+	files, err := jobLogFiles(job.provider)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+// serveJobLog serves a log file of a job, the latest one by default.
+// The byte ranges are supported, the last lines are served with tail,
+// and with follow the new content is streamed until the run is over.
+func (w *Worker) serveJobLog(c *gin.Context) {
+	job, ok := w.jobOfRequest(c)
+	if !ok {
+		return
+	}
+	p := job.provider
+
+	var tail int
+	var follow bool
+	var err error
+	if v := c.Query("tail"); v != "" {
+		if tail, err = strconv.Atoi(v); err != nil || tail < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid tail: " + v})
+			return
+		}
+	}
+	if v := c.Query("follow"); v != "" {
+		if follow, err = strconv.ParseBool(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid follow: " + v})
+			return
+		}
+	}
+
+	name := c.Query("file")
+	if name == "" {
+		name = filepath.Base(latestLog(p))
+		if name == "." {
+			c.JSON(http.StatusNotFound, gin.H{"msg": fmt.Sprintf("No log of mirror ``%s'' yet", p.Name())})
+			return
+		}
+	}
+	if !logFilePattern(p.Name()).MatchString(name) {
+		c.JSON(http.StatusNotFound, gin.H{"msg": fmt.Sprintf("Log ``%s'' not found", name)})
+		return
+	}
+	f, err := os.Open(filepath.Join(p.LogDir(), name))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"msg": fmt.Sprintf("Log ``%s'' not found", name)})
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+		return
+	}
+
+	c.Header(LogFileHeader, name)
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	if tail == 0 && !follow {
+		http.ServeContent(c.Writer, c.Request, name, info.ModTime(), f)
+		return
+	}
+
+	offset := int64(0)
+	if tail > 0 {
+		if offset, err = tailOffset(f, info.Size(), tail); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+			return
+		}
+	}
+	if !follow {
+		c.Header("Content-Length", strconv.FormatInt(info.Size()-offset, 10))
+		c.Status(http.StatusOK)
+		io.Copy(c.Writer, io.NewSectionReader(f, offset, info.Size()-offset))
+		return
+	}
+
+	// the stream lives longer than the write timeout of the server
+	rc := http.NewResponseController(c.Writer)
+	rc.SetWriteDeadline(time.Time{})
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
+	for {
+		n, err := io.Copy(c.Writer, io.NewSectionReader(f, offset, 1<<62))
+		offset += n
+		if err != nil {
+			return
+		}
+		if rc.Flush() != nil {
+			return
+		}
+		if n == 0 && !followingRun(p, info) {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-c.Request.Context().Done():
+			return
+		case <-w.exit:
+			return
+		}
+	}
+}
+
+// followingRun tells whether the log file is written by a running job,
+// its run is over once it is not running or a new run is started
+func followingRun(p mirrorProvider, info os.FileInfo) bool {
+	if !p.IsRunning() {
+		return false
+	}
+	latest, err := os.Stat(latestLog(p))
+	return err == nil && os.SameFile(latest, info)
+}
@@ -0,0 +1,145 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestJobLogs(t *testing.T) {
+	Convey("Worker should serve the job logs", t, func(ctx C) {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+
+		provider, err := newCmdProvider(cmdConfig{
+			name:       "tuna-logs",
+			command:    "true",
+			workingDir: tmpDir,
+			logDir:     tmpDir,
+			logFile:    filepath.Join(tmpDir, "latest.log"),
+			interval:   600 * time.Second,
+		})
+		So(err, ShouldBeNil)
+		w := &Worker{
+			cfg:    &Config{},
+			jobs:   map[string]*mirrorJob{provider.Name(): newMirrorJob(provider)},
+			exit:   make(chan empty),
+			tokens: make(map[string]string),
+		}
+		w.makeHTTPServer()
+		server := httptest.NewServer(w.httpEngine)
+		defer server.Close()
+		defer close(w.exit)
+
+		// an earlier failed run and the latest run
+		failed := "tuna-logs_2024-05-01_10_00.log.fail"
+		latest := "tuna-logs_2024-05-02_10_00.log"
+		So(os.WriteFile(filepath.Join(tmpDir, failed), []byte("rsync error\n"), 0644), ShouldBeNil)
+		So(os.WriteFile(filepath.Join(tmpDir, latest), []byte("line 1\nline 2\nline 3\n"), 0644), ShouldBeNil)
+		So(os.WriteFile(filepath.Join(tmpDir, "other_2024-05-02_10_00.log"), []byte("other\n"), 0644), ShouldBeNil)
+		So(os.Chtimes(filepath.Join(tmpDir, failed), time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)), ShouldBeNil)
+		So(os.Symlink(latest, filepath.Join(tmpDir, "latest")), ShouldBeNil)
+
+		get := func(path string, header http.Header) (*http.Response, string) {
+			req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+			So(err, ShouldBeNil)
+			for k, v := range header {
+				req.Header[k] = v
+			}
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			return resp, string(body)
+		}
+
+		Convey("list the log files", func() {
+			var files []JobLogFile
+			resp, err := GetJSON(server.URL+"/jobs/tuna-logs/logs", &files, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(files, ShouldHaveLength, 2)
+			So(files[0].Name, ShouldEqual, latest)
+			So(files[0].Latest, ShouldBeTrue)
+			So(files[0].Size, ShouldEqual, 21)
+			So(files[1].Name, ShouldEqual, failed)
+			So(files[1].Failed, ShouldBeTrue)
+
+			resp, _ = get("/jobs/nonexistent/logs", nil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("serve the latest log", func() {
+			resp, body := get("/jobs/tuna-logs/log", nil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get(LogFileHeader), ShouldEqual, latest)
+			So(body, ShouldEqual, "line 1\nline 2\nline 3\n")
+
+			resp, body = get("/jobs/tuna-logs/log", http.Header{"Range": {"bytes=7-12"}})
+			So(resp.StatusCode, ShouldEqual, http.StatusPartialContent)
+			So(body, ShouldEqual, "line 2")
+
+			resp, body = get("/jobs/tuna-logs/log?tail=2", nil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(body, ShouldEqual, "line 2\nline 3\n")
+		})
+
+		Convey("serve an earlier log", func() {
+			resp, body := get("/jobs/tuna-logs/log?file="+failed, nil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(body, ShouldEqual, "rsync error\n")
+
+			for _, name := range []string{"other_2024-05-02_10_00.log", "../tuna-logs_2024-05-02_10_00.log", "latest"} {
+				resp, _ = get("/jobs/tuna-logs/log?file="+name, nil)
+				So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+			}
+		})
+
+		Convey("follow the log of a running sync", func() {
+			defer func(d time.Duration) { logFollowInterval = d }(logFollowInterval)
+			logFollowInterval = 50 * time.Millisecond
+			provider.isRunning.Store(true)
+
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				f, err := os.OpenFile(filepath.Join(tmpDir, latest), os.O_APPEND|os.O_WRONLY, 0644)
+				if err == nil {
+					fmt.Fprintln(f, "line 4")
+					f.Close()
+				}
+				time.Sleep(100 * time.Millisecond)
+				provider.isRunning.Store(false)
+			}()
+			resp, body := get("/jobs/tuna-logs/log?follow=true&tail=1", nil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(body, ShouldEqual, "line 3\nline 4\n")
+		})
+
+		Convey("only for the manager with a session token", func() {
+			w.tokens["http://manager"] = "session_token"
+			resp, _ := get("/jobs/tuna-logs/log", nil)
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/jobs/tuna-logs/log?tail=1", nil)
+			So(err, ShouldBeNil)
+			SignRequest(req, nil, "session_token", time.Now())
+			resp, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(strings.TrimSpace(string(body)), ShouldEqual, "line 3")
+		})
+	})
+}
@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"errors"
+	"os/exec"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var jobStateNames = map[uint32]string{
+	stateNone:     "none",
+	stateReady:    "ready",
+	statePaused:   "paused",
+	stateDisabled: "disabled",
+	stateHalting:  "halting",
+}
+
+// metrics shared by all the jobs, updated as the jobs run
+var (
+	jobRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tunasync_worker_job_run_duration_seconds",
+		Help:    "Duration of each sync attempt of a job",
+		Buckets: prometheus.ExponentialBuckets(10, 3, 8),
+	}, []string{"mirror", "result"})
+	jobRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tunasync_worker_job_retries_total",
+		Help: "Number of retried sync attempts of a job",
+	}, []string{"mirror"})
+	jobLastExitCode = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tunasync_worker_job_last_exit_code",
+		Help: "Exit code of the last sync attempt of a job",
+	}, []string{"mirror"})
+	reportFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tunasync_worker_report_failures_total",
+		Help: "Number of failed reports to a manager",
+	}, []string{"manager", "type"})
+)
+
+var (
+	jobStateDesc = prometheus.NewDesc(
+		"tunasync_worker_job_state",
+		"Current state of a job, 1 for the current state",
+		[]string{"mirror", "state"}, nil,
+	)
+	concurrentJobsDesc = prometheus.NewDesc(
+		"tunasync_worker_concurrent_jobs",
+		"Number of jobs holding a concurrency slot",
+		nil, nil,
+	)
+	concurrentLimitDesc = prometheus.NewDesc(
+		"tunasync_worker_concurrent_limit",
+		"Maximum number of concurrently running jobs",
+		nil, nil,
+	)
+	groupJobsDesc = prometheus.NewDesc(
+		"tunasync_worker_group_concurrent_jobs",
+		"Number of jobs holding a slot of the concurrency group",
+		[]string{"group"}, nil,
+	)
+	groupLimitDesc = prometheus.NewDesc(
+		"tunasync_worker_group_concurrent_limit",
+		"Maximum number of concurrently running jobs of the concurrency group",
+		[]string{"group"}, nil,
+	)
+	waitingJobsDesc = prometheus.NewDesc(
+		"tunasync_worker_waiting_jobs",
+		"Number of jobs waiting for a concurrency slot",
+		nil, nil,
+	)
+	pendingMessagesDesc = prometheus.NewDesc(
+		"tunasync_worker_pending_status_messages",
+		"Number of job status messages waiting to be reported",
+		nil, nil,
+	)
+)
+
+// observeJobRun records a finished sync attempt of a job
+func observeJobRun(name string, started time.Time, syncErr error) {
+	result := "success"
+	if syncErr != nil {
+		result = "failed"
+	}
+	jobRunDuration.WithLabelValues(name, result).Observe(time.Since(started).Seconds())
+
+	var exitErr *exec.ExitError
+	if syncErr == nil {
+		jobLastExitCode.WithLabelValues(name).Set(0)
+	} else if errors.As(syncErr, &exitErr) {
+		jobLastExitCode.WithLabelValues(name).Set(float64(exitErr.ExitCode()))
+	}
+}
+
+// forgetJobMetrics drops the metrics of a deleted job
+func forgetJobMetrics(name string) {
+	labels := prometheus.Labels{"mirror": name}
+	jobRunDuration.DeletePartialMatch(labels)
+	jobRetries.DeletePartialMatch(labels)
+	jobLastExitCode.DeletePartialMatch(labels)
+}
+
+// workerCollector exports the current state of a worker at scrape time
+type workerCollector struct {
+	w *Worker
+}
+
+func (wc workerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jobStateDesc
+	ch <- concurrentJobsDesc
+	ch <- concurrentLimitDesc
+	ch <- groupJobsDesc
+	ch <- groupLimitDesc
+	ch <- waitingJobsDesc
+	ch <- pendingMessagesDesc
+}
+
+func (wc workerCollector) Collect(ch chan<- prometheus.Metric) {
+	w := wc.w
+	// L is held long by the commands disabling the jobs, which
+	// the scrapes do not wait for
+	if jobs := w.jobsView.Load(); jobs != nil {
+		for name, job := range *jobs {
+			current := job.State()
+			for state, stateName := range jobStateNames {
+				v := 0.0
+				if state == current {
+					v = 1
+				}
+				ch <- prometheus.MustNewConstMetric(jobStateDesc,
+					prometheus.GaugeValue, v, name, stateName)
+			}
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(concurrentJobsDesc,
+		prometheus.GaugeValue, float64(w.admission.Running()))
+	ch <- prometheus.MustNewConstMetric(concurrentLimitDesc,
+		prometheus.GaugeValue, float64(w.admission.Limit()))
+	for _, g := range w.admission.Groups() {
+		ch <- prometheus.MustNewConstMetric(groupJobsDesc,
+			prometheus.GaugeValue, float64(g.Running), g.Name)
+		ch <- prometheus.MustNewConstMetric(groupLimitDesc,
+			prometheus.GaugeValue, float64(g.Limit), g.Name)
+	}
+	ch <- prometheus.MustNewConstMetric(waitingJobsDesc,
+		prometheus.GaugeValue, float64(len(w.admission.Waiting())))
+	ch <- prometheus.MustNewConstMetric(pendingMessagesDesc,
+		prometheus.GaugeValue, float64(len(w.managerChan)))
+}
+
+// metricsHandler serves the metrics of the worker
+func (w *Worker) metricsHandler() gin.HandlerFunc {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		jobRunDuration,
+		jobRetries,
+		jobLastExitCode,
+		reportFailures,
+		workerCollector{w: w},
+	)
+	return gin.WrapH(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+}
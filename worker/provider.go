@@ -45,6 +45,14 @@ type mirrorProvider interface {
 	Hooks() []jobHook
 
 	Interval() time.Duration
+	// the cron schedule overriding the interval, nil if none
+	Schedule() *cronSchedule
+	// when the job may start, nil if at any time
+	Windows() *syncWindows
+	// the jobs of higher priorities are admitted to sync first
+	Priority() int
+	// the jobs of a group hold a slot of it besides a global one
+	ConcurrencyGroup() string
 	Retry() int
 	Timeout() time.Duration
 
@@ -61,6 +69,12 @@ type mirrorProvider interface {
 	// return context
 	Context() *Context
 
+	// set in newMirrorProvider, used by the scheduler
+	SetSchedule(s *cronSchedule)
+	SetWindows(sw *syncWindows)
+	SetPriority(priority int)
+	SetConcurrencyGroup(group string)
+
 	// set in newMirrorProvider, used by cmdJob.Wait
 	SetSuccessExitCodes(codes []int)
 	GetSuccessExitCodes() []int
@@ -90,6 +104,19 @@ func newMirrorProvider(mirror mirrorConfig, cfg *Config) mirrorProvider {
 			cfg.Global.MirrorDir, mirror.MirrorSubDir, mirror.Name,
 		)
 	}
+	// the interval of the mirror overrides the global schedule
+	schedule, err := mirrorSchedule(mirror, cfg)
+	if err != nil {
+		panic(err)
+	}
+	windows, err := mirrorWindows(mirror, cfg)
+	if err != nil {
+		panic(err)
+	}
+	group, err := mirrorConcurrencyGroup(mirror, cfg)
+	if err != nil {
+		panic(err)
+	}
 	if mirror.Interval == 0 {
 		mirror.Interval = cfg.Global.Interval
 	}
@@ -197,6 +224,11 @@ func newMirrorProvider(mirror mirrorConfig, cfg *Config) mirrorProvider {
 		panic(errors.New("Invalid mirror provider"))
 	}
 
+	provider.SetSchedule(schedule)
+	provider.SetWindows(windows)
+	provider.SetPriority(mirror.Priority)
+	provider.SetConcurrencyGroup(group)
+
 	// Add Logging Hook
 	provider.AddHook(newLogLimiter(provider))
 
@@ -3,9 +3,11 @@ package worker
 // schedule queue for jobs
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
+	"github.com/robfig/cron/v3"
 	"github.com/ryszard/goskiplist/skiplist"
 )
 
@@ -13,11 +15,14 @@ type scheduleQueue struct {
 	sync.Mutex
 	list *skiplist.SkipList
 	jobs map[string]bool
+	// why the jobs deferred by their sync windows are
+	deferred map[string]string
 }
 
 type jobScheduleInfo struct {
 	jobName       string
 	nextScheduled time.Time
+	deferred      string
 }
 
 func timeLessThan(l, r interface{}) bool {
@@ -30,10 +35,13 @@ func newScheduleQueue() *scheduleQueue {
 	queue := new(scheduleQueue)
 	queue.list = skiplist.NewCustomMap(timeLessThan)
 	queue.jobs = make(map[string]bool)
+	queue.deferred = make(map[string]string)
 	return queue
 }
 
 func (q *scheduleQueue) GetJobs() (jobs []jobScheduleInfo) {
+	q.Lock()
+	defer q.Unlock()
 	cur := q.list.Iterator()
 	defer cur.Close()
 
@@ -42,12 +50,19 @@ func (q *scheduleQueue) GetJobs() (jobs []jobScheduleInfo) {
 		jobs = append(jobs, jobScheduleInfo{
 			cj.Name(),
 			cur.Key().(time.Time),
+			q.deferred[cj.Name()],
 		})
 	}
 	return
 }
 
 func (q *scheduleQueue) AddJob(schedTime time.Time, job *mirrorJob) {
+	q.DeferJob(schedTime, job, "")
+}
+
+// DeferJob schedules the job at the time its sync windows open,
+// with the reason it is deferred
+func (q *scheduleQueue) DeferJob(schedTime time.Time, job *mirrorJob, reason string) {
 	q.Lock()
 	defer q.Unlock()
 	if _, ok := q.jobs[job.Name()]; ok {
@@ -55,18 +70,35 @@ func (q *scheduleQueue) AddJob(schedTime time.Time, job *mirrorJob) {
 		q.unsafeRemove(job.Name())
 	}
 	q.jobs[job.Name()] = true
+	if reason != "" {
+		q.deferred[job.Name()] = reason
+	}
+	// the jobs on the same cron schedule come at the same time,
+	// which are kept apart as the times are the keys
+	for {
+		if _, ok := q.list.Get(schedTime); !ok {
+			break
+		}
+		schedTime = schedTime.Add(time.Nanosecond)
+	}
 	q.list.Set(schedTime, job)
 	logger.Debugf("Added job %s @ %v", job.Name(), schedTime)
 }
 
 // pop out the first job if it's time to run it
 func (q *scheduleQueue) Pop() *mirrorJob {
+	job, _ := q.PopDue()
+	return job
+}
+
+// PopDue pops out the first job due, with the time it was due
+func (q *scheduleQueue) PopDue() (*mirrorJob, time.Time) {
 	q.Lock()
 	defer q.Unlock()
 
 	first := q.list.SeekToFirst()
 	if first == nil {
-		return nil
+		return nil, time.Time{}
 	}
 	defer first.Close()
 
@@ -75,10 +107,11 @@ func (q *scheduleQueue) Pop() *mirrorJob {
 		job := first.Value().(*mirrorJob)
 		q.list.Delete(first.Key())
 		delete(q.jobs, job.Name())
+		delete(q.deferred, job.Name())
 		logger.Debugf("Popped out job %s @%v", job.Name(), t)
-		return job
+		return job, t
 	}
-	return nil
+	return nil, time.Time{}
 }
 
 // remove job
@@ -98,8 +131,81 @@ func (q *scheduleQueue) unsafeRemove(name string) bool {
 		if cj.Name() == name {
 			q.list.Delete(cur.Key())
 			delete(q.jobs, name)
+			delete(q.deferred, name)
 			return true
 		}
 	}
 	return false
 }
+
+// a cronSchedule is the cron expression a job is synced on,
+// evaluated in its time zone
+type cronSchedule struct {
+	spec  string
+	sched cron.Schedule
+	loc   *time.Location
+}
+
+// parseCronSchedule parses the standard cron expression of five
+// fields, or the descriptors like @daily. The time zone is the
+// local one if empty.
+func parseCronSchedule(spec, timezone string) (*cronSchedule, error) {
+	sched, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %s", spec, err.Error())
+	}
+	loc, err := loadTimezone(timezone)
+	if err != nil {
+		return nil, err
+	}
+	s := &cronSchedule{spec: spec, sched: sched, loc: loc}
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid schedule %q: it never comes", spec)
+	}
+	return s, nil
+}
+
+// loadTimezone loads the time zone like "Asia/Shanghai", which is
+// the local one if empty
+func loadTimezone(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %s", timezone, err.Error())
+	}
+	return loc, nil
+}
+
+// Next returns the first time on the schedule after t
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	return s.sched.Next(t.In(s.loc))
+}
+
+func (s *cronSchedule) String() string {
+	if s.loc == time.Local {
+		return s.spec
+	}
+	return fmt.Sprintf("%s (%s)", s.spec, s.loc)
+}
+
+// nextSchedule returns when the job is synced next, after it is
+// synced at last: the next time on its schedule if any, or an
+// interval later, so that the syncs do not drift by the run time
+func nextSchedule(p mirrorProvider, last time.Time) time.Time {
+	if s := p.Schedule(); s != nil {
+		return s.Next(last)
+	}
+	return last.Add(p.Interval())
+}
+
+// syncInterval returns how often the job is synced, which is the
+// time between the next two syncs for a job on a schedule
+func syncInterval(p mirrorProvider) time.Duration {
+	if s := p.Schedule(); s != nil {
+		next := s.Next(time.Now())
+		return s.Next(next).Sub(next)
+	}
+	return p.Interval()
+}
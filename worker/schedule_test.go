@@ -63,6 +63,37 @@ func TestSchedule(t *testing.T) {
 			time.Sleep(1200 * time.Millisecond)
 			So(schedule.Pop(), ShouldBeNil)
 		})
+		Convey("When adding jobs at the same time", func() {
+			p1, _ := newCmdProvider(cmdConfig{name: "schedule_test1"})
+			p2, _ := newCmdProvider(cmdConfig{name: "schedule_test2"})
+			job1, job2 := newMirrorJob(p1), newMirrorJob(p2)
+			sched := time.Now().Add(-time.Second)
+
+			schedule.AddJob(sched, job1)
+			schedule.AddJob(sched, job2)
+			So(schedule.GetJobs(), ShouldHaveLength, 2)
+			So(schedule.Pop(), ShouldEqual, job1)
+			So(schedule.Pop(), ShouldEqual, job2)
+		})
+		Convey("When deferring jobs", func() {
+			p1, _ := newCmdProvider(cmdConfig{name: "schedule_test1"})
+			p2, _ := newCmdProvider(cmdConfig{name: "schedule_test2"})
+			job1, job2 := newMirrorJob(p1), newMirrorJob(p2)
+			sched := time.Now().Add(-2 * time.Second)
+
+			schedule.DeferJob(sched, job1, "outside sync windows 01:00-07:00")
+			schedule.AddJob(sched.Add(time.Second), job2)
+			jobs := schedule.GetJobs()
+			So(jobs, ShouldHaveLength, 2)
+			So(jobs[0].jobName, ShouldEqual, "schedule_test1")
+			So(jobs[0].deferred, ShouldEqual, "outside sync windows 01:00-07:00")
+			So(jobs[1].deferred, ShouldBeEmpty)
+
+			So(schedule.Pop(), ShouldEqual, job1)
+			So(schedule.Pop(), ShouldEqual, job2)
+			schedule.AddJob(sched, job1)
+			So(schedule.GetJobs()[0].deferred, ShouldBeEmpty)
+		})
 
 	})
 }
@@ -0,0 +1,248 @@
+package worker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the longest a job is deferred before its windows are checked again,
+// when they never open, e.g. all of them are in the past
+const windowRecheckInterval = time.Hour
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+	"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// a timeWindow is either a period recurring on some days of the week,
+// like "Mon-Fri 01:00-07:00", or a period once, like
+// "2024-06-01T00:00/2024-06-01T06:00"
+type timeWindow struct {
+	spec string
+	// minutes of the day, the period goes past midnight if the end
+	// is not after the start, and it is on the day it starts
+	days       [7]bool
+	start, end int
+	// the period once
+	from, to time.Time
+}
+
+// parseTimeWindow parses a time window, the times are in loc
+// unless the time zone is given for a period once
+func parseTimeWindow(spec string, loc *time.Location) (timeWindow, error) {
+	tw := timeWindow{spec: spec}
+	invalid := func() (timeWindow, error) {
+		return tw, fmt.Errorf("invalid time window %q", spec)
+	}
+
+	if from, to, ok := strings.Cut(spec, "/"); ok {
+		var err error
+		if tw.from, err = parseWindowTime(strings.TrimSpace(from), loc); err != nil {
+			return invalid()
+		}
+		if tw.to, err = parseWindowTime(strings.TrimSpace(to), loc); err != nil {
+			return invalid()
+		}
+		if !tw.from.Before(tw.to) {
+			return invalid()
+		}
+		return tw, nil
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 1:
+		for i := range tw.days {
+			tw.days[i] = true
+		}
+	case 2:
+		for _, r := range strings.Split(fields[0], ",") {
+			first, last, isRange := strings.Cut(r, "-")
+			d1, ok1 := weekdayNames[strings.ToLower(first)]
+			d2, ok2 := d1, true
+			if isRange {
+				d2, ok2 = weekdayNames[strings.ToLower(last)]
+			}
+			if !ok1 || !ok2 {
+				return invalid()
+			}
+			for d := d1; ; d = (d + 1) % 7 {
+				tw.days[d] = true
+				if d == d2 {
+					break
+				}
+			}
+		}
+	default:
+		return invalid()
+	}
+	start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+	var err1, err2 error
+	tw.start, err1 = parseMinuteOfDay(start)
+	tw.end, err2 = parseMinuteOfDay(end)
+	if !ok || err1 != nil || err2 != nil {
+		return invalid()
+	}
+	return tw, nil
+}
+
+// parseWindowTime parses a time in RFC 3339, or without the
+// seconds and the time zone
+func parseWindowTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02T15:04", s, loc)
+}
+
+// parseMinuteOfDay parses HH:MM, where 24:00 is the end of the day
+func parseMinuteOfDay(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if !ok || len(m) != 2 || err1 != nil || err2 != nil || hour < 0 || minute < 0 ||
+		minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return hour*60 + minute, nil
+}
+
+func (tw timeWindow) once() bool {
+	return !tw.to.IsZero()
+}
+
+// contains tells whether t, in the location of the window, is in it
+func (tw timeWindow) contains(t time.Time) bool {
+	if tw.once() {
+		return !t.Before(tw.from) && t.Before(tw.to)
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if tw.start < tw.end {
+		return tw.days[t.Weekday()] && minute >= tw.start && minute < tw.end
+	}
+	yesterday := (t.Weekday() + 6) % 7
+	return (tw.days[t.Weekday()] && minute >= tw.start) ||
+		(tw.days[yesterday] && minute < tw.end)
+}
+
+// nextChange returns the first time after t the window may open or
+// close, which is zero if it never does
+func (tw timeWindow) nextChange(t time.Time) time.Time {
+	if tw.once() {
+		for _, edge := range []time.Time{tw.from, tw.to} {
+			if edge.After(t) {
+				return edge
+			}
+		}
+		return time.Time{}
+	}
+	var next time.Time
+	y, m, d := t.Date()
+	for k := -1; k <= 8; k++ {
+		for _, minute := range []int{tw.start, tw.end} {
+			edge := time.Date(y, m, d+k, 0, minute, 0, 0, t.Location())
+			if edge.After(t) && (next.IsZero() || edge.Before(next)) {
+				next = edge
+			}
+		}
+	}
+	return next
+}
+
+func (tw timeWindow) String() string {
+	return tw.spec
+}
+
+// syncWindows tells when a job may start: within one of the windows
+// if any, but never during a blackout
+type syncWindows struct {
+	windows   []timeWindow
+	blackouts []timeWindow
+	loc       *time.Location
+}
+
+func newSyncWindows(windows, blackouts []string, loc *time.Location) (*syncWindows, error) {
+	if len(windows) == 0 && len(blackouts) == 0 {
+		return nil, nil
+	}
+	sw := &syncWindows{loc: loc}
+	for _, spec := range windows {
+		tw, err := parseTimeWindow(spec, loc)
+		if err != nil {
+			return nil, err
+		}
+		sw.windows = append(sw.windows, tw)
+	}
+	for _, spec := range blackouts {
+		tw, err := parseTimeWindow(spec, loc)
+		if err != nil {
+			return nil, err
+		}
+		sw.blackouts = append(sw.blackouts, tw)
+	}
+	return sw, nil
+}
+
+// blocked returns why a job may not start at t, which is empty if it may
+func (sw *syncWindows) blocked(t time.Time) string {
+	t = t.In(sw.loc)
+	for _, tw := range sw.blackouts {
+		if tw.contains(t) {
+			return fmt.Sprintf("in blackout %s", tw)
+		}
+	}
+	if len(sw.windows) == 0 {
+		return ""
+	}
+	for _, tw := range sw.windows {
+		if tw.contains(t) {
+			return ""
+		}
+	}
+	specs := make([]string, len(sw.windows))
+	for i, tw := range sw.windows {
+		specs[i] = tw.spec
+	}
+	return fmt.Sprintf("outside sync windows %s", strings.Join(specs, ", "))
+}
+
+// deferral returns why a job may not start at t and when it may,
+// the reason is empty if it may start at t
+func (sw *syncWindows) deferral(t time.Time) (time.Time, string) {
+	reason := sw.blocked(t)
+	if reason == "" {
+		return t, ""
+	}
+	next := t.In(sw.loc)
+	// the windows of a week and the periods once change a few hundred
+	// times at most before the job may start
+	for i := 0; i < 1000; i++ {
+		var change time.Time
+		for _, tw := range append(append([]timeWindow{}, sw.windows...), sw.blackouts...) {
+			c := tw.nextChange(next)
+			if !c.IsZero() && (change.IsZero() || c.Before(change)) {
+				change = c
+			}
+		}
+		if change.IsZero() {
+			break
+		}
+		next = change
+		if sw.blocked(next) == "" {
+			return next, reason
+		}
+	}
+	return t.Add(windowRecheckInterval), reason
+}
+
+// jobDeferral returns why the job may not start at t and when it may,
+// the reason is empty if it may start at t
+func jobDeferral(p mirrorProvider, t time.Time) (time.Time, string) {
+	if sw := p.Windows(); sw != nil {
+		return sw.deferral(t)
+	}
+	return t, ""
+}
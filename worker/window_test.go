@@ -0,0 +1,154 @@
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "github.com/tuna/tunasync/internal"
+)
+
+func TestSyncWindows(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	// Wed May 1 2024
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 5, day, hour, minute, 0, 0, loc)
+	}
+
+	Convey("Time windows should be parsed", t, func() {
+		tw, err := parseTimeWindow("Mon-Fri 01:00-07:00", loc)
+		So(err, ShouldBeNil)
+		So(tw.contains(at(1, 1, 0)), ShouldBeTrue)
+		So(tw.contains(at(1, 7, 0)), ShouldBeFalse)
+		So(tw.contains(at(4, 3, 0)), ShouldBeFalse) // Saturday
+
+		tw, err = parseTimeWindow("Sat,Sun 22:00-06:00", loc)
+		So(err, ShouldBeNil)
+		So(tw.contains(at(4, 23, 0)), ShouldBeTrue)
+		So(tw.contains(at(6, 5, 59)), ShouldBeTrue) // Monday morning
+		So(tw.contains(at(3, 23, 0)), ShouldBeFalse)
+
+		tw, err = parseTimeWindow("2024-05-01T12:00/2024-05-02T12:00", loc)
+		So(err, ShouldBeNil)
+		So(tw.contains(at(1, 12, 0)), ShouldBeTrue)
+		So(tw.contains(at(2, 12, 0)), ShouldBeFalse)
+
+		for _, spec := range []string{
+			"", "01:00", "1:00-7", "01:00-25:00", "Someday 01:00-02:00",
+			"Mon Tue 01:00-02:00", "2024-05-02T12:00/2024-05-01T12:00",
+		} {
+			_, err := parseTimeWindow(spec, loc)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Jobs should be deferred until the windows open", t, func() {
+		sw, err := newSyncWindows([]string{"01:00-07:00"}, []string{"Thu 02:00-04:00"}, loc)
+		So(err, ShouldBeNil)
+
+		until, reason := sw.deferral(at(1, 3, 0))
+		So(reason, ShouldBeEmpty)
+		So(until, ShouldEqual, at(1, 3, 0))
+
+		until, reason = sw.deferral(at(1, 12, 0))
+		So(reason, ShouldEqual, "outside sync windows 01:00-07:00")
+		So(until.Equal(at(2, 1, 0)), ShouldBeTrue)
+
+		until, reason = sw.deferral(at(2, 2, 30))
+		So(reason, ShouldEqual, "in blackout Thu 02:00-04:00")
+		So(until.Equal(at(2, 4, 0)), ShouldBeTrue)
+
+		// evaluated in the time zone of the windows
+		until, reason = sw.deferral(at(1, 12, 0).UTC())
+		So(reason, ShouldNotBeEmpty)
+		So(until.Equal(at(2, 1, 0)), ShouldBeTrue)
+
+		// a blackout over the whole window
+		sw, err = newSyncWindows([]string{"01:00-07:00"},
+			[]string{"2024-05-01T12:00/2024-05-03T00:00"}, loc)
+		So(err, ShouldBeNil)
+		until, reason = sw.deferral(at(1, 12, 0))
+		So(reason, ShouldStartWith, "in blackout")
+		So(until.Equal(at(3, 1, 0)), ShouldBeTrue)
+
+		// windows never open
+		sw, err = newSyncWindows([]string{"2024-01-01T00:00/2024-01-02T00:00"}, nil, loc)
+		So(err, ShouldBeNil)
+		until, reason = sw.deferral(at(1, 12, 0))
+		So(reason, ShouldNotBeEmpty)
+		So(until.Equal(at(1, 12, 0).Add(windowRecheckInterval)), ShouldBeTrue)
+	})
+}
+
+func TestManualStartInWindows(t *testing.T) {
+	Convey("Manual starts should wait for the windows unless forced", t, func() {
+		tmpDir, err := os.MkdirTemp("", "tunasync")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpDir)
+
+		schedules := make(chan MirrorSchedules, 1)
+		manager := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			var sch MirrorSchedules
+			if json.NewDecoder(r.Body).Decode(&sch) == nil && r.URL.Path == "/workers/dut/schedules" {
+				schedules <- sch
+			}
+			rw.Write([]byte("{}"))
+		}))
+		defer manager.Close()
+
+		provider, err := newCmdProvider(cmdConfig{
+			name:       "tuna-window",
+			command:    "true",
+			workingDir: tmpDir,
+			logDir:     tmpDir,
+			logFile:    filepath.Join(tmpDir, "latest.log"),
+			interval:   600 * time.Second,
+		})
+		So(err, ShouldBeNil)
+		now := time.Now()
+		sw, err := newSyncWindows(nil, []string{
+			now.Add(-time.Hour).Format(time.RFC3339) + "/" + now.Add(time.Hour).Format(time.RFC3339),
+		}, time.Local)
+		So(err, ShouldBeNil)
+		provider.SetWindows(sw)
+		job := newMirrorJob(provider)
+
+		cfg := &Config{}
+		cfg.Global.Name = "dut"
+		cfg.Manager.APIBase = manager.URL
+		httpClient, err := CreateHTTPClient("")
+		So(err, ShouldBeNil)
+		w := &Worker{
+			cfg:        cfg,
+			jobs:       map[string]*mirrorJob{job.Name(): job},
+			schedule:   newScheduleQueue(),
+			httpClient: httpClient,
+			tokens:     make(map[string]string),
+		}
+
+		code, msg := w.handleCmd(WorkerCmd{Cmd: CmdStart, MirrorID: job.Name()})
+		So(code, ShouldEqual, http.StatusOK)
+		So(msg, ShouldStartWith, "Deferred until ")
+		So(len(job.ctrlChan), ShouldEqual, 0)
+
+		sch := <-schedules
+		So(sch.Schedules, ShouldHaveLength, 1)
+		So(sch.Schedules[0].Deferred, ShouldStartWith, "in blackout ")
+		So(sch.Schedules[0].NextSchedule.Unix(), ShouldEqual, now.Add(time.Hour).Unix())
+
+		code, msg = w.handleCmd(WorkerCmd{Cmd: CmdStart, MirrorID: job.Name(),
+			Options: map[string]bool{"force": true}})
+		So(code, ShouldEqual, http.StatusOK)
+		So(msg, ShouldEqual, "OK")
+		So(<-job.ctrlChan, ShouldEqual, jobForceStart)
+		So(w.schedule.GetJobs(), ShouldBeEmpty)
+	})
+}
@@ -1,10 +1,14 @@
 package worker
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -17,14 +21,21 @@ type Worker struct {
 	L    sync.Mutex
 	cfg  *Config
 	jobs map[string]*mirrorJob
+	// a copy of jobs replaced whenever they change, read by the
+	// metrics without waiting for L
+	jobsView atomic.Pointer[map[string]*mirrorJob]
 
 	managerChan chan jobMessage
-	semaphore   chan empty
+	admission   *admissionQueue
 	exit        chan empty
 
 	schedule   *scheduleQueue
 	httpEngine *gin.Engine
 	httpClient *http.Client
+
+	// session tokens issued by the managers, keyed by API base
+	tokenLock sync.RWMutex
+	tokens    map[string]string
 }
 
 // NewTUNASyncWorker creates a worker
@@ -39,10 +50,11 @@ func NewTUNASyncWorker(cfg *Config) *Worker {
 		jobs: make(map[string]*mirrorJob),
 
 		managerChan: make(chan jobMessage, 32),
-		semaphore:   make(chan empty, cfg.Global.Concurrent),
+		admission:   newAdmissionQueue(cfg.Global.Concurrent),
 		exit:        make(chan empty),
 
 		schedule: newScheduleQueue(),
+		tokens:   make(map[string]string),
 	}
 
 	if cfg.Manager.CACert != "" {
@@ -54,6 +66,13 @@ func NewTUNASyncWorker(cfg *Config) *Worker {
 		w.httpClient = httpClient
 	}
 
+	groupLimits, err := cfg.concurrencyGroupLimits()
+	if err != nil {
+		logger.Errorf("Error initializing concurrency groups: %s", err.Error())
+		return nil
+	}
+	w.admission.setGroupLimits(groupLimits)
+
 	if cfg.Cgroup.Enable {
 		if err := initCgroup(&cfg.Cgroup); err != nil {
 			logger.Errorf("Error initializing Cgroup: %s", err.Error())
@@ -68,7 +87,17 @@ func NewTUNASyncWorker(cfg *Config) *Worker {
 // Run runs worker forever
 func (w *Worker) Run() {
 	w.registerWorker()
-	go w.runHTTPServer()
+	// the http server is optional when the commands are pulled
+	if !w.cfg.Manager.PullCommands || w.cfg.Server.Port != 0 {
+		go w.runHTTPServer()
+	}
+	if w.cfg.Manager.PullCommands {
+		for _, root := range w.cfg.Manager.APIBaseList() {
+			go w.runCmdPoller(root)
+		}
+	}
+	go w.runHeartbeat()
+	go w.runWaitingReporter()
 	w.runSchedule()
 }
 
@@ -87,6 +116,21 @@ func (w *Worker) Halt() {
 	close(w.exit)
 }
 
+// ReloadConcurrencyGroups applies the new limits of the concurrency
+// groups, before the mirrors of the new groups are reloaded
+func (w *Worker) ReloadConcurrencyGroups(groups []concurrencyGroupConfig) error {
+	w.L.Lock()
+	defer w.L.Unlock()
+	limits, err := (&Config{ConcurrencyGroups: groups}).concurrencyGroupLimits()
+	if err != nil {
+		return err
+	}
+	w.cfg.ConcurrencyGroups = groups
+	w.admission.setGroupLimits(limits)
+	logger.Info("Reloaded concurrency groups")
+	return nil
+}
+
 // ReloadMirrorConfig refresh the providers and jobs
 // from new mirror configs
 // TODO: deleted job should be removed from manager-side mirror list
@@ -113,6 +157,7 @@ func (w *Worker) ReloadMirrorConfig(newMirrors []mirrorConfig) {
 		case diffDelete:
 			w.disableJob(job)
 			delete(w.jobs, name)
+			forgetJobMetrics(name)
 			logger.Noticef("Deleted job %s", name)
 		case diffModify:
 			jobState := job.State()
@@ -129,10 +174,10 @@ func (w *Worker) ReloadMirrorConfig(newMirrors []mirrorConfig) {
 				job.SetState(stateDisabled)
 			} else if jobState == statePaused {
 				job.SetState(statePaused)
-				go job.Run(w.managerChan, w.semaphore)
+				go job.Run(w.managerChan, w.admission)
 			} else {
 				job.SetState(stateNone)
-				go job.Run(w.managerChan, w.semaphore)
+				go job.Run(w.managerChan, w.admission)
 				w.schedule.AddJob(time.Now(), job)
 			}
 			logger.Noticef("Reloaded job %s", name)
@@ -148,12 +193,13 @@ func (w *Worker) ReloadMirrorConfig(newMirrors []mirrorConfig) {
 		w.jobs[provider.Name()] = job
 
 		job.SetState(stateNone)
-		go job.Run(w.managerChan, w.semaphore)
+		go job.Run(w.managerChan, w.admission)
 		w.schedule.AddJob(time.Now(), job)
 		logger.Noticef("New job %s", job.Name())
 	}
 
 	w.cfg.Mirrors = newMirrors
+	w.updateJobsView()
 }
 
 func (w *Worker) initJobs() {
@@ -162,6 +208,16 @@ func (w *Worker) initJobs() {
 		provider := newMirrorProvider(mirror, w.cfg)
 		w.jobs[provider.Name()] = newMirrorJob(provider)
 	}
+	w.updateJobsView()
+}
+
+// updateJobsView copies jobs to jobsView, with L held
+func (w *Worker) updateJobsView() {
+	view := make(map[string]*mirrorJob, len(w.jobs))
+	for name, job := range w.jobs {
+		view[name] = job
+	}
+	w.jobsView.Store(&view)
 }
 
 func (w *Worker) disableJob(job *mirrorJob) {
@@ -178,76 +234,103 @@ func (w *Worker) makeHTTPServer() {
 	s.Use(gin.Recovery())
 
 	s.POST("/", func(c *gin.Context) {
-		w.L.Lock()
-		defer w.L.Unlock()
-
 		var cmd WorkerCmd
 
-		if err := c.BindJSON(&cmd); err != nil {
+		body, err := c.GetRawData()
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid request"})
 			return
 		}
-
-		logger.Noticef("Received command: %v", cmd)
-
-		if cmd.MirrorID == "" {
-			// worker-level commands
-			switch cmd.Cmd {
-			case CmdReload:
-				// send myself a SIGHUP
-				pid := os.Getpid()
-				syscall.Kill(pid, syscall.SIGHUP)
-			default:
-				c.JSON(http.StatusNotAcceptable, gin.H{"msg": "Invalid Command"})
-				return
-			}
+		if !w.verifyRequest(c, body) {
+			return
 		}
-
-		// job level comands
-		job, ok := w.jobs[cmd.MirrorID]
-		if !ok {
-			c.JSON(http.StatusNotFound, gin.H{"msg": fmt.Sprintf("Mirror ``%s'' not found", cmd.MirrorID)})
+		if err := json.Unmarshal(body, &cmd); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "Invalid request"})
 			return
 		}
 
-		// No matter what command, the existing job
-		// schedule should be flushed
-		w.schedule.Remove(job.Name())
+		code, msg := w.handleCmd(cmd)
+		c.JSON(code, gin.H{"msg": msg})
+	})
 
-		// if job disabled, start them first
-		switch cmd.Cmd {
-		case CmdStart, CmdRestart:
-			if job.State() == stateDisabled {
-				go job.Run(w.managerChan, w.semaphore)
-			}
-		}
+	// jobs waiting for a slot, and logs of the jobs
+	s.GET("/jobs/waiting", w.listWaitingJobs)
+	s.GET("/jobs/:job/logs", w.listJobLogs)
+	s.GET("/jobs/:job/log", w.serveJobLog)
+
+	// prometheus metrics
+	s.GET("/metrics", w.metricsHandler())
+	w.httpEngine = s
+}
+
+// handleCmd carries out a command from the manager, and returns
+// the status code and the message of the reply
+func (w *Worker) handleCmd(cmd WorkerCmd) (int, string) {
+	w.L.Lock()
+	defer w.L.Unlock()
+
+	logger.Noticef("Received command: %v", cmd)
+
+	if cmd.MirrorID == "" {
+		// worker-level commands
 		switch cmd.Cmd {
-		case CmdStart:
-			if cmd.Options["force"] {
-				job.ctrlChan <- jobForceStart
-			} else {
-				job.ctrlChan <- jobStart
-			}
-		case CmdRestart:
-			job.ctrlChan <- jobRestart
-		case CmdStop:
-			// if job is disabled, no goroutine would be there
-			// receiving this signal
-			if job.State() != stateDisabled {
-				job.ctrlChan <- jobStop
-			}
-		case CmdDisable:
-			w.disableJob(job)
-		case CmdPing:
-			// empty
+		case CmdReload:
+			// send myself a SIGHUP
+			pid := os.Getpid()
+			syscall.Kill(pid, syscall.SIGHUP)
+			return http.StatusOK, "OK"
 		default:
-			c.JSON(http.StatusNotAcceptable, gin.H{"msg": "Invalid Command"})
-			return
+			return http.StatusNotAcceptable, "Invalid Command"
 		}
+	}
 
-		c.JSON(http.StatusOK, gin.H{"msg": "OK"})
-	})
-	w.httpEngine = s
+	// job level comands
+	job, ok := w.jobs[cmd.MirrorID]
+	if !ok {
+		return http.StatusNotFound, fmt.Sprintf("Mirror ``%s'' not found", cmd.MirrorID)
+	}
+
+	// No matter what command, the existing job
+	// schedule should be flushed
+	w.schedule.Remove(job.Name())
+
+	// if job disabled, start them first
+	switch cmd.Cmd {
+	case CmdStart, CmdRestart:
+		if job.State() == stateDisabled {
+			go job.Run(w.managerChan, w.admission)
+		}
+	}
+	switch cmd.Cmd {
+	case CmdStart:
+		if cmd.Options["force"] {
+			job.ctrlChan <- jobForceStart
+			break
+		}
+		// only forced to start outside the sync windows
+		if until, reason := jobDeferral(job.provider, time.Now()); reason != "" {
+			w.schedule.DeferJob(until, job, reason)
+			go w.updateSchedInfo(w.schedule.GetJobs())
+			return http.StatusOK, fmt.Sprintf("Deferred until %s: %s",
+				until.Format(time.RFC3339), reason)
+		}
+		job.ctrlChan <- jobStart
+	case CmdRestart:
+		job.ctrlChan <- jobRestart
+	case CmdStop:
+		// if job is disabled, no goroutine would be there
+		// receiving this signal
+		if job.State() != stateDisabled {
+			job.ctrlChan <- jobStop
+		}
+	case CmdDisable:
+		w.disableJob(job)
+	case CmdPing:
+		// empty
+	default:
+		return http.StatusNotAcceptable, "Invalid Command"
+	}
+	return http.StatusOK, "OK"
 }
 
 func (w *Worker) runHTTPServer() {
@@ -291,12 +374,12 @@ func (w *Worker) runSchedule() {
 				continue
 			case Paused:
 				job.SetState(statePaused)
-				go job.Run(w.managerChan, w.semaphore)
+				go job.Run(w.managerChan, w.admission)
 				continue
 			default:
 				job.SetState(stateNone)
-				go job.Run(w.managerChan, w.semaphore)
-				stime := m.LastUpdate.Add(job.provider.Interval())
+				go job.Run(w.managerChan, w.admission)
+				stime := nextSchedule(job.provider, m.LastUpdate)
 				logger.Debugf("Scheduling job %s @%s", job.Name(), stime.Format("2006-01-02 15:04:05"))
 				w.schedule.AddJob(stime, job)
 			}
@@ -308,7 +391,7 @@ func (w *Worker) runSchedule() {
 	for name := range unset {
 		job := w.jobs[name]
 		job.SetState(stateNone)
-		go job.Run(w.managerChan, w.semaphore)
+		go job.Run(w.managerChan, w.admission)
 		w.schedule.AddJob(time.Now(), job)
 	}
 
@@ -344,7 +427,7 @@ func (w *Worker) runSchedule() {
 			// only successful or the final failure msg
 			// can trigger scheduling
 			if jobMsg.schedule {
-				schedTime := time.Now().Add(job.provider.Interval())
+				schedTime := nextSchedule(job.provider, time.Now())
 				logger.Noticef(
 					"Next scheduled time for %s: %s",
 					job.Name(),
@@ -358,9 +441,21 @@ func (w *Worker) runSchedule() {
 
 		case <-tick:
 			// check schedule every 5 seconds
-			if job := w.schedule.Pop(); job != nil {
-				job.ctrlChan <- jobStart
+			job, due := w.schedule.PopDue()
+			if job == nil {
+				continue
+			}
+			// wait for the sync windows to open
+			if until, reason := jobDeferral(job.provider, time.Now()); reason != "" {
+				logger.Noticef("Deferred job %s until %s: %s", job.Name(),
+					until.Format("2006-01-02 15:04:05"), reason)
+				w.schedule.DeferJob(until, job, reason)
+				schedInfo = w.schedule.GetJobs()
+				w.updateSchedInfo(schedInfo)
+				continue
 			}
+			job.SetDue(due)
+			job.ctrlChan <- jobStart
 		case <-w.exit:
 			// flush status update messages
 			w.L.Lock()
@@ -389,8 +484,12 @@ func (w *Worker) Name() string {
 	return w.cfg.Global.Name
 }
 
-// URL returns the url to http server of the worker
+// URL returns the url to http server of the worker, which
+// is empty if the worker pulls the commands without it
 func (w *Worker) URL() string {
+	if w.cfg.Manager.PullCommands && w.cfg.Server.Port == 0 {
+		return ""
+	}
 	proto := "https"
 	if w.cfg.Server.SSLCert == "" && w.cfg.Server.SSLKey == "" {
 		proto = "http"
@@ -401,26 +500,123 @@ func (w *Worker) URL() string {
 
 func (w *Worker) registerWorker() {
 	msg := WorkerStatus{
-		ID:  w.Name(),
-		URL: w.URL(),
+		ID:   w.Name(),
+		URL:  w.URL(),
+		Pull: w.cfg.Manager.PullCommands,
 	}
 
 	for _, root := range w.cfg.Manager.APIBaseList() {
-		url := fmt.Sprintf("%s/workers", root)
-		logger.Debugf("register on manager url: %s", url)
-		for retry := 10; retry > 0; {
-			if _, err := PostJSON(url, msg, w.httpClient); err != nil {
-				logger.Errorf("Failed to register worker")
-				retry--
-				if retry > 0 {
-					time.Sleep(1 * time.Second)
-					logger.Noticef("Retrying... (%d)", retry)
-				}
-			} else {
-				break
+		if !w.registerOn(root, msg, 10) {
+			// the requests are rejected until a session token is
+			// issued, so keep trying
+			go w.keepRegistering(root, msg)
+		}
+	}
+}
+
+// registerOn registers the worker on the manager at root, and tells
+// whether it succeeds within the retries
+func (w *Worker) registerOn(root string, msg WorkerStatus, retries int) bool {
+	url := fmt.Sprintf("%s/workers", root)
+	logger.Debugf("register on manager url: %s", url)
+	for retry := retries; retry > 0; {
+		registered, err := w.postRegistration(url, msg)
+		if err != nil {
+			reportFailures.WithLabelValues(root, "register").Inc()
+			logger.Errorf("Failed to register worker: %s", err.Error())
+			retry--
+			if retry > 0 {
+				time.Sleep(1 * time.Second)
+				logger.Noticef("Retrying... (%d)", retry)
+			}
+			continue
+		}
+		if registered.Token == "" && w.cfg.Manager.Token != "" {
+			logger.Warningf("Manager %s issued no session token, requests from it are rejected", root)
+		}
+		w.tokenLock.Lock()
+		w.tokens[root] = registered.Token
+		w.tokenLock.Unlock()
+		return true
+	}
+	return false
+}
+
+// keepRegistering retries to register on the manager at root in
+// the background until it succeeds or the worker exits
+func (w *Worker) keepRegistering(root string, msg WorkerStatus) {
+	ticker := time.NewTicker(registerRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if w.registerOn(root, msg, 1) {
+				logger.Noticef("Registered on manager %s", root)
+				return
 			}
+		case <-w.exit:
+			return
+		}
+	}
+}
+
+func (w *Worker) postRegistration(url string, msg WorkerStatus) (registered WorkerStatus, err error) {
+	resp, err := PostJSONWithToken(url, msg, w.cfg.Manager.Token, w.httpClient)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("HTTP status code %d: %s", resp.StatusCode, body)
+		return
+	}
+	err = json.Unmarshal(body, &registered)
+	return
+}
+
+// sessionToken returns the session token issued by the manager at root
+func (w *Worker) sessionToken(root string) string {
+	w.tokenLock.RLock()
+	defer w.tokenLock.RUnlock()
+	return w.tokens[root]
+}
+
+// sessionTokens returns all the non-empty session tokens, with
+// which the commands from the managers are signed
+func (w *Worker) sessionTokens() (tokens []string) {
+	w.tokenLock.RLock()
+	defer w.tokenLock.RUnlock()
+	for _, token := range w.tokens {
+		if token != "" {
+			tokens = append(tokens, token)
 		}
 	}
+	return
+}
+
+// verifyRequest checks the signature of a request from the managers,
+// and responds if it is invalid. Once a secret to register is set,
+// the requests are rejected until the managers issue session tokens.
+func (w *Worker) verifyRequest(c *gin.Context, body []byte) bool {
+	secrets := w.sessionTokens()
+	if len(secrets) == 0 {
+		if w.cfg.Manager.Token == "" {
+			return true
+		}
+		logger.Warningf("Rejected request from %s: not registered on the managers", c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
+		return false
+	}
+	if err := VerifyRequest(c.Request, body, secrets, cmdSignatureMaxAge); err != nil {
+		logger.Warningf("Rejected request from %s: %s", c.ClientIP(), err.Error())
+		c.JSON(http.StatusUnauthorized, gin.H{"msg": "Unauthorized"})
+		return false
+	}
+	return true
 }
 
 func (w *Worker) updateStatus(job *mirrorJob, jobMsg jobMessage) {
@@ -433,6 +629,7 @@ func (w *Worker) updateStatus(job *mirrorJob, jobMsg jobMessage) {
 		Upstream: p.Upstream(),
 		Size:     "unknown",
 		ErrorMsg: jobMsg.msg,
+		Interval: int(syncInterval(p).Minutes()),
 	}
 
 	// Certain Providers (rsync for example) may know the size of mirror,
@@ -446,7 +643,7 @@ func (w *Worker) updateStatus(job *mirrorJob, jobMsg jobMessage) {
 			"%s/workers/%s/jobs/%s", root, w.Name(), jobMsg.name,
 		)
 		logger.Debugf("reporting on manager url: %s", url)
-		if _, err := PostJSON(url, smsg, w.httpClient); err != nil {
+		if err := w.postReport(root, url, smsg, "status"); err != nil {
 			logger.Errorf("Failed to update mirror(%s) status: %s", jobMsg.name, err.Error())
 		}
 	}
@@ -458,6 +655,7 @@ func (w *Worker) updateSchedInfo(schedInfo []jobScheduleInfo) {
 		s = append(s, MirrorSchedule{
 			MirrorName:   sched.jobName,
 			NextSchedule: sched.nextScheduled,
+			Deferred:     sched.deferred,
 		})
 	}
 	msg := MirrorSchedules{Schedules: s}
@@ -467,12 +665,121 @@ func (w *Worker) updateSchedInfo(schedInfo []jobScheduleInfo) {
 			"%s/workers/%s/schedules", root, w.Name(),
 		)
 		logger.Debugf("reporting on manager url: %s", url)
-		if _, err := PostJSON(url, msg, w.httpClient); err != nil {
+		if err := w.postReport(root, url, msg, "schedule"); err != nil {
 			logger.Errorf("Failed to upload schedules: %s", err.Error())
 		}
 	}
 }
 
+// postReport posts msg to the manager at root, failures are
+// counted by the type of the report
+func (w *Worker) postReport(root, url string, msg interface{}, reportType string) error {
+	resp, err := PostJSONWithToken(url, msg, w.sessionToken(root), w.httpClient)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("HTTP status code %d", resp.StatusCode)
+		}
+	}
+	if err != nil {
+		reportFailures.WithLabelValues(root, reportType).Inc()
+	}
+	return err
+}
+
+// runHeartbeat keeps the worker online in the managers' view,
+// even if no job status changes for a long time
+func (w *Worker) runHeartbeat() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.sendHeartbeat()
+		case <-w.exit:
+			return
+		}
+	}
+}
+
+func (w *Worker) sendHeartbeat() {
+	for _, root := range w.cfg.Manager.APIBaseList() {
+		url := fmt.Sprintf("%s/workers/%s/heartbeat", root, w.Name())
+		if err := w.postReport(root, url, empty{}, "heartbeat"); err != nil {
+			logger.Errorf("Failed to send heartbeat to %s: %s", root, err.Error())
+		}
+	}
+}
+
+// runCmdPoller keeps polling the manager at root for the commands,
+// and replies them as if they were posted to the worker
+func (w *Worker) runCmdPoller(root string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-w.exit
+		cancel()
+	}()
+
+	client := http.Client{}
+	if w.httpClient != nil {
+		client = *w.httpClient
+	}
+	client.Timeout = cmdPollWait + 10*time.Second
+	url := fmt.Sprintf("%s/workers/%s/cmd?wait=%d", root, w.Name(), int(cmdPollWait.Seconds()))
+
+	for {
+		cmd, err := w.pollCmd(ctx, &client, root, url)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			reportFailures.WithLabelValues(root, "poll").Inc()
+			logger.Errorf("Failed to poll %s for commands: %s", root, err.Error())
+			select {
+			case <-time.After(cmdPollRetryDelay):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		if cmd == nil {
+			continue
+		}
+
+		code, msg := w.handleCmd(cmd.Cmd)
+		replyURL := fmt.Sprintf("%s/workers/%s/cmd/%s", root, w.Name(), cmd.ID)
+		if err := w.postReport(root, replyURL, CmdReply{Code: code, Message: msg}, "reply"); err != nil {
+			logger.Errorf("Failed to reply command %s: %s", cmd.Cmd, err.Error())
+		}
+	}
+}
+
+// pollCmd waits for a command from the manager, which is nil
+// if none is sent during the poll
+func (w *Worker) pollCmd(ctx context.Context, client *http.Client, root, url string) (*PulledCmd, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	SetBearerToken(req, w.sessionToken(root))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusOK:
+		var cmd PulledCmd
+		if err := json.NewDecoder(resp.Body).Decode(&cmd); err != nil {
+			return nil, err
+		}
+		return &cmd, nil
+	}
+	return nil, fmt.Errorf("HTTP status code %d", resp.StatusCode)
+}
+
 func (w *Worker) fetchJobStatus() []MirrorStatus {
 	var mirrorList []MirrorStatus
 	apiBase := w.cfg.Manager.APIBaseList()[0]
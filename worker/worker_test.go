@@ -1,7 +1,9 @@
 package worker
 
 import (
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"testing"
 	"time"
@@ -16,6 +18,14 @@ type workTestFunc func(*Worker)
 var managerPort = 5001
 var workerPort = 5002
 
+const (
+	mockWorkerSecret = "worker_secret"
+	mockSessionToken = "session_token"
+)
+
+// the commands the mock manager hands to the workers polling it
+var pulledCmds = make(chan PulledCmd, 1)
+
 func makeMockManagerServer(recvData chan interface{}) *gin.Engine {
 	r := gin.Default()
 	r.GET("/ping", func(c *gin.Context) {
@@ -24,6 +34,9 @@ func makeMockManagerServer(recvData chan interface{}) *gin.Engine {
 	r.POST("/workers", func(c *gin.Context) {
 		var _worker WorkerStatus
 		c.BindJSON(&_worker)
+		if BearerToken(c.Request) == mockWorkerSecret {
+			_worker.Token = mockSessionToken
+		}
 		_worker.LastOnline = time.Now()
 		_worker.LastRegister = time.Now()
 		recvData <- _worker
@@ -41,6 +54,20 @@ func makeMockManagerServer(recvData chan interface{}) *gin.Engine {
 		recvData <- status
 		c.JSON(http.StatusOK, status)
 	})
+	r.GET("/workers/dut/cmd", func(c *gin.Context) {
+		select {
+		case cmd := <-pulledCmds:
+			c.JSON(http.StatusOK, cmd)
+		case <-time.After(200 * time.Millisecond):
+			c.Status(http.StatusNoContent)
+		}
+	})
+	r.POST("/workers/dut/cmd/:cmd", func(c *gin.Context) {
+		var reply CmdReply
+		c.BindJSON(&reply)
+		recvData <- reply
+		c.JSON(http.StatusOK, empty{})
+	})
 	r.GET("/workers/dut/jobs", func(c *gin.Context) {
 		mirrorStatusList := []MirrorStatus{}
 		c.JSON(http.StatusOK, mirrorStatusList)
@@ -145,6 +172,107 @@ func TestWorker(t *testing.T) {
 
 			startWorkerThenStop(&workerCfg, dummyTester)
 		})
+		Convey("with token authentication", func(ctx C) {
+			workerCfg.Manager.Token = mockWorkerSecret
+			dummyTester := func(*Worker) {
+				registered := false
+				for {
+					select {
+					case data := <-recvDataChan:
+						if reg, ok := data.(WorkerStatus); ok {
+							registered = true
+							time.Sleep(500 * time.Millisecond)
+							cmd := WorkerCmd{Cmd: CmdStart, MirrorID: "foobar"}
+							resp, err := PostJSON(reg.URL, cmd, httpClient)
+							So(err, ShouldBeNil)
+							resp.Body.Close()
+							So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+							resp, err = PostSignedJSON(reg.URL, cmd, "invalid_token", httpClient)
+							So(err, ShouldBeNil)
+							resp.Body.Close()
+							So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+							resp, err = PostSignedJSON(reg.URL, cmd, mockSessionToken, httpClient)
+							So(err, ShouldBeNil)
+							resp.Body.Close()
+							So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+						}
+					case <-time.After(2 * time.Second):
+						So(registered, ShouldBeTrue)
+						return
+					}
+				}
+			}
+
+			startWorkerThenStop(&workerCfg, dummyTester)
+		})
+		Convey("rejecting the requests before registered", func(ctx C) {
+			workerCfg.Manager.Token = mockWorkerSecret
+			w := NewTUNASyncWorker(&workerCfg)
+			So(w, ShouldNotBeNil)
+			server := httptest.NewServer(w.httpEngine)
+			defer server.Close()
+
+			resp, err := PostJSON(server.URL, WorkerCmd{Cmd: CmdStart, MirrorID: "foobar"}, httpClient)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			resp, err = http.Get(server.URL + "/jobs/waiting")
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			// registered on the manager afterwards
+			registered := make(chan bool, 1)
+			go func() {
+				registered <- w.registerOn(workerCfg.Manager.APIBase, WorkerStatus{ID: "dut"}, 1)
+			}()
+			So((<-recvDataChan).(WorkerStatus).ID, ShouldEqual, "dut")
+			So(<-registered, ShouldBeTrue)
+			resp, err = PostSignedJSON(server.URL, WorkerCmd{Cmd: CmdStart, MirrorID: "foobar"},
+				mockSessionToken, httpClient)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("pulling the commands", func(ctx C) {
+			workerCfg.Manager.PullCommands = true
+			workerCfg.Server.Port = 0
+			workerCfg.Mirrors = []mirrorConfig{
+				{
+					Name:     "job-ls",
+					Provider: provCommand,
+					Command:  "ls",
+				},
+			}
+			dummyTester := func(*Worker) {
+				var replies []CmdReply
+				for {
+					select {
+					case data := <-recvDataChan:
+						if reg, ok := data.(WorkerStatus); ok {
+							So(reg.Pull, ShouldBeTrue)
+							So(reg.URL, ShouldEqual, "")
+							pulledCmds <- PulledCmd{ID: "1", Cmd: WorkerCmd{Cmd: CmdStart, MirrorID: "foobar"}}
+						} else if reply, ok := data.(CmdReply); ok {
+							replies = append(replies, reply)
+							if len(replies) == 1 {
+								pulledCmds <- PulledCmd{ID: "2", Cmd: WorkerCmd{Cmd: CmdPing, MirrorID: "job-ls"}}
+							}
+						}
+					case <-time.After(2 * time.Second):
+						So(replies, ShouldHaveLength, 2)
+						So(replies[0].Code, ShouldEqual, http.StatusNotFound)
+						So(replies[1], ShouldResemble, CmdReply{Code: http.StatusOK, Message: "OK"})
+						return
+					}
+				}
+			}
+
+			startWorkerThenStop(&workerCfg, dummyTester)
+		})
 		Convey("with one job", func(ctx C) {
 			workerCfg.Mirrors = []mirrorConfig{
 				{
@@ -154,7 +282,7 @@ func TestWorker(t *testing.T) {
 				},
 			}
 
-			dummyTester := func(*Worker) {
+			dummyTester := func(w *Worker) {
 				url := ""
 				jobRunning := false
 				lastStatus := SyncStatus(None)
@@ -179,12 +307,30 @@ func TestWorker(t *testing.T) {
 							logger.Noticef("Job %s status %s", status.Name, status.Status.String())
 							jobRunning = status.Status == PreSyncing || status.Status == Syncing
 							So(status.Status, ShouldNotEqual, Failed)
+							So(status.Interval, ShouldEqual, workerCfg.Global.Interval)
 							lastStatus = status.Status
 						}
 					case <-time.After(2 * time.Second):
 						So(url, ShouldNotEqual, "")
 						So(jobRunning, ShouldBeFalse)
 						So(lastStatus, ShouldEqual, Success)
+
+						// the scrapes do not wait for the jobs locked
+						w.L.Lock()
+						resp, err := http.Get(url + "metrics")
+						w.L.Unlock()
+						So(err, ShouldBeNil)
+						defer resp.Body.Close()
+						So(resp.StatusCode, ShouldEqual, http.StatusOK)
+						body, err := io.ReadAll(resp.Body)
+						So(err, ShouldBeNil)
+						metrics := string(body)
+						So(metrics, ShouldContainSubstring,
+							`tunasync_worker_job_run_duration_seconds_count{mirror="job-ls",result="success"}`)
+						So(metrics, ShouldContainSubstring, `tunasync_worker_job_last_exit_code{mirror="job-ls"} 0`)
+						So(metrics, ShouldContainSubstring, `tunasync_worker_job_state{mirror="job-ls",state="ready"} 1`)
+						So(metrics, ShouldContainSubstring, "tunasync_worker_concurrent_jobs 0")
+						So(metrics, ShouldContainSubstring, "tunasync_worker_concurrent_limit 2")
 						return
 					}
 				}